@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/urfave/cli/v2"
+)
+
+var exportCommand = &cli.Command{
+	Name:  "export",
+	Usage: "export books as CSV or JSON Lines",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "format", Usage: "output format, csv, jsonl or ndjson", Value: string(graph.ExportFormatJSONL), EnvVars: []string{"EXPORT_FORMAT"}},
+		&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "output file, - for stdout", Value: "-"},
+	},
+	Action: func(c *cli.Context) error {
+		format := c.String("format")
+		exportFormat := graph.ExportFormat(strings.ToLower(format))
+		if !exportFormat.IsValid() {
+			return fmt.Errorf("invalid format '%s'", format)
+		}
+
+		db, err := openDB(c)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = c.App.Writer
+		if output := c.String("output"); output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		count, err := graph.NewDataSource(db).ExportBooks(c.Context, bw, exportFormat)
+		if err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "exported %v books\n", count)
+		return nil
+	},
+}
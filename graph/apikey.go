@@ -0,0 +1,239 @@
+package graph
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+	"golang.org/x/crypto/argon2"
+)
+
+// APIKeyPrefix starts every key, followed by the lookup prefix and the secret separated by '_'.
+const APIKeyPrefix = "gq_"
+
+// APIKeyTouchInterval throttles the last used updates of a key under steady use.
+var APIKeyTouchInterval = time.Minute
+
+var scopePattern = regexp.MustCompile(`^[a-z][a-z0-9_:.-]*$`)
+
+var (
+	errInvalidAPIKey = errors.New("invalid API key")
+	errRevokedAPIKey = errors.New("API key has been revoked")
+	errExpiredAPIKey = errors.New("API key has expired")
+	errTenantAPIKey  = errors.New("API key belongs to another tenant")
+)
+
+// NewAPIKey builds a key for input, the returned key is the only time the secret is available,
+// only its Argon2id hash is kept on the model.
+func NewAPIKey(cfg *ConfigType, input model.NewAPIKey, now time.Time) (*model.APIKey, string, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, "", fmt.Errorf("api key name is required")
+	}
+	if len(input.Scopes) == 0 {
+		return nil, "", fmt.Errorf("api key needs at least one scope")
+	}
+	for _, s := range input.Scopes {
+		if !scopePattern.MatchString(s) {
+			return nil, "", fmt.Errorf("invalid scope '%s'", s)
+		}
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		return nil, "", fmt.Errorf("api key expiry must be in the future")
+	}
+	prefix := GenerateRandomString(8)
+	key := APIKeyPrefix + prefix + "_" + GenerateRandomString(32)
+	hash, err := HashAPIKey(cfg, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return &model.APIKey{
+		Name:      input.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+		UserID:    input.UserID,
+		CreatedAt: now,
+	}, key, nil
+}
+
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	return prefix, ok && prefix != ""
+}
+
+// HashAPIKey encodes the Argon2id parameters along with the salt and hash, so keys created before
+// a change of the configured parameters keep verifying.
+func HashAPIKey(cfg *ConfigType, key string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(key), salt, cfg.Argon2_Time, cfg.Argon2_Memory, cfg.Argon2_Thread, cfg.HashedPasswordLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, cfg.Argon2_Memory, cfg.Argon2_Time, cfg.Argon2_Thread,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func VerifyAPIKey(encoded string, key string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(key), salt, iterations, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, other) == 1
+}
+
+// APIKeyAuth authenticates requests carrying APIKeyHeader against the repository of the request,
+// so it goes inside Resolver.Middleware. Requests without the header pass through unauthenticated.
+// Argon2 is deliberately slow, a key that verified once is remembered by the sha256 of the key.
+type APIKeyAuth struct {
+	// Now is the clock used for expiry and last used, time.Now when nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	verified map[string][32]byte
+	// known holds the sums of verified keys, any other key is charged to the client address before
+	// it is verified
+	known map[[32]byte]bool
+}
+
+func (a *APIKeyAuth) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
+// Authenticate looks the key up in every tenant, the tenant of the key becomes the tenant of the
+// request.
+func (a *APIKeyAuth) Authenticate(ctx context.Context, repo Repository, key string) (*model.APIKey, error) {
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	apiKey, err := repo.APIKeyByPrefix(AnyTenant(ctx), prefix)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil || !a.verify(apiKey.Hash, key) {
+		return nil, errInvalidAPIKey
+	}
+	now := a.now()
+	if apiKey.RevokedAt != nil {
+		return nil, errRevokedAPIKey
+	}
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now) {
+		return nil, errExpiredAPIKey
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= APIKeyTouchInterval {
+		if err := repo.TouchAPIKey(AnyTenant(ctx), apiKey.ID, now); err != nil {
+			return nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+	return apiKey, nil
+}
+
+func (a *APIKeyAuth) verify(hash string, key string) bool {
+	sum := sha256.Sum256([]byte(key))
+	a.mu.Lock()
+	known, ok := a.verified[hash]
+	a.mu.Unlock()
+	if ok {
+		return subtle.ConstantTimeCompare(known[:], sum[:]) == 1
+	}
+	if !VerifyAPIKey(hash, key) {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.verified == nil {
+		a.verified = map[string][32]byte{}
+		a.known = map[[32]byte]bool{}
+	}
+	a.verified[hash] = sum
+	a.known[sum] = true
+	return true
+}
+
+func (a *APIKeyAuth) isKnown(key string) bool {
+	sum := sha256.Sum256([]byte(key))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.known[sum]
+}
+
+func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.isKnown(key) {
+			if ok, wait := chargeAuthentication(r.Context()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+					"errors": []map[string]interface{}{
+						{"message": "rate limit exceeded", "extensions": map[string]interface{}{"code": ErrorCode_RateLimited}},
+					},
+				})
+				return
+			}
+		}
+		repo, err := RepositoryOf(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		apiKey, err := a.Authenticate(r.Context(), repo, key)
+		if err == nil && r.Header.Get(TenantHeader) != "" && r.Header.Get(TenantHeader) != apiKey.TenantID {
+			apiKey, err = nil, errTenantAPIKey
+		}
+		if err != nil {
+			if !errors.Is(err, errInvalidAPIKey) && !errors.Is(err, errRevokedAPIKey) && !errors.Is(err, errExpiredAPIKey) && !errors.Is(err, errTenantAPIKey) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"errors": []map[string]interface{}{
+					{"message": err.Error(), "extensions": map[string]interface{}{"code": ErrorCode_Unauthenticated}},
+				},
+			})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithAPIKey(r.Context(), apiKey)))
+	})
+}
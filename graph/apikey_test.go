@@ -0,0 +1,191 @@
+package graph_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey(t *testing.T) {
+	db := OpenSQLite(t)
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+	if err := graph.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	now := time.Now()
+	auth := &graph.APIKeyAuth{Now: func() time.Time { return now }}
+	resolver := &graph.Resolver{Config: cfg, NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole}}))
+	c := client.New(resolver.Middleware(auth.Middleware(srv)))
+
+	adminKey, admin, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: "admin", Scopes: []string{graph.RoleAdmin}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.NewDataSource(db).CreateAPIKey(ctx, adminKey); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("hash", func(t *testing.T) {
+		hash, err := graph.HashAPIKey(cfg, "gq_abc_secret")
+		if assert.NoError(t, err) {
+			assert.True(t, graph.VerifyAPIKey(hash, "gq_abc_secret"))
+			assert.False(t, graph.VerifyAPIKey(hash, "gq_abc_other"))
+		}
+		assert.NotContains(t, adminKey.Hash, admin)
+	})
+
+	t.Run("admin role required", func(t *testing.T) {
+		var resp struct {
+			CreateAPIKey model.NewAPIKeyResult
+		}
+		err := c.Post(`mutation { createApiKey(input: {name: "batch", scopes: ["reader"]}) { key } }`, &resp)
+		assert.ErrorContains(t, err, `requires role 'admin'`)
+		assert.ErrorContains(t, err, `"code":"FORBIDDEN"`)
+	})
+
+	var batch struct {
+		CreateAPIKey struct {
+			Key    string
+			APIKey struct {
+				ID        int
+				Prefix    string
+				Scopes    []string
+				CreatedBy *string
+			}
+		}
+	}
+	t.Run("create key", func(t *testing.T) {
+		c.MustPost(`mutation {
+         createApiKey(input: {name: "batch", scopes: ["reader"]}) {
+            key
+            apiKey {
+               id
+               prefix
+               scopes
+               createdBy
+            }
+         }
+      }`, &batch, client.AddHeader(graph.APIKeyHeader, admin))
+		assert.Equal(t, 2, batch.CreateAPIKey.APIKey.ID)
+		assert.Equal(t, []string{"reader"}, batch.CreateAPIKey.APIKey.Scopes)
+		assert.Equal(t, graph.Of("apikey:admin"), batch.CreateAPIKey.APIKey.CreatedBy)
+		assert.Contains(t, batch.CreateAPIKey.Key, graph.APIKeyPrefix+batch.CreateAPIKey.APIKey.Prefix+"_")
+
+		var stored model.APIKey
+		db.WithContext(ctx).First(&stored, batch.CreateAPIKey.APIKey.ID)
+		assert.NotContains(t, stored.Hash, batch.CreateAPIKey.Key)
+		assert.Equal(t, []string{"reader"}, stored.Scopes)
+	})
+
+	t.Run("scopes are roles", func(t *testing.T) {
+		type APIKey struct {
+			ID         int
+			Name       string
+			LastUsedAt *string
+		}
+		var resp struct {
+			APIKeys []APIKey
+		}
+		err := c.Post(`{ apiKeys { id } }`, &resp, client.AddHeader(graph.APIKeyHeader, batch.CreateAPIKey.Key))
+		assert.ErrorContains(t, err, `requires role 'admin'`)
+
+		now = now.Add(time.Hour)
+		c.MustPost(`{ apiKeys { id name lastUsedAt } }`, &resp, client.AddHeader(graph.APIKeyHeader, admin))
+		if assert.Len(t, resp.APIKeys, 2) {
+			assert.Equal(t, "batch", resp.APIKeys[1].Name)
+			lastUsed := func(k APIKey) time.Time {
+				if k.LastUsedAt == nil {
+					return time.Time{}
+				}
+				at, _ := time.Parse(time.RFC3339Nano, *k.LastUsedAt)
+				return at
+			}
+			assert.WithinDuration(t, now.Add(-time.Hour), lastUsed(resp.APIKeys[1]), time.Second)
+			assert.WithinDuration(t, now, lastUsed(resp.APIKeys[0]), time.Second)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		var resp struct {
+			Books model.BookList
+		}
+		err := c.Post(`{ books { count } }`, &resp, client.AddHeader(graph.APIKeyHeader, admin+"x"))
+		assert.ErrorContains(t, err, "http 401")
+		assert.ErrorContains(t, err, `"code":"UNAUTHENTICATED"`)
+	})
+
+	t.Run("guessed keys are charged to the address", func(t *testing.T) {
+		limiter := graph.NewRateLimiter(1, 20)
+		limiter.Now = func() time.Time { return now }
+		h := limiter.Middleware(resolver.Middleware(auth.Middleware(srv)))
+		post := func(key string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "{ books { count } }"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(graph.APIKeyHeader, key)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+		assert.Equal(t, http.StatusOK, post(admin).Code)
+		for i, guess := range []string{admin + "x", admin + "y"} {
+			assert.Equal(t, http.StatusUnauthorized, post(guess).Code, i)
+		}
+		rec := post(admin + "z")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), `"code":"RATE_LIMITED"`)
+		// a key verified before is not charged
+		assert.Equal(t, http.StatusOK, post(admin).Code)
+	})
+
+	t.Run("revoke key", func(t *testing.T) {
+		var resp struct {
+			RevokeAPIKey struct {
+				ID        int
+				RevokedAt *string
+			}
+		}
+		c.MustPost(`mutation { revokeApiKey(id: 2) { id revokedAt } }`, &resp, client.AddHeader(graph.APIKeyHeader, admin))
+		assert.NotNil(t, resp.RevokeAPIKey.RevokedAt)
+
+		err := c.Post(`mutation { revokeApiKey(id: 2) { id } }`, &resp, client.AddHeader(graph.APIKeyHeader, admin))
+		assert.ErrorContains(t, err, `active api key with id '2' does not exist`)
+
+		err = c.Post(`{ books { count } }`, &resp, client.AddHeader(graph.APIKeyHeader, batch.CreateAPIKey.Key))
+		assert.ErrorContains(t, err, "API key has been revoked")
+
+		var history []model.AuditEntry
+		db.WithContext(ctx).Where("entity = ?", "api_keys").Order("id").Find(&history)
+		if assert.Len(t, history, 3) {
+			assert.Equal(t, model.AuditActionUpdate, history[2].Action)
+			assert.Equal(t, graph.Of("apikey:admin"), history[2].Actor)
+			assert.NotContains(t, *history[2].After, "argon2id")
+		}
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		var resp struct {
+			CreateAPIKey struct{ Key string }
+		}
+		c.MustPost(`mutation($at: Time!) { createApiKey(input: {name: "temp", scopes: ["reader"], expiresAt: $at}) { key } }`, &resp,
+			client.Var("at", now.Add(time.Minute)), client.AddHeader(graph.APIKeyHeader, admin))
+		now = now.Add(2 * time.Minute)
+		err := c.Post(`{ books { count } }`, &resp, client.AddHeader(graph.APIKeyHeader, resp.CreateAPIKey.Key))
+		assert.ErrorContains(t, err, "API key has expired")
+	})
+}
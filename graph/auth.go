@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	ErrorCode_Unauthenticated = "UNAUTHENTICATED"
+	ErrorCode_Forbidden       = "FORBIDDEN"
+)
+
+const (
+	Context_Roles  = ContextID("Roles")
+	Context_APIKey = ContextID("APIKey")
+	Context_User   = ContextID("User")
+)
+
+const (
+	// RoleAdmin passes every @hasRole check
+	RoleAdmin = "admin"
+	// RoleEditor may import books in bulk
+	RoleEditor = "editor"
+	// RoleModerator may edit and delete the reviews of any user
+	RoleModerator = "moderator"
+)
+
+func RolesOf(ctx context.Context) []string {
+	roles, _ := ctx.Value(Context_Roles).([]string)
+	return roles
+}
+
+func WithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, Context_Roles, roles)
+}
+
+func HasRoleOf(ctx context.Context, role string) bool {
+	for _, r := range RolesOf(ctx) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func UserOf(ctx context.Context) *model.User {
+	user, _ := ctx.Value(Context_User).(*model.User)
+	return user
+}
+
+func WithUser(ctx context.Context, user *model.User) context.Context {
+	return context.WithValue(ctx, Context_User, user)
+}
+
+func APIKeyOf(ctx context.Context) *model.APIKey {
+	key, _ := ctx.Value(Context_APIKey).(*model.APIKey)
+	return key
+}
+
+// WithAPIKey authenticates the context as the key: its scopes are the roles checked by @hasRole and
+// the audit trail records it as the actor. A key acting for a user authenticates that user too, and
+// the key scopes the context to its tenant.
+func WithAPIKey(ctx context.Context, key *model.APIKey) context.Context {
+	ctx = context.WithValue(ctx, Context_APIKey, key)
+	ctx = WithTenant(ctx, key.TenantID)
+	if key.User != nil {
+		ctx = WithUser(ctx, key.User)
+	}
+	ctx = context.WithValue(ctx, Context_Actor, "apikey:"+key.Name)
+	return WithRoles(ctx, key.Scopes)
+}
+
+// HasRole implements the @hasRole directive.
+func HasRole(ctx context.Context, obj interface{}, next graphql.Resolver, role string) (interface{}, error) {
+	if HasRoleOf(ctx, role) || HasRoleOf(ctx, RoleAdmin) {
+		return next(ctx)
+	}
+	return nil, NewForbiddenError(fmt.Sprintf("requires role '%s'", role))
+}
+
+// CanIncludeDeleted allows only admins to list deleted books, they are otherwise gone for good.
+func CanIncludeDeleted(ctx context.Context, includeDeleted *bool) error {
+	if includeDeleted != nil && *includeDeleted && !HasRoleOf(ctx, RoleAdmin) {
+		return NewForbiddenError(fmt.Sprintf("includeDeleted requires role '%s'", RoleAdmin))
+	}
+	return nil
+}
+
+func NewUnauthenticatedError(message string) error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": ErrorCode_Unauthenticated},
+	}
+}
+
+func NewForbiddenError(message string) error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": ErrorCode_Forbidden},
+	}
+}
+
+// CanModifyReview allows the author of review and moderators to edit or delete it. Both
+// repositories check it, so the rule holds for every caller and not only for the resolvers.
+func CanModifyReview(ctx context.Context, review *model.Review) error {
+	if HasRoleOf(ctx, RoleModerator) {
+		return nil
+	}
+	user := UserOf(ctx)
+	if user == nil {
+		return NewUnauthenticatedError("modifying a review requires a user")
+	}
+	if review.UserID == nil || *review.UserID != user.ID {
+		return NewForbiddenError(fmt.Sprintf("review with id '%v' belongs to another user", review.ID))
+	}
+	return nil
+}
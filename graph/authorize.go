@@ -0,0 +1,192 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/graph-gophers/dataloader"
+	"github.com/senomas/gographql/graph/model"
+)
+
+const Context_Policies = ContextID("Policies")
+
+// Policy decides for each of objs whether the caller in ctx may read a field annotated with
+// @auth(policy:). Objects of a list arrive together, so a policy that needs a query runs it once.
+type Policy interface {
+	Allow(ctx context.Context, objs []interface{}) ([]bool, error)
+}
+
+// PolicyFunc is a Policy deciding one object at a time, it is evaluated without batching.
+type PolicyFunc func(ctx context.Context, obj interface{}) (bool, error)
+
+func (f PolicyFunc) Allow(ctx context.Context, objs []interface{}) ([]bool, error) {
+	res := make([]bool, len(objs))
+	for i, obj := range objs {
+		allowed, err := f(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = allowed
+	}
+	return res, nil
+}
+
+// SelfPolicy allows a user to read their own fields and the fields of what they own.
+func SelfPolicy(ctx context.Context, obj interface{}) (bool, error) {
+	user := UserOf(ctx)
+	if user == nil {
+		return false, nil
+	}
+	var owner *int
+	switch o := obj.(type) {
+	case *model.User:
+		owner = &o.ID
+	case *model.Review:
+		owner = o.UserID
+	case *model.APIKey:
+		owner = o.UserID
+	default:
+		return false, fmt.Errorf("policy 'self' does not apply to %T", obj)
+	}
+	return owner != nil && *owner == user.ID, nil
+}
+
+// Authorizer implements the @auth directive, it is also an extension that batches the policy
+// evaluation of each operation.
+type Authorizer struct {
+	Policies map[string]Policy
+	// Wait is how long a batched policy collects the objects of a list before it is evaluated
+	Wait time.Duration
+}
+
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{
+		Policies: map[string]Policy{"self": PolicyFunc(SelfPolicy)},
+		Wait:     10 * time.Millisecond,
+	}
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+} = &Authorizer{}
+
+func (a *Authorizer) ExtensionName() string {
+	return "Authorizer"
+}
+
+// Validate rejects @auth on non null fields, they can not be masked, and policies that are not known.
+func (a *Authorizer) Validate(schema graphql.ExecutableSchema) error {
+	for _, def := range schema.Schema().Types {
+		for _, f := range def.Fields {
+			d := f.Directives.ForName("auth")
+			if d == nil {
+				continue
+			}
+			if f.Type.NonNull {
+				return fmt.Errorf("@auth on %s.%s needs a nullable type, got %s", def.Name, f.Name, f.Type.String())
+			}
+			if arg := d.Arguments.ForName("policy"); arg != nil {
+				if _, ok := a.Policies[arg.Value.Raw]; !ok {
+					return fmt.Errorf("@auth on %s.%s names unknown policy '%s'", def.Name, f.Name, arg.Value.Raw)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type policyLoaders struct {
+	mu      sync.Mutex
+	loaders map[string]*dataloader.Loader
+}
+
+type policyKey struct {
+	obj interface{}
+}
+
+func (k policyKey) String() string {
+	return fmt.Sprintf("%T:%p", k.obj, k.obj)
+}
+
+func (k policyKey) Raw() interface{} {
+	return k.obj
+}
+
+func (a *Authorizer) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	return next(context.WithValue(ctx, Context_Policies, &policyLoaders{loaders: map[string]*dataloader.Loader{}}))
+}
+
+func (l *policyLoaders) loader(name string, policy Policy, wait time.Duration) *dataloader.Loader {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if loader, ok := l.loaders[name]; ok {
+		return loader
+	}
+	loader := dataloader.NewBatchedLoader(func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		objs := make([]interface{}, len(keys))
+		for i, k := range keys {
+			objs[i] = k.Raw()
+		}
+		results := make([]*dataloader.Result, len(keys))
+		allowed, err := policy.Allow(ctx, objs)
+		if err == nil && len(allowed) != len(objs) {
+			err = fmt.Errorf("policy '%s' decided %v of %v objects", name, len(allowed), len(objs))
+		}
+		for i := range results {
+			if err != nil {
+				results[i] = &dataloader.Result{Error: err}
+			} else {
+				results[i] = &dataloader.Result{Data: allowed[i]}
+			}
+		}
+		return results
+	}, dataloader.WithWait(wait))
+	l.loaders[name] = loader
+	return loader
+}
+
+func (a *Authorizer) allow(ctx context.Context, name string, obj interface{}) (bool, error) {
+	policy, ok := a.Policies[name]
+	if !ok {
+		return false, fmt.Errorf("unknown policy '%s'", name)
+	}
+	loaders, _ := ctx.Value(Context_Policies).(*policyLoaders)
+	if _, single := policy.(PolicyFunc); single || loaders == nil {
+		allowed, err := policy.Allow(ctx, []interface{}{obj})
+		if err != nil {
+			return false, err
+		}
+		return len(allowed) == 1 && allowed[0], nil
+	}
+	allowed, err := loaders.loader(name, policy, a.Wait).Load(ctx, policyKey{obj: obj})()
+	if err != nil {
+		return false, err
+	}
+	return allowed.(bool), nil
+}
+
+// Auth implements the @auth directive. A caller that is not allowed reads null and the error is
+// added to the response, so the rest of the query still resolves.
+func (a *Authorizer) Auth(ctx context.Context, obj interface{}, next graphql.Resolver, requires *model.Role, policy *string) (interface{}, error) {
+	allowed := requires == nil && policy == nil && (UserOf(ctx) != nil || APIKeyOf(ctx) != nil)
+	if requires != nil {
+		allowed = HasRoleOf(ctx, strings.ToLower(requires.String()))
+	}
+	if !allowed && policy != nil {
+		var err error
+		if allowed, err = a.allow(ctx, *policy, obj); err != nil {
+			return nil, err
+		}
+	}
+	if !allowed {
+		fc := graphql.GetFieldContext(ctx)
+		graphql.AddError(ctx, NewForbiddenError(fmt.Sprintf("not authorized to read %s.%s", fc.Object, fc.Field.Name)))
+		return nil, nil
+	}
+	return next(ctx)
+}
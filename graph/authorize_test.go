@@ -0,0 +1,160 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingPolicy is SelfPolicy evaluated in batches, recording the size of each batch.
+type countingPolicy struct {
+	mu      sync.Mutex
+	batches []int
+}
+
+func (p *countingPolicy) Allow(ctx context.Context, objs []interface{}) ([]bool, error) {
+	p.mu.Lock()
+	p.batches = append(p.batches, len(objs))
+	p.mu.Unlock()
+	return graph.PolicyFunc(graph.SelfPolicy).Allow(ctx, objs)
+}
+
+func TestAuthorizer(t *testing.T) {
+	db := SetupSQLite(t)
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	policy := &countingPolicy{}
+	authorizer := graph.NewAuthorizer()
+	authorizer.Policies["self"] = policy
+	authorizer.Wait = 50 * time.Millisecond
+	resolver := &graph.Resolver{Config: cfg, NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver,
+		Directives: generated.DirectiveRoot{HasRole: graph.HasRole, Auth: authorizer.Auth}}))
+	srv.Use(authorizer)
+	c := client.New(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv)))
+
+	ds := graph.NewDataSource(db)
+	newKey := func(name string, scopes []string, userID *int) string {
+		apiKey, key, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: name, Scopes: scopes, UserID: userID}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.CreateAPIKey(ctx, apiKey); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	keys := map[string]string{}
+	for _, name := range []string{"cho", "cedric"} {
+		user, err := ds.CreateUser(ctx, model.NewUser{Name: name, Email: graph.Of(name + "@hogwarts.edu")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = newKey(name, []string{"reader"}, &user.ID)
+		if _, err := ds.CreateReview(graph.WithUser(ctx, user), model.NewReview{BookID: 1, Star: 4, Text: "by " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys["moderator"] = newKey("moderator", []string{graph.RoleModerator}, nil)
+
+	type Author struct {
+		Name  string
+		Email *string
+	}
+	type Error struct {
+		Message    string
+		Path       []interface{}
+		Extensions map[string]interface{}
+	}
+	query := func(key string) (map[string]*string, []Error) {
+		opts := []client.Option{}
+		if key != "" {
+			opts = append(opts, client.AddHeader(graph.APIKeyHeader, key))
+		}
+		resp, err := c.RawPost(`{ books(filter: {id: 1}) { list { id title reviews(limit: 50) { id author { name email } } } } }`, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct {
+			Books struct {
+				List []struct {
+					ID      int
+					Title   string
+					Reviews []struct {
+						ID     int
+						Author *Author
+					}
+				}
+			}
+		}
+		if b, err := json.Marshal(resp.Data); err != nil {
+			t.Fatal(err)
+		} else if err := json.Unmarshal(b, &data); err != nil {
+			t.Fatal(err)
+		}
+		var errs []Error
+		if len(resp.Errors) > 0 {
+			if err := json.Unmarshal(resp.Errors, &errs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		emails := map[string]*string{}
+		if assert.Len(t, data.Books.List, 1) {
+			assert.NotEmpty(t, data.Books.List[0].Title)
+			for _, r := range data.Books.List[0].Reviews {
+				if r.Author != nil {
+					emails[r.Author.Name] = r.Author.Email
+				}
+			}
+		}
+		return emails, errs
+	}
+
+	t.Run("masked for others", func(t *testing.T) {
+		emails, errs := query(keys["cedric"])
+		assert.Equal(t, map[string]*string{"cho": nil, "cedric": graph.Of("cedric@hogwarts.edu")}, emails)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "not authorized to read User.email", errs[0].Message)
+			assert.Equal(t, graph.ErrorCode_Forbidden, errs[0].Extensions["code"])
+			assert.Equal(t, "email", errs[0].Path[len(errs[0].Path)-1])
+		}
+	})
+
+	t.Run("masked without a user", func(t *testing.T) {
+		emails, errs := query("")
+		assert.Equal(t, map[string]*string{"cho": nil, "cedric": nil}, emails)
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("moderator reads every email", func(t *testing.T) {
+		emails, errs := query(keys["moderator"])
+		assert.Equal(t, map[string]*string{"cho": graph.Of("cho@hogwarts.edu"), "cedric": graph.Of("cedric@hogwarts.edu")}, emails)
+		assert.Empty(t, errs)
+	})
+
+	t.Run("policy is batched per list", func(t *testing.T) {
+		policy.mu.Lock()
+		policy.batches = nil
+		policy.mu.Unlock()
+		query(keys["cho"])
+		assert.Equal(t, []int{2}, policy.batches)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		schema := generated.NewExecutableSchema(generated.Config{Resolvers: resolver})
+		assert.NoError(t, graph.NewAuthorizer().Validate(schema))
+		assert.ErrorContains(t, (&graph.Authorizer{}).Validate(schema), "@auth on User.email names unknown policy 'self'")
+	})
+}
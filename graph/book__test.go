@@ -1,11 +1,19 @@
 package graph_test
 
 import (
+	"context"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/99designs/gqlgen/client"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/senomas/gographql/graph"
 	"github.com/senomas/gographql/graph/model"
@@ -27,19 +35,19 @@ func TestBook(t *testing.T) {
 
 	t.Run("find book series", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "book_series"`)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "book_series" WHERE "book_series"."tenant_id" = $1`)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(1))
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT "book_series"."id","book_series"."title" FROM "book_series" LIMIT 10
-         `)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT "book_series"."id","book_series"."title" FROM "book_series" WHERE "book_series"."tenant_id" = $1 LIMIT 10
+         `)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(1, "Harry Potter"))
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT count(*) FROM "books" WHERE books.series_id = $1
-         `)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+            SELECT count(*) FROM "books" WHERE books.series_id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2
+         `)).WithArgs(1, "default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(2))
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT "books"."id","books"."title" FROM "books" WHERE books.series_id = $1
-         `)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT "books"."id","books"."title" FROM "books" WHERE books.series_id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 10
+         `)).WithArgs(1, "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(1, "Harry Potter and the Sorcerer's Stone").
 				AddRow(2, "Harry Potter and the Chamber of Secrets"))
 		}
@@ -98,11 +106,11 @@ func TestBook(t *testing.T) {
 
 	t.Run("find books", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(4))
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT "books"."id","books"."title" FROM "books" LIMIT 10
-         `)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10
+         `)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(1, "Harry Potter and the Sorcerer's Stone").
 				AddRow(2, "Harry Potter and the Chamber of Secrets").
 				AddRow(3, "Harry Potter and the Book of Evil").
@@ -155,9 +163,9 @@ func TestBook(t *testing.T) {
 
 	t.Run("find books + authors", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(4))
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
@@ -168,8 +176,8 @@ func TestBook(t *testing.T) {
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" 
             FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1,$2,$3,$4)
-         `)).WithArgs(args, args, args, args).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
+            WHERE book_authors.book_id IN ($1,$2,$3,$4) AND "authors"."tenant_id" = $5 ORDER BY authors.id
+         `)).WithArgs(args, args, args, args, "default").WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 				AddRow(1, 1, "J.K. Rowling").
 				AddRow(2, 1, "J.K. Rowling").
 				AddRow(3, 2, "Lord Voldermort").
@@ -256,18 +264,18 @@ func TestBook(t *testing.T) {
 	t.Run("find book limit 1", func(t *testing.T) {
 		if mock != nil {
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT count(*) FROM "books"
-         `)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+            SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1
+         `)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(4))
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT "books"."id","books"."title" FROM "books" LIMIT 1
-         `)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 1
+         `)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(1, "Harry Potter and the Sorcerer's Stone"))
 
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."name" FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1)
-         `)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
+            WHERE book_authors.book_id IN ($1) AND "authors"."tenant_id" = $2 ORDER BY authors.id
+         `)).WithArgs(1, "default").WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 				AddRow(1, 1, "J.K. Rowling"))
 		}
 		defer func() {
@@ -315,17 +323,17 @@ func TestBook(t *testing.T) {
 		if mock != nil {
 			mock.ExpectQuery(QuoteMeta(`
             SELECT count(*) FROM "books" 
-            WHERE books.title LIKE $1 AND books.id IN (
-              SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2)
-         `)).WithArgs("%Harry Potter%", "Lord Voldermort").WillReturnRows(sqlmock.NewRows([]string{"count"}).
+            WHERE books.deleted_at IS NULL AND books.title LIKE $1 AND books.id IN (
+              SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2 AND "authors"."tenant_id" = $3) AND "books"."tenant_id" = $4
+         `)).WithArgs("%Harry Potter%", "Lord Voldermort", "default", "default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(2))
 
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "books"."id","books"."title" FROM "books" 
-            WHERE books.title LIKE $1 AND books.id IN (
-                  SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2
-            ) LIMIT 10
-         `)).WithArgs("%Harry Potter%", "Lord Voldermort").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            WHERE books.deleted_at IS NULL AND books.title LIKE $1 AND books.id IN (
+                  SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2 AND "authors"."tenant_id" = $3
+            ) AND "books"."tenant_id" = $4 LIMIT 10
+         `)).WithArgs("%Harry Potter%", "Lord Voldermort", "default", "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(3, "Harry Potter and the Book of Evil").
 				AddRow(4, "Harry Potter and the Snake Dictionary"))
 
@@ -333,8 +341,8 @@ func TestBook(t *testing.T) {
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" 
             FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1,$2)
-         `)).WithArgs(args, args).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
+            WHERE book_authors.book_id IN ($1,$2) AND "authors"."tenant_id" = $3 ORDER BY authors.id
+         `)).WithArgs(args, args, "default").WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 				AddRow(3, 2, "Lord Voldermort").
 				AddRow(4, 2, "Lord Voldermort").
 				AddRow(4, 3, "Salazar Slitherin"))
@@ -409,21 +417,21 @@ func TestBook(t *testing.T) {
 	t.Run("find books filter by title not and author_name not", func(t *testing.T) {
 		if mock != nil {
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT count(*) FROM "books" WHERE books.title NOT LIKE $1 AND books.id NOT IN (
-               SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2
-            )`)).WithArgs("%Stone%", "Lord Voldermort").WillReturnRows(sqlmock.NewRows([]string{"count"}).
+            SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND books.title NOT LIKE $1 AND books.id NOT IN (
+               SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2 AND "authors"."tenant_id" = $3
+            ) AND "books"."tenant_id" = $4`)).WithArgs("%Stone%", "Lord Voldermort", "default", "default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(1))
 
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT "books"."id","books"."title" FROM "books" WHERE books.title NOT LIKE $1 AND books.id NOT IN (
-               SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2
-            ) LIMIT 10`)).WithArgs("%Stone%", "Lord Voldermort").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND books.title NOT LIKE $1 AND books.id NOT IN (
+               SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $2 AND "authors"."tenant_id" = $3
+            ) AND "books"."tenant_id" = $4 LIMIT 10`)).WithArgs("%Stone%", "Lord Voldermort", "default", "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(2, "Harry Potter and the Chamber of Secrets"))
 
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" FROM "authors"
-            JOIN book_authors ON authors.id = book_authors.author_id WHERE book_authors.book_id IN ($1)
-            `)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
+            JOIN book_authors ON authors.id = book_authors.author_id WHERE book_authors.book_id IN ($1) AND "authors"."tenant_id" = $2 ORDER BY authors.id
+            `)).WithArgs(2, "default").WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 				AddRow(2, 1, "J.K. Rowling"))
 		}
 		defer func() {
@@ -482,9 +490,9 @@ func TestBook(t *testing.T) {
 	t.Run("find books filter unknown author_name", func(t *testing.T) {
 		if mock != nil {
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT count(*) FROM "books" WHERE books.id IN (
-              SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $1)
-            `)).WithArgs("Harry Potter").WillReturnRows(sqlmock.NewRows([]string{"count"}).
+            SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND books.id IN (
+              SELECT book_id FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE authors.name = $1 AND "authors"."tenant_id" = $2) AND "books"."tenant_id" = $3
+            `)).WithArgs("Harry Potter", "default", "default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(0))
 		}
 		defer func() {
@@ -528,16 +536,16 @@ func TestBook(t *testing.T) {
 	t.Run("find books + reviews", func(t *testing.T) {
 		if mock != nil {
 			reviewArgs := NewArrayIntArgs(1, 2, 3, 4)
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs(NoArgs...).WillReturnRows(sqlmock.NewRows([]string{"count"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).
 				AddRow(4))
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
 					AddRow(3, "Harry Potter and the Book of Evil").
 					AddRow(4, "Harry Potter and the Snake Dictionary"))
-			mock.ExpectQuery(QuoteMeta(`SELECT "book_id","id","star","text" FROM "reviews" WHERE book_id IN ($1,$2,$3,$4)`)).
-				WithArgs(reviewArgs, reviewArgs, reviewArgs, reviewArgs).
+			mock.ExpectQuery(QuoteMeta(`SELECT "book_id","id","star","text" FROM (SELECT "book_id","id","star","text",ROW_NUMBER() OVER (PARTITION BY book_id ORDER BY id) AS row_num FROM "reviews" WHERE book_id IN ($1,$2,$3,$4) AND "reviews"."tenant_id" = $5) AS reviews WHERE row_num <= $6 ORDER BY book_id, row_num`)).
+				WithArgs(reviewArgs, reviewArgs, reviewArgs, reviewArgs, "default", 10).
 				WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "star", "text"}).
 					AddRow(1, 1, 5, "The Boy Who Live").
 					AddRow(2, 2, 5, "The Girl Who Kill").
@@ -624,14 +632,14 @@ func TestBook(t *testing.T) {
 	t.Run("find books + reviews filter by star", func(t *testing.T) {
 		if mock != nil {
 			reviewArgs := NewArrayIntArgs(1, 2, 3, 4)
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
 					AddRow(3, "Harry Potter and the Book of Evil").
 					AddRow(4, "Harry Potter and the Snake Dictionary"))
-			mock.ExpectQuery(QuoteMeta(`SELECT "book_id","id","star","text" FROM "reviews" WHERE book_id IN ($1,$2,$3,$4) AND "reviews"."star" >= $5`)).
-				WithArgs(reviewArgs, reviewArgs, reviewArgs, reviewArgs, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "star", "text"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT "book_id","id","star","text" FROM (SELECT "book_id","id","star","text",ROW_NUMBER() OVER (PARTITION BY book_id ORDER BY id) AS row_num FROM "reviews" WHERE book_id IN ($1,$2,$3,$4) AND "reviews"."star" >= $5 AND "reviews"."tenant_id" = $6) AS reviews WHERE row_num <= $7 ORDER BY book_id, row_num`)).
+				WithArgs(reviewArgs, reviewArgs, reviewArgs, reviewArgs, 3, "default", 10).WillReturnRows(sqlmock.NewRows([]string{"id", "book_id", "star", "text"}).
 				AddRow(1, 1, 5, "The Boy Who Live").
 				AddRow(2, 2, 5, "The Girl Who Kill").
 				AddRow(4, 1, 3, "The Man With Funny Hat"))
@@ -708,8 +716,8 @@ func TestBook(t *testing.T) {
 	t.Run("find books filter by title and review.star", func(t *testing.T) {
 		if mock != nil {
 			mock.ExpectQuery(QuoteMeta(`
-            SELECT DISTINCT "books"."id","books"."title" FROM "books" JOIN reviews ON books.id = reviews.book_id WHERE books.title LIKE $1 AND "reviews"."star" >= $2 LIMIT 10
-            `)).WithArgs("%Harry Potter%", 3).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+            SELECT DISTINCT "books"."id","books"."title" FROM "books" JOIN reviews ON books.id = reviews.book_id WHERE books.deleted_at IS NULL AND books.title LIKE $1 AND "reviews"."star" >= $2 AND "books"."tenant_id" = $3 LIMIT 10
+            `)).WithArgs("%Harry Potter%", 3, "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(1, "Harry Potter and the Sorcerer's Stone").
 				AddRow(2, "Harry Potter and the Chamber of Secrets"))
 		}
@@ -750,7 +758,7 @@ func TestBook(t *testing.T) {
 
 	t.Run("find books concurrent", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
@@ -815,23 +823,31 @@ func TestBook(t *testing.T) {
 	})
 
 	t.Run("create review", func(t *testing.T) {
-		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE id = $1 LIMIT 1`)).WithArgs(3).
+		user := &model.User{ID: 1, Name: "ginny"}
+		if mock == nil {
+			if u, err := graph.NewDataSource(db).CreateUser(graph.WithTenant(context.TODO(), graph.DefaultTenant), model.NewUser{Name: user.Name}); assert.NoError(t, err) {
+				user = u
+			}
+		} else {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE id = $1 AND deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(3, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author_id"}).
 					AddRow(3, "Harry Potter and the Book of Evil", 2))
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "reviews" WHERE book_id = $1 AND user_id = $2 AND "reviews"."tenant_id" = $3`)).WithArgs(3, 1, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 			mock.ExpectBegin()
-			mock.ExpectQuery(QuoteMeta(`INSERT INTO "reviews" ("star","text","book_id") VALUES ($1,$2,$3) RETURNING "id"`)).
-				WithArgs(5, "Tom Riddle", 3).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "reviews" ("star","text","book_id","user_id","version","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`)).
+				WithArgs(5, "Tom Riddle", 3, 1, 1, "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).
 				AddRow(5))
+			ExpectAudit(mock, 1, "reviews", 5, "INSERT", "createReview")
 			mock.ExpectCommit()
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.id IN ($1)`)).
-				WithArgs(int64(3)).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.id IN ($1) AND books.deleted_at IS NULL AND "books"."tenant_id" = $2`)).
+				WithArgs(int64(3), "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 				AddRow(3, "Harry Potter and the Book of Evil"))
 
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" 
-            FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE book_authors.book_id IN ($1)
-            `)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
+            FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE book_authors.book_id IN ($1) AND "authors"."tenant_id" = $2 ORDER BY authors.id
+            `)).WithArgs(3, "default").WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 				AddRow(3, 2, "Lord Voldermort"))
 		}
 		defer func() {
@@ -868,7 +884,7 @@ func TestBook(t *testing.T) {
                }
             }
          }
-      }`, &resp, addContext(graph.NewDataSource(db)))
+      }`, &resp, addContext(graph.NewDataSource(db)), addUser(user))
 		JsonMatch(t, &respType{
 			CreateReview: CreateReview{
 				ID:   5,
@@ -890,26 +906,19 @@ func TestBook(t *testing.T) {
 
 	t.Run("create book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2)`)).WithArgs("J.K. Rowling", "Albus Dumbledore").
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2) AND "authors"."tenant_id" = $3 ORDER BY authors.id`)).WithArgs("J.K. Rowling", "Albus Dumbledore", "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
 					AddRow(1, "J.K. Rowling").
 					AddRow(4, "Albus Dumbledore"))
 			mock.ExpectBegin()
-			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id") VALUES ($1,$2) RETURNING "id"`)).
-				WithArgs("Harry Potter and the Unknown", nil).
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).
+				WithArgs("Harry Potter and the Unknown", nil, nil, 1, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id"}).
 					AddRow(5))
 			mock.ExpectExec(QuoteMeta(`INSERT INTO "book_authors" ("book_id","author_id") VALUES ($1,$2),($3,$4) ON CONFLICT DO NOTHING`)).
 				WithArgs(5, 1, 5, 4).WillReturnResult(driver.RowsAffected(2))
+			ExpectAudit(mock, 2, "books", 5, "INSERT", "createBook")
 			mock.ExpectCommit()
-
-			mock.ExpectQuery(QuoteMeta(`
-            SELECT "book_authors"."book_id","authors"."id","authors"."name" 
-            FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1)
-         `)).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
-				AddRow(5, 1, "J.K. Rowling").
-				AddRow(5, 4, "Albus Dumbledore"))
 		}
 		defer func() {
 			if mock != nil {
@@ -959,14 +968,14 @@ func TestBook(t *testing.T) {
 
 	t.Run("create duplicate book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2)`)).WithArgs("J.K. Rowling", "Albus Dumbledore").
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2) AND "authors"."tenant_id" = $3 ORDER BY authors.id`)).WithArgs("J.K. Rowling", "Albus Dumbledore", "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
 					AddRow(1, "J.K. Rowling").
 					AddRow(4, "Albus Dumbledore"))
 			mock.ExpectBegin()
-			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id") VALUES ($1,$2) RETURNING "id"`)).
-				WithArgs("Harry Potter and the Unknown", nil).
-				WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "books_title_key" (SQLSTATE 23505)`))
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).
+				WithArgs("Harry Potter and the Unknown", nil, nil, 1, "default").
+				WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`))
 			mock.ExpectRollback()
 		}
 		defer func() {
@@ -1002,29 +1011,24 @@ func TestBook(t *testing.T) {
 
 	t.Run("update book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 LIMIT 1`)).WithArgs(4).
-				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
-					AddRow(4, "Harry Potter and the Unknown"))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Snake Dictionary", 1))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{2: "Lord Voldermort", 3: "Salazar Slitherin"})
 			mock.ExpectBegin()
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2)`)).
-				WithArgs("Albus Dumbledore", "Salazar Slitherin").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
-				AddRow(4, "Salazar Slitherin").
-				AddRow(5, "Albus Dumbledore"))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2) AND "authors"."tenant_id" = $3 ORDER BY authors.id`)).
+				WithArgs("Albus Dumbledore", "Salazar Slitherin", "default").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).
+				AddRow(3, "Salazar Slitherin", 1).
+				AddRow(4, "Albus Dumbledore", 1))
 			mock.ExpectExec(QuoteMeta(`DELETE FROM book_authors WHERE book_id = $1 AND author_id NOT IN ($2,$3)`)).
-				WithArgs(4, 4, 5).WillReturnResult(driver.RowsAffected(1))
-			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "title"=$1 WHERE "id" = $2`)).
-				WithArgs("Harry Potter and the Fake Book", 4).
+				WithArgs(4, 3, 4).WillReturnResult(driver.RowsAffected(1))
+			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "title"=$1,"version"=$2 WHERE version = $3 AND "books"."tenant_id" = $4 AND "id" = $5`)).
+				WithArgs("Harry Potter and the Fake Book", 2, 1, "default", 4).
 				WillReturnResult(driver.RowsAffected(1))
 			mock.ExpectExec(QuoteMeta(`INSERT INTO "book_authors" ("book_id","author_id") VALUES ($1,$2),($3,$4) ON CONFLICT DO NOTHING`)).
-				WithArgs(4, 4, 4, 5).WillReturnResult(driver.RowsAffected(1))
+				WithArgs(4, 3, 4, 4).WillReturnResult(driver.RowsAffected(1))
+			ExpectAudit(mock, 3, "books", 4, "UPDATE", "updateBook")
 			mock.ExpectCommit()
-
-			mock.ExpectQuery(QuoteMeta(`
-            SELECT "book_authors"."book_id","authors"."id","authors"."name" 
-              FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id WHERE book_authors.book_id IN ($1)
-            `)).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
-				AddRow(4, 3, "Salazar Slitherin").
-				AddRow(4, 4, "Albus Dumbledore"))
 		}
 		defer func() {
 			if mock != nil {
@@ -1044,6 +1048,7 @@ func TestBook(t *testing.T) {
 		c.MustPost(`mutation {
          updateBook(input: {
             id: 4
+            expectedVersion: 1
             title: "Harry Potter and the Fake Book"
             authors_name: ["Albus Dumbledore", "Salazar Slitherin"]
          }) {
@@ -1075,9 +1080,9 @@ func TestBook(t *testing.T) {
 
 	t.Run("find updated books", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
@@ -1088,8 +1093,8 @@ func TestBook(t *testing.T) {
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" 
             FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1,$2,$3,$4,$5)`)).
-				WithArgs(args, args, args, args, args).
+            WHERE book_authors.book_id IN ($1,$2,$3,$4,$5) AND "authors"."tenant_id" = $6 ORDER BY authors.id`)).
+				WithArgs(args, args, args, args, args, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 					AddRow(1, 1, "J.K. Rowling").
 					AddRow(2, 1, "J.K. Rowling").
@@ -1123,10 +1128,11 @@ func TestBook(t *testing.T) {
          }
       }`, &resp, addContext(graph.NewDataSource(db)))
 
+		resp.Books.List = SortBooks(db, resp.Books.List)
 		JsonMatch(t, &respType{
 			Books: model.BookList{
 				Count: 5,
-				List: []*model.Book{
+				List: SortBooks(db, []*model.Book{
 					{
 						ID:    1,
 						Title: "Harry Potter and the Sorcerer's Stone",
@@ -1185,19 +1191,21 @@ func TestBook(t *testing.T) {
 							},
 						},
 					},
-				},
+				}),
 			},
 		}, &resp)
 	})
 
 	t.Run("update book duplicate", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 LIMIT 1`)).WithArgs(4).
-				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
-					AddRow(4, "Harry Potter and the Unknown"))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 2))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
 			mock.ExpectBegin()
-			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "title"=$1 WHERE "id" = $2`)).WithArgs("Harry Potter and the Sorcerer's Stone", 4).
-				WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "books_title_key" (SQLSTATE 23505)`))
+			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "title"=$1,"version"=$2 WHERE version = $3 AND "books"."tenant_id" = $4 AND "id" = $5`)).
+				WithArgs("Harry Potter and the Sorcerer's Stone", 3, 2, "default", 4).
+				WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`))
 			mock.ExpectRollback()
 		}
 		defer func() {
@@ -1218,6 +1226,7 @@ func TestBook(t *testing.T) {
 		err := c.Post(`mutation {
          updateBook(input: {
             id: 4
+            expectedVersion: 2
             title: "Harry Potter and the Sorcerer's Stone"
          }) {
             id
@@ -1231,9 +1240,46 @@ func TestBook(t *testing.T) {
 		assert.ErrorContains(t, err, `duplicate key books.title \"Harry Potter and the Sorcerer's Stone\"`)
 	})
 
+	t.Run("update book conflict", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 2))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 2))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			UpdateBook model.Book
+		}
+		var resp respType
+		err := c.Post(`mutation {
+         updateBook(input: {
+            id: 4
+            expectedVersion: 1
+            title: "Harry Potter and the Stale Book"
+         }) {
+            id
+            title
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)))
+		assert.ErrorContains(t, err, `book with id '4' has version 2, expected 1`)
+		assert.ErrorContains(t, err, `"code":"CONFLICT"`)
+		assert.ErrorContains(t, err, `"title":"Harry Potter and the Fake Book"`)
+		assert.ErrorContains(t, err, `"name":"Albus Dumbledore"`)
+	})
+
 	t.Run("update unknown book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 LIMIT 1`)).WithArgs(999).
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(999, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author_id", "Author__id", "Author__name"}))
 		}
 		defer func() {
@@ -1254,6 +1300,7 @@ func TestBook(t *testing.T) {
 		err := c.Post(`mutation {
          updateBook(input: {
             id: 999
+            expectedVersion: 1
             title: "Harry Potter and the Sorcerer's Stone"
          }) {
             id
@@ -1269,12 +1316,14 @@ func TestBook(t *testing.T) {
 
 	t.Run("delete book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 LIMIT 1`)).WithArgs(4).
-				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
-					AddRow(4, "Harry Potter and the Fake Book"))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 2))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
 			mock.ExpectBegin()
-			mock.ExpectExec(QuoteMeta(`DELETE FROM "books" WHERE "books"."id" = $1`)).WithArgs(4).
+			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "deleted_at"=$1,"version"=$2 WHERE version = $3 AND "books"."tenant_id" = $4 AND "id" = $5`)).WithArgs(sqlmock.AnyArg(), 3, 2, "default", 4).
 				WillReturnResult(driver.RowsAffected(1))
+			ExpectAudit(mock, 4, "books", 4, "DELETE", "deleteBook")
 			mock.ExpectCommit()
 		}
 		defer func() {
@@ -1308,10 +1357,10 @@ func TestBook(t *testing.T) {
 
 	t.Run("find deleted books", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books"`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"count"}).
 					AddRow(4))
-			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" LIMIT 10`)).WithArgs(NoArgs...).
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
 					AddRow(1, "Harry Potter and the Sorcerer's Stone").
 					AddRow(2, "Harry Potter and the Chamber of Secrets").
@@ -1322,7 +1371,7 @@ func TestBook(t *testing.T) {
 			mock.ExpectQuery(QuoteMeta(`
             SELECT "book_authors"."book_id","authors"."id","authors"."name" 
             FROM "authors" JOIN book_authors ON authors.id = book_authors.author_id 
-            WHERE book_authors.book_id IN ($1,$2,$3,$4)`)).WithArgs(args, args, args, args).
+            WHERE book_authors.book_id IN ($1,$2,$3,$4) AND "authors"."tenant_id" = $5 ORDER BY authors.id`)).WithArgs(args, args, args, args, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"book_id", "id", "name"}).
 					AddRow(1, 1, "J.K. Rowling").
 					AddRow(2, 1, "J.K. Rowling").
@@ -1407,9 +1456,376 @@ func TestBook(t *testing.T) {
 		}, &resp)
 	})
 
+	t.Run("find books include deleted", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT count(*) FROM "books" WHERE "books"."tenant_id" = $1`)).WithArgs("default").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).
+					AddRow(5))
+			mock.ExpectQuery(QuoteMeta(`SELECT "books"."id","books"."title" FROM "books" WHERE "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+					AddRow(1, "Harry Potter and the Sorcerer's Stone").
+					AddRow(2, "Harry Potter and the Chamber of Secrets").
+					AddRow(3, "Harry Potter and the Book of Evil").
+					AddRow(5, "Harry Potter and the Unknown").
+					AddRow(4, "Harry Potter and the Fake Book"))
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			Books model.BookList
+		}
+		var resp respType
+		c.MustPost(`{
+         books(includeDeleted: true) {
+            count
+            list {
+               id
+               title
+            }
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)), addRoles(graph.RoleAdmin))
+
+		resp.Books.List = SortBooks(db, resp.Books.List)
+		JsonMatch(t, &respType{
+			Books: model.BookList{
+				Count: 5,
+				List: SortBooks(db, []*model.Book{
+					{
+						ID:    1,
+						Title: "Harry Potter and the Sorcerer's Stone",
+					},
+					{
+						ID:    2,
+						Title: "Harry Potter and the Chamber of Secrets",
+					},
+					{
+						ID:    3,
+						Title: "Harry Potter and the Book of Evil",
+					},
+					{
+						ID:    5,
+						Title: "Harry Potter and the Unknown",
+					},
+					{
+						ID:    4,
+						Title: "Harry Potter and the Fake Book",
+					},
+				}),
+			},
+		}, &resp)
+	})
+
+	t.Run("restore book", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NOT NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "deleted_at", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", time.Now(), 3))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+			mock.ExpectBegin()
+			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "deleted_at"=$1,"version"=$2 WHERE version = $3 AND "books"."tenant_id" = $4 AND "id" = $5`)).WithArgs(nil, 4, 3, "default", 4).
+				WillReturnResult(driver.RowsAffected(1))
+			ExpectAudit(mock, 5, "books", 4, "UPDATE", "restoreBook")
+			mock.ExpectCommit()
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type RestoreBook struct {
+			ID        int
+			Title     string
+			DeletedAt *time.Time
+		}
+		type respType struct {
+			RestoreBook RestoreBook
+		}
+		var resp respType
+		c.MustPost(`mutation {
+         restoreBook(id: 4) {
+            id
+            title
+            deletedAt
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)), addRoles(graph.RoleAdmin))
+		JsonMatch(t, &respType{
+			RestoreBook: RestoreBook{
+				ID:    4,
+				Title: "Harry Potter and the Fake Book",
+			},
+		}, &resp)
+	})
+
+	t.Run("update restored book with stale version", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 4))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 4))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			UpdateBook model.Book
+		}
+		var resp respType
+		err := c.Post(`mutation {
+         updateBook(input: {
+            id: 4
+            expectedVersion: 2
+            title: "Harry Potter and the Stale Book"
+         }) {
+            id
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)))
+		assert.ErrorContains(t, err, `book with id '4' has version 4, expected 2`)
+	})
+
+	t.Run("restore not deleted book", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NOT NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "deleted_at"}))
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			RestoreBook model.Book
+		}
+		var resp respType
+		err := c.Post(`mutation {
+         restoreBook(id: 4) {
+            id
+            title
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)), addRoles(graph.RoleAdmin))
+		assert.ErrorContains(t, err, `deleted book with id '4' does not exist`)
+	})
+
+	t.Run("deleted books require admin", func(t *testing.T) {
+		var resp struct{}
+		for _, query := range []string{
+			`{ books(includeDeleted: true) { count } }`,
+			`mutation { restoreBook(id: 4) { id } }`,
+			`mutation { purgeDeleted(olderThan: "2100-01-01T00:00:00Z") }`,
+		} {
+			err := c.Post(query, &resp, addContext(graph.NewDataSource(db)), addRoles(graph.RoleModerator))
+			assert.ErrorContains(t, err, "requires role 'admin'", query)
+		}
+	})
+
+	t.Run("book history", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`
+            SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND books.id = $1 AND "books"."tenant_id" = $2 LIMIT 10
+         `)).WithArgs(4, "default").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).
+				AddRow(4, "Harry Potter and the Fake Book"))
+			mock.ExpectQuery(QuoteMeta(`
+            SELECT * FROM "audit_entries" WHERE audit_entries.entity = $1 AND audit_entries.entity_id IN ($2) AND "audit_entries"."tenant_id" = $3 
+            ORDER BY audit_entries.at,audit_entries.id
+         `)).WithArgs("books", 4, "default").WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "action", "operation", "actor", "at"}).
+				AddRow(3, "books", 4, "UPDATE", "updateBook", nil, time.Now()).
+				AddRow(4, "books", 4, "DELETE", "deleteBook", nil, time.Now()).
+				AddRow(5, "books", 4, "UPDATE", "restoreBook", nil, time.Now()))
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type AuditEntry struct {
+			Action    string
+			Operation string
+			Actor     *string
+		}
+		type Book struct {
+			ID      int
+			Title   string
+			History []AuditEntry
+		}
+		type BookList struct {
+			List []Book
+		}
+		type respType struct {
+			Books BookList
+		}
+		var resp respType
+		c.MustPost(`{
+         books(filter: {id: 4}) {
+            list {
+               id
+               title
+               history {
+                  action
+                  operation
+                  actor
+               }
+            }
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)))
+		JsonMatch(t, &respType{
+			Books: BookList{
+				List: []Book{
+					{
+						ID:    4,
+						Title: "Harry Potter and the Fake Book",
+						History: []AuditEntry{
+							{Action: "UPDATE", Operation: "updateBook"},
+							{Action: "DELETE", Operation: "deleteBook"},
+							{Action: "UPDATE", Operation: "restoreBook"},
+						},
+					},
+				},
+			},
+		}, &resp)
+	})
+
+	t.Run("book as of", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`
+            SELECT * FROM "audit_entries" WHERE audit_entries.entity = $1 AND audit_entries.entity_id = $2 AND audit_entries.at <= $3 AND "audit_entries"."tenant_id" = $4 
+            ORDER BY audit_entries.at DESC,audit_entries.id DESC LIMIT 1
+         `)).WithArgs("books", 4, sqlmock.AnyArg(), "default").WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "action", "operation", "after"}).
+				AddRow(5, "books", 4, "UPDATE", "restoreBook", `{
+               "id": 4, "title": "Harry Potter and the Fake Book", "series_id": null, "deleted_at": null, "version": 4,
+               "authors": [{"id": 3, "name": "Salazar Slitherin", "version": 1}, {"id": 4, "name": "Albus Dumbledore", "version": 1}]
+            }`))
+			mock.ExpectQuery(QuoteMeta(`
+            SELECT * FROM "audit_entries" WHERE audit_entries.entity = $1 AND audit_entries.entity_id = $2 AND audit_entries.at <= $3 AND "audit_entries"."tenant_id" = $4 
+            ORDER BY audit_entries.at DESC,audit_entries.id DESC LIMIT 1
+         `)).WithArgs("books", 1, sqlmock.AnyArg(), "default").WillReturnRows(sqlmock.NewRows([]string{"id", "entity", "entity_id", "action", "operation", "after"}))
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			BookAsOf *model.Book
+		}
+		var resp respType
+		c.MustPost(`{
+         bookAsOf(id: 4, at: "2100-01-01T00:00:00Z") {
+            id
+            title
+            version
+            authors {
+               id
+               name
+            }
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)))
+		JsonMatch(t, &respType{
+			BookAsOf: &model.Book{
+				ID:      4,
+				Title:   "Harry Potter and the Fake Book",
+				Version: 4,
+				Authors: []*model.Author{
+					{
+						ID:   3,
+						Name: "Salazar Slitherin",
+					},
+					{
+						ID:   4,
+						Name: "Albus Dumbledore",
+					},
+				},
+			},
+		}, &resp)
+
+		resp = respType{}
+		c.MustPost(`{
+         bookAsOf(id: 1, at: "2100-01-01T00:00:00Z") {
+            id
+            title
+         }
+      }`, &resp, addContext(graph.NewDataSource(db)))
+		JsonMatch(t, &respType{}, &resp)
+	})
+
+	t.Run("purge deleted books", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(4, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", 4))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+			mock.ExpectBegin()
+			mock.ExpectExec(QuoteMeta(`UPDATE "books" SET "deleted_at"=$1,"version"=$2 WHERE version = $3 AND "books"."tenant_id" = $4 AND "id" = $5`)).WithArgs(sqlmock.AnyArg(), 5, 4, "default", 4).
+				WillReturnResult(driver.RowsAffected(1))
+			ExpectAudit(mock, 6, "books", 4, "DELETE", "deleteBook")
+			mock.ExpectCommit()
+			mock.ExpectBegin()
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.deleted_at < $1 AND "books"."tenant_id" = $2`)).WithArgs(sqlmock.AnyArg(), "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "deleted_at", "version"}).
+					AddRow(4, "Harry Potter and the Fake Book", time.Now(), 2))
+			ExpectPreloadBookAuthors(mock, 4, map[int]string{3: "Salazar Slitherin", 4: "Albus Dumbledore"})
+			mock.ExpectExec(QuoteMeta(`DELETE FROM "reviews" WHERE book_id IN ($1) AND "reviews"."tenant_id" = $2`)).WithArgs(4, "default").
+				WillReturnResult(driver.RowsAffected(1))
+			mock.ExpectExec(QuoteMeta(`DELETE FROM book_authors WHERE book_id IN ($1)`)).WithArgs(4).
+				WillReturnResult(driver.RowsAffected(2))
+			mock.ExpectExec(QuoteMeta(`DELETE FROM "books" WHERE books.id IN ($1) AND "books"."tenant_id" = $2`)).WithArgs(4, "default").
+				WillReturnResult(driver.RowsAffected(1))
+			ExpectAudit(mock, 7, "books", 4, "DELETE", "purgeDeleted")
+			mock.ExpectCommit()
+		} else if result := db.WithContext(graph.WithTenant(context.TODO(), graph.DefaultTenant)).Create(&model.Review{BookID: 4, Star: 2, Text: "Purged With The Book"}); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		type respType struct {
+			DeleteBook   model.Book
+			PurgeDeleted int
+		}
+		var resp respType
+		c.MustPost(`mutation {
+         deleteBook(id: 4) {
+            id
+            title
+         }
+         purgeDeleted(olderThan: "2100-01-01T00:00:00Z")
+      }`, &resp, addContext(graph.NewDataSource(db)), addRoles(graph.RoleAdmin))
+		JsonMatch(t, &respType{
+			DeleteBook: model.Book{
+				ID:    4,
+				Title: "Harry Potter and the Fake Book",
+			},
+			PurgeDeleted: 1,
+		}, &resp)
+
+		if mock == nil {
+			var reviews, authors int64
+			db.WithContext(graph.WithTenant(context.TODO(), graph.DefaultTenant)).Model(&model.Review{}).Where("book_id = ?", 4).Count(&reviews)
+			db.Table("book_authors").Where("book_id = ?", 4).Count(&authors)
+			assert.Zero(t, reviews)
+			assert.Zero(t, authors)
+		}
+	})
+
 	t.Run("delete unknown book", func(t *testing.T) {
 		if mock != nil {
-			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 LIMIT 1`)).WithArgs(999).
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "books" WHERE books.id = $1 AND books.deleted_at IS NULL AND "books"."tenant_id" = $2 LIMIT 1`)).WithArgs(999, "default").
 				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author_id", "Author__id", "Author__name"}))
 		}
 
@@ -1434,4 +1850,154 @@ func TestBook(t *testing.T) {
       }`, &resp, addContext(graph.NewDataSource(db)))
 		assert.ErrorContains(t, err, `book with id '999' does not exist`)
 	})
+
+	t.Run("import books without editor role", func(t *testing.T) {
+		file, err := os.CreateTemp(t.TempDir(), "books-*.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString("title,series,authors,reviews\nFantastic Beasts,,,\n")
+		file.Seek(0, 0)
+
+		var resp struct {
+			ImportBooks model.ImportResult
+		}
+		err = c.Post(`mutation ($file: Upload!) {
+         importBooks(file: $file) {
+            imported
+         }
+      }`, &resp, client.Var("file", file), client.WithFiles(), addContext(graph.NewDataSource(db)),
+			addUser(&model.User{ID: 1, Name: "ginny"}))
+		assert.ErrorContains(t, err, `requires role 'editor'`)
+	})
+
+	t.Run("import books", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectBegin()
+			mock.ExpectExec(QuoteMeta(`SAVEPOINT import_row_1`)).WillReturnResult(driver.RowsAffected(0))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "book_series" WHERE title = $1 AND "book_series"."tenant_id" = $2 LIMIT 1`)).WithArgs("Wizarding World", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "version"}))
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "book_series" ("title","version","tenant_id") VALUES ($1,$2,$3) RETURNING "id"`)).
+				WithArgs("Wizarding World", 1, "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			ExpectAudit(mock, 8, "book_series", 2, "INSERT", "importBooks")
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1,$2) AND "authors"."tenant_id" = $3`)).WithArgs("J.K. Rowling", "Newt Scamander", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(1, "J.K. Rowling", 1))
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "authors" ("name","version","tenant_id") VALUES ($1,$2,$3) RETURNING "id"`)).
+				WithArgs("Newt Scamander", 1, "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+			ExpectAudit(mock, 9, "authors", 5, "INSERT", "importBooks")
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).
+				WithArgs("Fantastic Beasts", 2, nil, 1, "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+			mock.ExpectExec(QuoteMeta(`INSERT INTO "book_authors" ("book_id","author_id") VALUES ($1,$2),($3,$4) ON CONFLICT DO NOTHING`)).
+				WithArgs(6, 1, 6, 5).WillReturnResult(driver.RowsAffected(2))
+			ExpectAudit(mock, 10, "books", 6, "INSERT", "importBooks")
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "reviews" ("star","text","book_id","user_id","version","tenant_id") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`)).
+				WithArgs(4, "Magical", 6, 1, 1, "default").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+			ExpectAudit(mock, 11, "reviews", 6, "INSERT", "importBooks")
+			mock.ExpectExec(QuoteMeta(`SAVEPOINT import_row_2`)).WillReturnResult(driver.RowsAffected(0))
+			mock.ExpectQuery(QuoteMeta(`SELECT * FROM "authors" WHERE name IN ($1) AND "authors"."tenant_id" = $2`)).WithArgs("J.K. Rowling", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(1, "J.K. Rowling", 1))
+			mock.ExpectQuery(QuoteMeta(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).
+				WithArgs("Harry Potter and the Sorcerer's Stone", nil, nil, 1, "default").
+				WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`))
+			mock.ExpectExec(QuoteMeta(`ROLLBACK TO SAVEPOINT import_row_2`)).WillReturnResult(driver.RowsAffected(0))
+			mock.ExpectCommit()
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		file, err := os.CreateTemp(t.TempDir(), "books-*.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(`title,series,authors,reviews
+Fantastic Beasts,Wizarding World,J.K. Rowling;Newt Scamander,4:Magical
+Harry Potter and the Sorcerer's Stone,,J.K. Rowling,
+Harry Potter and the Bad Row,,J.K. Rowling,x
+`)
+		file.Seek(0, 0)
+
+		type respType struct {
+			ImportBooks model.ImportResult
+		}
+		var resp respType
+		c.MustPost(`mutation ($file: Upload!) {
+         importBooks(file: $file, batchSize: 2) {
+            rows
+            imported
+            errors {
+               row
+               message
+            }
+         }
+      }`, &resp, client.Var("file", file), client.WithFiles(), addContext(graph.NewDataSource(db)),
+			addUser(&model.User{ID: 1, Name: "ginny"}), addRoles(graph.RoleEditor))
+		JsonMatch(t, &respType{
+			ImportBooks: model.ImportResult{
+				Rows:     3,
+				Imported: 1,
+				Errors: []*model.ImportError{
+					{
+						Row:     2,
+						Message: `duplicate key books.title "Harry Potter and the Sorcerer's Stone"`,
+					},
+					{
+						Row:     3,
+						Message: `invalid review 'x', expected 'star:text'`,
+					},
+				},
+			},
+		}, &resp)
+	})
+
+	t.Run("export books", func(t *testing.T) {
+		if mock != nil {
+			mock.ExpectQuery(QuoteMeta(`SELECT books.id, books.title, book_series.title, authors.name, ratings.reviews, ratings.rating FROM "books" LEFT JOIN book_series ON book_series.id = books.series_id LEFT JOIN book_authors ON book_authors.book_id = books.id LEFT JOIN authors ON authors.id = book_authors.author_id LEFT JOIN (SELECT reviews.book_id, COUNT(*) AS reviews, AVG(reviews.star) AS rating FROM "reviews" WHERE "reviews"."tenant_id" = $1 GROUP BY "reviews"."book_id") AS ratings ON ratings.book_id = books.id WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $2 ORDER BY books.id,authors.name`)).WithArgs("default", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title", "title", "name", "reviews", "rating"}).
+					AddRow(1, "Harry Potter and the Sorcerer's Stone", "Harry Potter", "J.K. Rowling", 2, 4.5).
+					AddRow(6, "Fantastic Beasts", "Wizarding World", "J.K. Rowling", 1, 4.0).
+					AddRow(6, "Fantastic Beasts", "Wizarding World", "Newt Scamander", 1, 4.0))
+		}
+		defer func() {
+			if mock != nil {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}
+		}()
+
+		req := httptest.NewRequest("GET", "/export?format=jsonl", nil)
+		req = req.WithContext(graph.WithTenant(req.Context(), graph.DefaultTenant))
+		rec := httptest.NewRecorder()
+		graph.ExportHandler(graph.NewDataSource(db)).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/jsonl", rec.Header().Get("Content-Type"))
+
+		var found *graph.ExportRow
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			var row graph.ExportRow
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("invalid line %s: %v", line, err)
+			}
+			if row.Title == "Fantastic Beasts" {
+				found = &row
+			}
+		}
+		if assert.NotNil(t, found) {
+			assert.Equal(t, graph.Of("Wizarding World"), found.Series)
+			assert.Equal(t, []string{"J.K. Rowling", "Newt Scamander"}, found.Authors)
+			assert.Equal(t, 1, found.ReviewCount)
+			assert.Equal(t, graph.Of(4.0), found.Rating)
+		}
+	})
+
+	t.Run("export books invalid format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/export?format=xml", nil)
+		rec := httptest.NewRecorder()
+		graph.ExportHandler(graph.NewDataSource(db)).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "unsupported export format 'xml'\n", rec.Body.String())
+	})
 }
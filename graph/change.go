@@ -0,0 +1,224 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm/schema"
+)
+
+// changeEntities are the audited tables recorded in the change stream.
+var changeEntities = map[string]model.ChangeEntity{
+	"authors":     model.ChangeEntityAuthor,
+	"books":       model.ChangeEntityBook,
+	"book_series": model.ChangeEntityBookSeries,
+	"reviews":     model.ChangeEntityReview,
+}
+
+// ChangeGapTimeout is how long a gap in the event ids holds the stream back. An id is taken when the
+// event is written but only shows once its transaction commits, which may be long after the events
+// following it committed. A gap is a change still being committed until it has been seen for longer
+// than ChangeGapTimeout, and is then presumed rolled back.
+var ChangeGapTimeout = 10 * time.Second
+
+// changeGaps remembers when every gap in the event ids of a store was first seen. A gap is timed from
+// then, the events following it say nothing of when the transaction holding its id will commit.
+type changeGaps struct {
+	mu   sync.Mutex
+	seen map[changeGap]time.Time
+}
+
+// changeGap is a run of missing ids, known by its first one.
+type changeGap struct {
+	store interface{}
+	id    int
+}
+
+var gaps = &changeGaps{seen: map[changeGap]time.Time{}}
+
+// observe notes the gaps among events, which follow since in id order, as seen at now unless they
+// were seen before, and forgets the gaps filled since.
+func (g *changeGaps) observe(store interface{}, since model.Cursor, events []*model.ChangeEvent, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	last := int(since)
+	for _, e := range events {
+		delete(g.seen, changeGap{store, e.ID})
+		if e.ID != last+1 {
+			if _, ok := g.seen[changeGap{store, last + 1}]; !ok {
+				g.seen[changeGap{store, last + 1}] = now
+			}
+		}
+		last = e.ID
+	}
+	for k, at := range g.seen {
+		// a consumer behind by that long waits for the gap once more
+		if now.Sub(at) > 100*ChangeGapTimeout {
+			delete(g.seen, k)
+		}
+	}
+}
+
+// open reports whether the gap starting at id may still be filled at now.
+func (g *changeGaps) open(store interface{}, id int, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	at, ok := g.seen[changeGap{store, id}]
+	return !ok || now.Sub(at) < ChangeGapTimeout
+}
+
+// NewChangeEvent records an audited change for the change stream, nil for the entities the stream
+// does not cover. The event is meant to be stored along with the change.
+func NewChangeEvent(ctx context.Context, namer schema.Namer, action model.AuditAction, entity string, id int, before interface{}, after interface{}, at time.Time) (*model.ChangeEvent, error) {
+	e, ok := changeEntities[entity]
+	if !ok {
+		return nil, nil
+	}
+	event := &model.ChangeEvent{
+		Entity:    e,
+		EntityID:  id,
+		Action:    action,
+		Operation: OperationName(ctx),
+		Actor:     Actor(ctx),
+		At:        at,
+	}
+	data := after
+	if data == nil {
+		data = before
+	}
+	if data != nil {
+		if m, err := Snapshot(ctx, namer, data); err != nil {
+			return nil, err
+		} else if v, err := json.Marshal(m); err != nil {
+			return nil, err
+		} else {
+			event.Data = Of(string(v))
+		}
+	}
+	return event, nil
+}
+
+// changeFeed pages the events of store following since, which are up to limit+1 events of every
+// tenant in id order. The page stops at the first gap seen for less than ChangeGapTimeout, and the
+// cursor moves past the events of other tenants so they are not scanned again.
+func changeFeed(ctx context.Context, store interface{}, events []*model.ChangeEvent, since model.Cursor, limit int, now time.Time) *model.ChangeFeed {
+	feed := &model.ChangeFeed{Events: []*model.ChangeEvent{}, Cursor: since, HasMore: len(events) > limit}
+	gaps.observe(store, since, events, now)
+	for i, e := range events {
+		if i == limit {
+			break
+		}
+		if e.ID != int(feed.Cursor)+1 && gaps.open(store, int(feed.Cursor)+1, now) {
+			feed.HasMore = false
+			break
+		}
+		feed.Cursor = model.Cursor(e.ID)
+		if inTenant(ctx, e.TenantID) {
+			feed.Events = append(feed.Events, e)
+		}
+	}
+	return feed
+}
+
+// ChangeFeedHandler serves the change stream to consumers polling over plain HTTP. A GET with
+// since, limit and wait (in seconds) answers as soon as there are events after since, or without
+// events once wait is up. It goes inside Resolver.Middleware and APIKeyAuth, and like the changes
+// query it requires the admin role.
+type ChangeFeedHandler struct {
+	// Poll is how often a waiting request looks for new events
+	Poll     time.Duration
+	MaxWait  time.Duration
+	MaxLimit int
+}
+
+func NewChangeFeedHandler(cfg *ConfigType) *ChangeFeedHandler {
+	return &ChangeFeedHandler{
+		Poll:     time.Duration(cfg.ChangeFeedPoll) * time.Second,
+		MaxWait:  time.Duration(cfg.ChangeFeedWait) * time.Second,
+		MaxLimit: 1000,
+	}
+}
+
+func (h *ChangeFeedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if APIKeyOf(ctx) == nil {
+		http.Error(w, "missing api key", http.StatusUnauthorized)
+		return
+	}
+	if !HasRoleOf(ctx, RoleAdmin) {
+		http.Error(w, fmt.Sprintf("requires role '%s'", RoleAdmin), http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	var since model.Cursor
+	if s := query.Get("since"); s != "" {
+		var err error
+		if since, err = model.ParseCursor(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if s := query.Get("limit"); s != "" {
+		if l, err := strconv.Atoi(s); err != nil || l <= 0 || l > h.MaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %v", h.MaxLimit), http.StatusBadRequest)
+			return
+		} else {
+			limit = l
+		}
+	}
+	wait := h.MaxWait
+	if s := query.Get("wait"); s != "" {
+		if v, err := strconv.Atoi(s); err != nil || v < 0 {
+			http.Error(w, fmt.Sprintf("invalid wait '%s'", s), http.StatusBadRequest)
+			return
+		} else if d := time.Duration(v) * time.Second; d < wait {
+			wait = d
+		}
+	}
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	var feed *model.ChangeFeed
+	for {
+		if feed, err = repo.Changes(ctx, since, limit); err != nil {
+			DefaultLogger.Error(ctx, "change feed failed", map[string]interface{}{"error": err.Error()})
+			http.Error(w, "change feed failed", http.StatusInternalServerError)
+			return
+		}
+		if len(feed.Events) > 0 || feed.HasMore {
+			break
+		}
+		since = feed.Cursor
+		poll := time.NewTimer(h.Poll)
+		select {
+		case <-ctx.Done():
+			poll.Stop()
+			return
+		case <-deadline.C:
+			poll.Stop()
+		case <-poll.C:
+			continue
+		}
+		break
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		DefaultLogger.Error(ctx, "change feed write failed", map[string]interface{}{"error": err.Error()})
+	}
+}
@@ -0,0 +1,297 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	t.Run("database", func(t *testing.T) {
+		db := SetupSQLite(t)
+		c, admin, cursor := testChanges(t, func() graph.Repository { return graph.NewDataSource(db) })
+
+		t.Run("gap", func(t *testing.T) {
+			last, err := model.ParseCursor(cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// an id still being committed holds back the events after it, however long ago they were made
+			insert := func(id int, at time.Time) {
+				event := &model.ChangeEvent{ID: id, Entity: model.ChangeEntityAuthor, EntityID: 1, Action: model.AuditActionUpdate, At: at}
+				if result := db.WithContext(graph.WithTenant(context.TODO(), graph.DefaultTenant)).Create(event); result.Error != nil {
+					t.Fatal(result.Error)
+				}
+			}
+			var resp struct{ Changes Feed }
+			poll := func() {
+				c.MustPost(`query($since: Cursor) { changes(since: $since) { events { id } cursor hasMore } }`, &resp, admin, client.Var("since", cursor))
+			}
+			insert(int(last)+3, time.Now().Add(-time.Minute))
+			poll()
+			assert.Empty(t, resp.Changes.Events)
+			assert.Equal(t, cursor, resp.Changes.Cursor)
+			assert.False(t, resp.Changes.HasMore)
+
+			// the transactions holding the earlier ids commit late and out of order
+			insert(int(last)+2, time.Now().Add(-2*time.Minute))
+			poll()
+			assert.Empty(t, resp.Changes.Events)
+			insert(int(last)+1, time.Now().Add(-3*time.Minute))
+			poll()
+			if assert.Len(t, resp.Changes.Events, 3) {
+				assert.Equal(t, int(last)+1, resp.Changes.Events[0].ID)
+				assert.Equal(t, int(last)+2, resp.Changes.Events[1].ID)
+				assert.Equal(t, int(last)+3, resp.Changes.Events[2].ID)
+			}
+
+			// a gap seen for longer than ChangeGapTimeout is presumed rolled back
+			defer func(timeout time.Duration) { graph.ChangeGapTimeout = timeout }(graph.ChangeGapTimeout)
+			graph.ChangeGapTimeout = 100 * time.Millisecond
+			cursor = resp.Changes.Cursor
+			insert(int(last)+5, time.Now())
+			poll()
+			assert.Empty(t, resp.Changes.Events)
+			time.Sleep(graph.ChangeGapTimeout)
+			poll()
+			if assert.Len(t, resp.Changes.Events, 1) {
+				assert.Equal(t, int(last)+5, resp.Changes.Events[0].ID)
+			}
+		})
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		mem := graph.NewMemoryRepository()
+		mem.Populate()
+		testChanges(t, func() graph.Repository { return mem })
+	})
+}
+
+type Change struct {
+	ID        int
+	Entity    string
+	EntityID  int
+	Action    string
+	Operation string
+	Data      *string
+}
+
+type Feed struct {
+	Events  []Change
+	Cursor  string
+	HasMore bool
+}
+
+func testChanges(t *testing.T, repository func() graph.Repository) (*client.Client, client.Option, string) {
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	resolver := &graph.Resolver{Config: cfg, NewRepository: repository}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole}}))
+	c := client.New(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv)))
+
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+	user, err := repository().CreateUser(ctx, model.NewUser{Name: "hermione"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := func(ctx context.Context, scopes []string, userID *int) (client.Option, string) {
+		apiKey, key, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: "changes", Scopes: scopes, UserID: userID}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repository().CreateAPIKey(ctx, apiKey); err != nil {
+			t.Fatal(err)
+		}
+		return client.AddHeader(graph.APIKeyHeader, key), key
+	}
+	admin, adminKey := newKey(ctx, []string{graph.RoleAdmin}, &user.ID)
+	reader, readerKey := newKey(ctx, []string{"reader"}, nil)
+
+	const changes = `query($since: Cursor, $limit: Int) { changes(since: $since, limit: $limit) { events { id entity entityId action operation data } cursor hasMore } }`
+	var resp struct{ Changes Feed }
+	c.MustPost(changes, &resp, admin)
+	assert.Empty(t, resp.Changes.Events, "the sample data is not a change")
+	assert.False(t, resp.Changes.HasMore)
+	start := resp.Changes.Cursor
+
+	err = c.Post(changes, &resp, reader)
+	assert.ErrorContains(t, err, "requires role 'admin'")
+	err = c.Post(changes, &resp, admin, client.Var("since", "bogus"))
+	assert.ErrorContains(t, err, "invalid cursor 'bogus'")
+
+	var author struct {
+		CreateAuthor struct{ ID int }
+	}
+	c.MustPost(`mutation { createAuthor(input: {name: "Newt Scamander"}) { id } }`, &author, admin)
+	var book struct {
+		CreateBook struct{ ID int }
+	}
+	c.MustPost(`mutation { createBook(input: {title: "Fantastic Beasts", authors_name: ["Newt Scamander"]}) { id } }`, &book, admin)
+	var duplicate struct {
+		CreateBook *struct{ ID int }
+	}
+	err = c.Post(`mutation { createBook(input: {title: "Fantastic Beasts", authors_name: ["Newt Scamander"]}) { id } }`, &duplicate, admin)
+	assert.ErrorContains(t, err, "duplicate key books.title")
+	var updated struct {
+		UpdateBook struct{ ID int }
+	}
+	c.MustPost(`mutation($id: Int!) { updateBook(input: {id: $id, expectedVersion: 1, title: "Fantastic Beasts and Where to Find Them"}) { id } }`,
+		&updated, admin, client.Var("id", book.CreateBook.ID))
+	var review struct {
+		CreateReview struct{ ID int }
+	}
+	c.MustPost(`mutation($id: Int!) { createReview(input: {book_id: $id, star: 5, text: "magical"}) { id } }`,
+		&review, admin, client.Var("id", book.CreateBook.ID))
+	var deleted struct {
+		DeleteReview struct{ ID int }
+	}
+	c.MustPost(`mutation($id: Int!) { deleteReview(id: $id) { id } }`, &deleted, admin, client.Var("id", review.CreateReview.ID))
+
+	expected := []struct {
+		entity string
+		id     int
+		action string
+		op     string
+	}{
+		{"AUTHOR", author.CreateAuthor.ID, "INSERT", "createAuthor"},
+		{"BOOK", book.CreateBook.ID, "INSERT", "createBook"},
+		{"BOOK", book.CreateBook.ID, "UPDATE", "updateBook"},
+		{"REVIEW", review.CreateReview.ID, "INSERT", "createReview"},
+		{"REVIEW", review.CreateReview.ID, "DELETE", "deleteReview"},
+	}
+
+	t.Run("query", func(t *testing.T) {
+		var all []Change
+		since := start
+		for page := 0; page < 5; page++ {
+			c.MustPost(changes, &resp, admin, client.Var("since", since), client.Var("limit", 2))
+			all = append(all, resp.Changes.Events...)
+			since = resp.Changes.Cursor
+			if !resp.Changes.HasMore {
+				break
+			}
+		}
+		if !assert.Len(t, all, len(expected)) {
+			return
+		}
+		for i, e := range expected {
+			assert.Equal(t, e.entity, all[i].Entity, "event %d", i)
+			assert.Equal(t, e.id, all[i].EntityID, "event %d", i)
+			assert.Equal(t, e.action, all[i].Action, "event %d", i)
+			assert.Equal(t, e.op, all[i].Operation, "event %d", i)
+			if i > 0 {
+				assert.Greater(t, all[i].ID, all[i-1].ID)
+			}
+		}
+		var data map[string]interface{}
+		if assert.NotNil(t, all[2].Data) && assert.NoError(t, json.Unmarshal([]byte(*all[2].Data), &data)) {
+			assert.Equal(t, "Fantastic Beasts and Where to Find Them", data["title"])
+		}
+		if assert.NotNil(t, all[4].Data) && assert.NoError(t, json.Unmarshal([]byte(*all[4].Data), &data)) {
+			assert.Equal(t, "magical", data["text"])
+		}
+
+		c.MustPost(changes, &resp, admin, client.Var("since", since))
+		assert.Empty(t, resp.Changes.Events)
+		assert.Equal(t, since, resp.Changes.Cursor)
+	})
+
+	c.MustPost(changes, &resp, admin, client.Var("since", start), client.Var("limit", 100))
+	cursor := resp.Changes.Cursor
+
+	t.Run("tenant", func(t *testing.T) {
+		acme, _ := newKey(graph.WithTenant(context.TODO(), "acme"), []string{graph.RoleAdmin}, nil)
+		var created struct {
+			CreateAuthor struct{ ID int }
+		}
+		c.MustPost(`mutation { createAuthor(input: {name: "Gilderoy Lockhart"}) { id } }`, &created, acme, client.AddHeader(graph.TenantHeader, "acme"))
+		c.MustPost(changes, &resp, acme, client.AddHeader(graph.TenantHeader, "acme"), client.Var("since", cursor))
+		if assert.Len(t, resp.Changes.Events, 1) {
+			assert.Equal(t, created.CreateAuthor.ID, resp.Changes.Events[0].EntityID)
+		}
+		// the cursor moves past the events of other tenants
+		c.MustPost(changes, &resp, admin, client.Var("since", cursor))
+		assert.Empty(t, resp.Changes.Events)
+		assert.NotEqual(t, cursor, resp.Changes.Cursor)
+		cursor = resp.Changes.Cursor
+	})
+
+	t.Run("long poll", func(t *testing.T) {
+		feed := &graph.ChangeFeedHandler{Poll: 10 * time.Millisecond, MaxWait: 5 * time.Second, MaxLimit: 100}
+		server := httptest.NewServer(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(feed)))
+		defer server.Close()
+		get := func(query string, key string) (*http.Response, *model.ChangeFeed) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"?"+query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != "" {
+				req.Header.Set(graph.APIKeyHeader, key)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				return res, nil
+			}
+			var body model.ChangeFeed
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			return res, &body
+		}
+		res, _ := get("", "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		res, _ = get("", readerKey)
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		res, _ = get("since=bogus", adminKey)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res, _ = get("limit=1000", adminKey)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+		res, body := get("wait=0&since="+cursor, adminKey)
+		if assert.Equal(t, http.StatusOK, res.StatusCode) {
+			assert.Empty(t, body.Events)
+			assert.Equal(t, cursor, body.Cursor.String())
+		}
+
+		done := make(chan *model.ChangeFeed, 1)
+		began := time.Now()
+		go func() {
+			_, body := get("wait=5&since="+cursor, adminKey)
+			done <- body
+		}()
+		time.Sleep(50 * time.Millisecond)
+		var created struct {
+			CreateAuthor struct{ ID int }
+		}
+		c.MustPost(`mutation { createAuthor(input: {name: "Luna Lovegood"}) { id } }`, &created, admin)
+		select {
+		case body := <-done:
+			if assert.NotNil(t, body) && assert.Len(t, body.Events, 1) {
+				assert.Equal(t, model.ChangeEntityAuthor, body.Events[0].Entity)
+				assert.Equal(t, created.CreateAuthor.ID, body.Events[0].EntityID)
+				assert.NotEqual(t, cursor, body.Cursor.String())
+				cursor = body.Cursor.String()
+			}
+			assert.Less(t, time.Since(began), 4*time.Second)
+		case <-time.After(10 * time.Second):
+			t.Fatal("long poll did not return")
+		}
+	})
+
+	return c, admin, cursor
+}
@@ -0,0 +1,202 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	ErrorCode_DepthLimit = "DEPTH_LIMIT"
+	ErrorCode_CostLimit  = "COST_LIMIT"
+)
+
+const costExtension = "cost"
+
+type OperationCost struct {
+	Depth    int `json:"depth"`
+	Cost     int `json:"cost"`
+	MaxDepth int `json:"maxDepth,omitempty"`
+	MaxCost  int `json:"maxCost,omitempty"`
+}
+
+// CostLimit is a gqlgen extension rejecting operations nested deeper than MaxDepth or costing more
+// than MaxCost before anything is resolved, zero disables a limit. The computed cost is returned in
+// the cost response extension. MaxLimit is the page size charged for a list whose limit is null.
+type CostLimit struct {
+	MaxDepth int
+	MaxCost  int
+	MaxLimit int
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+	graphql.ResponseInterceptor
+} = CostLimit{}
+
+func (CostLimit) ExtensionName() string {
+	return "CostLimit"
+}
+
+func (CostLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e CostLimit) MutateOperationContext(ctx context.Context, oc *graphql.OperationContext) *gqlerror.Error {
+	if oc.Operation == nil {
+		return nil
+	}
+	cost := OperationCost{
+		Depth:    selectionDepth(oc.Operation.SelectionSet, oc.Doc.Fragments),
+		Cost:     e.selectionCost(oc.Operation.SelectionSet, oc.Doc.Fragments, oc.Variables),
+		MaxDepth: e.MaxDepth,
+		MaxCost:  e.MaxCost,
+	}
+	oc.Stats.SetExtension(costExtension, cost)
+	if e.MaxDepth > 0 && cost.Depth > e.MaxDepth {
+		return &gqlerror.Error{
+			Message:    fmt.Sprintf("operation has depth %d, exceeding the limit of %d", cost.Depth, e.MaxDepth),
+			Extensions: map[string]interface{}{"code": ErrorCode_DepthLimit, "cost": cost},
+		}
+	}
+	if e.MaxCost > 0 && cost.Cost > e.MaxCost {
+		return &gqlerror.Error{
+			Message:    fmt.Sprintf("operation has cost %d, exceeding the limit of %d", cost.Cost, e.MaxCost),
+			Extensions: map[string]interface{}{"code": ErrorCode_CostLimit, "cost": cost},
+		}
+	}
+	return nil
+}
+
+func (CostLimit) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	if cost, ok := graphql.GetOperationContext(ctx).Stats.GetExtension(costExtension).(OperationCost); ok {
+		graphql.RegisterExtension(ctx, costExtension, cost)
+	}
+	return next(ctx)
+}
+
+// selectionDepth counts the nested field levels, fragments do not add a level of their own and
+// introspection fields are not counted.
+func selectionDepth(set ast.SelectionSet, fragments ast.FragmentDefinitionList) int {
+	depth := 0
+	for _, sel := range set {
+		d := 0
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if isIntrospection(sel) {
+				continue
+			}
+			d = 1 + selectionDepth(sel.SelectionSet, fragments)
+		case *ast.InlineFragment:
+			d = selectionDepth(sel.SelectionSet, fragments)
+		case *ast.FragmentSpread:
+			if f := fragments.ForName(sel.Name); f != nil {
+				d = selectionDepth(f.SelectionSet, fragments)
+			}
+		}
+		if d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+func (e CostLimit) selectionCost(set ast.SelectionSet, fragments ast.FragmentDefinitionList, vars map[string]interface{}) int {
+	cost := 0
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if !isIntrospection(sel) {
+				cost += e.fieldCost(sel, fragments, vars)
+			}
+		case *ast.InlineFragment:
+			cost += e.selectionCost(sel.SelectionSet, fragments, vars)
+		case *ast.FragmentSpread:
+			if f := fragments.ForName(sel.Name); f != nil {
+				cost += e.selectionCost(f.SelectionSet, fragments, vars)
+			}
+		}
+	}
+	return cost
+}
+
+// fieldCost follows the @cost annotation of the field definition: its value plus the cost of its
+// selection multiplied by the multiplier argument. A null or missing argument is charged at listSize,
+// or MaxLimit without one, or the default of the argument when neither is set. Fields without the annotation cost 1 when they select an
+// object and nothing when they are leaves.
+func (e CostLimit) fieldCost(field *ast.Field, fragments ast.FragmentDefinitionList, vars map[string]interface{}) int {
+	value, multiplier := 0, 1
+	if len(field.SelectionSet) > 0 {
+		value = 1
+	}
+	if field.Definition != nil {
+		if d := field.Definition.Directives.ForName("cost"); d != nil {
+			value = 1
+			args := d.ArgumentMap(vars)
+			if v, ok := intArg(args["value"]); ok {
+				value = v
+			}
+			listSize, hasListSize := intArg(args["listSize"])
+			if hasListSize {
+				multiplier = listSize
+			}
+			if name, ok := args["multiplier"].(string); ok {
+				if v, ok := intArg(field.ArgumentMap(vars)[name]); ok {
+					multiplier = v
+				} else if !hasListSize && e.MaxLimit > 0 {
+					multiplier = e.MaxLimit
+				} else if v, ok := argDefault(field.Definition, name); ok && !hasListSize {
+					// the resolvers read a null as the default
+					multiplier = v
+				}
+			}
+		}
+	}
+	if multiplier < 0 {
+		multiplier = 0
+	}
+	return value + multiplier*e.selectionCost(field.SelectionSet, fragments, vars)
+}
+
+func isIntrospection(field *ast.Field) bool {
+	return len(field.Name) > 1 && field.Name[:2] == "__"
+}
+
+// intArg reads an int argument, including a variable left as a json.Number or sent as a string,
+// which gqlgen coerces to an int when the field resolves.
+func intArg(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		return n, err == nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// argDefault is the default of the int argument name of def.
+func argDefault(def *ast.FieldDefinition, name string) (int, bool) {
+	if arg := def.Arguments.ForName(name); arg != nil && arg.DefaultValue != nil {
+		if v, err := arg.DefaultValue.Value(nil); err == nil {
+			return intArg(v)
+		}
+	}
+	return 0, false
+}
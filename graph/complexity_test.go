@@ -0,0 +1,114 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostLimit(t *testing.T) {
+	repo := graph.NewMemoryRepository()
+	repo.Populate()
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return repo }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.CostLimit{MaxDepth: 4, MaxCost: 50, MaxLimit: 100})
+	c := client.New(resolver.Middleware(srv))
+
+	t.Run("cost in extensions", func(t *testing.T) {
+		resp, err := c.RawPost(`query Books($limit: Int) {
+         books(limit: $limit) {
+            count
+            list {
+               ...bookFields
+            }
+         }
+      }
+      fragment bookFields on Book {
+         id
+         title
+         authors {
+            name
+         }
+      }`, client.Var("limit", 2))
+		if assert.NoError(t, err) && assert.Nil(t, resp.Errors) {
+			// books 1 + limit 2 * (list 1 + authors 1 + listSize 5 * leaves 0)
+			assert.Equal(t, map[string]interface{}{"depth": 4.0, "cost": 5.0, "maxDepth": 4.0, "maxCost": 50.0}, resp.Extensions["cost"])
+		}
+	})
+
+	t.Run("default limit multiplies nested lists", func(t *testing.T) {
+		resp, err := c.RawPost(`{
+         bookSeries {
+            list {
+               books {
+                  count
+               }
+            }
+         }
+      }`)
+		if assert.NoError(t, err) && assert.Nil(t, resp.Errors) {
+			// bookSeries 1 + limit 10 * (list 1 + books 1 + listSize 10 * leaves 0)
+			assert.Equal(t, 21.0, resp.Extensions["cost"].(map[string]interface{})["cost"])
+		}
+	})
+
+	t.Run("depth limit", func(t *testing.T) {
+		resp, err := c.RawPost(`{
+         bookSeries {
+            list {
+               books {
+                  list {
+                     id
+                  }
+               }
+            }
+         }
+      }`)
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `operation has depth 5, exceeding the limit of 4`)
+			assert.Contains(t, string(resp.Errors), `"code":"DEPTH_LIMIT"`)
+		}
+	})
+
+	t.Run("cost limit", func(t *testing.T) {
+		resp, err := c.RawPost(`{
+         books(limit: 30) {
+            list {
+               reviews {
+                  star
+               }
+            }
+         }
+      }`)
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `operation has cost 61, exceeding the limit of 50`)
+			assert.Contains(t, string(resp.Errors), `"code":"COST_LIMIT"`)
+		}
+	})
+
+	t.Run("null limit", func(t *testing.T) {
+		resp, err := c.RawPost(`query Books($limit: Int) { books(limit: $limit) { list { id } } }`, client.Var("limit", nil))
+		if assert.NoError(t, err) {
+			// books 1 + MaxLimit 100 * list 1
+			assert.Contains(t, string(resp.Errors), `operation has cost 101, exceeding the limit of 50`)
+		}
+		resp, err = c.RawPost(`{ books(limit: null) { list { id } } }`)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(resp.Errors), `operation has cost 101, exceeding the limit of 50`)
+		}
+	})
+
+	t.Run("limit as string variable", func(t *testing.T) {
+		resp, err := c.RawPost(`query Books($limit: Int) { books(limit: $limit) { list { id } } }`, client.Var("limit", "1000000"))
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `operation has cost 1000001, exceeding the limit of 50`)
+		}
+	})
+}
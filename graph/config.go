@@ -0,0 +1,244 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+)
+
+const DefaultTokenSecret = "supersecure"
+
+type ConfigType struct {
+	Application          string `yaml:"application" toml:"application" env:"APPLICATION"`
+	Mode                 string `yaml:"mode" toml:"mode" env:"APP_MODE"`
+	Port                 string `yaml:"port" toml:"port" env:"PORT"`
+	Driver               string `yaml:"driver" toml:"driver" env:"DB_DRIVER"`
+	DSN                  string `yaml:"dsn" toml:"dsn" env:"DB_DSN,DB_POSTGRES"`
+	LogSQL               bool   `yaml:"logSQL" toml:"logSQL" env:"LOGGER"`
+	LogFormat            string `yaml:"logFormat" toml:"logFormat" env:"LOG_FORMAT"`
+	TokenSecret          string `yaml:"tokenSecret" toml:"tokenSecret" env:"TOKEN_SECRET"`
+	HashedPasswordLength uint32 `yaml:"hashedPasswordLength" toml:"hashedPasswordLength" env:"HASHED_PASSWORD_LENGTH"`
+	Argon2_Time          uint32 `yaml:"argon2Time" toml:"argon2Time" env:"ARGON2_TIME"`
+	Argon2_Memory        uint32 `yaml:"argon2Memory" toml:"argon2Memory" env:"ARGON2_MEMORY"`
+	Argon2_Thread        uint8  `yaml:"argon2Thread" toml:"argon2Thread" env:"ARGON2_THREAD"`
+	TracingExporter      string `yaml:"tracingExporter" toml:"tracingExporter" env:"TRACING_EXPORTER"`
+	TracingEndpoint      string `yaml:"tracingEndpoint" toml:"tracingEndpoint" env:"TRACING_ENDPOINT"`
+	MaxQueryDepth        uint32 `yaml:"maxQueryDepth" toml:"maxQueryDepth" env:"MAX_QUERY_DEPTH"`
+	MaxQueryCost         uint32 `yaml:"maxQueryCost" toml:"maxQueryCost" env:"MAX_QUERY_COST"`
+	MaxPageLimit         uint32 `yaml:"maxPageLimit" toml:"maxPageLimit" env:"MAX_PAGE_LIMIT"`
+	MaxPageOffset        uint32 `yaml:"maxPageOffset" toml:"maxPageOffset" env:"MAX_PAGE_OFFSET"`
+	PersistedQueryCache  uint32 `yaml:"persistedQueryCache" toml:"persistedQueryCache" env:"PERSISTED_QUERY_CACHE"`
+	PersistedQueryStore  string `yaml:"persistedQueryStore" toml:"persistedQueryStore" env:"PERSISTED_QUERY_STORE"`
+	OperationManifest    string `yaml:"operationManifest" toml:"operationManifest" env:"OPERATION_MANIFEST"`
+	StrictOperations     bool   `yaml:"strictOperations" toml:"strictOperations" env:"STRICT_OPERATIONS"`
+	RateLimit            uint32 `yaml:"rateLimit" toml:"rateLimit" env:"RATE_LIMIT"`
+	RateLimitBurst       uint32 `yaml:"rateLimitBurst" toml:"rateLimitBurst" env:"RATE_LIMIT_BURST"`
+	RateLimitOverrides   string `yaml:"rateLimitOverrides" toml:"rateLimitOverrides" env:"RATE_LIMIT_OVERRIDES"`
+	Tenant               string `yaml:"tenant" toml:"tenant" env:"TENANT"`
+	WebhookMaxAttempts   uint32 `yaml:"webhookMaxAttempts" toml:"webhookMaxAttempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookBackoff       uint32 `yaml:"webhookBackoff" toml:"webhookBackoff" env:"WEBHOOK_BACKOFF"`
+	WebhookInterval      uint32 `yaml:"webhookInterval" toml:"webhookInterval" env:"WEBHOOK_INTERVAL"`
+	WebhookTimeout       uint32 `yaml:"webhookTimeout" toml:"webhookTimeout" env:"WEBHOOK_TIMEOUT"`
+	ChangeFeedWait       uint32 `yaml:"changeFeedWait" toml:"changeFeedWait" env:"CHANGE_FEED_WAIT"`
+	ChangeFeedPoll       uint32 `yaml:"changeFeedPoll" toml:"changeFeedPoll" env:"CHANGE_FEED_POLL"`
+}
+
+func DefaultConfig() *ConfigType {
+	return &ConfigType{
+		Application:          "MyApp",
+		Mode:                 ModeDevelopment,
+		Port:                 "8088",
+		Driver:               DialectPostgres,
+		DSN:                  DefaultDSN,
+		LogFormat:            LogFormatJSON,
+		TokenSecret:          DefaultTokenSecret,
+		HashedPasswordLength: 32,
+		Argon2_Time:          3,
+		Argon2_Memory:        64 * 1024,
+		Argon2_Thread:        2,
+		MaxQueryDepth:        10,
+		MaxQueryCost:         5000,
+		MaxPageLimit:         100,
+		MaxPageOffset:        10000,
+		PersistedQueryCache:  1000,
+		PersistedQueryStore:  PersistedQueryStoreMemory,
+		RateLimit:            50,
+		RateLimitBurst:       500,
+		Tenant:               DefaultTenant,
+		WebhookMaxAttempts:   8,
+		WebhookBackoff:       30,
+		WebhookInterval:      5,
+		WebhookTimeout:       10,
+		ChangeFeedWait:       30,
+		ChangeFeedPoll:       1,
+	}
+}
+
+// LoadConfig builds the configuration from the defaults, then the file when one is given, then the
+// environment, each one overriding the previous. Flags are applied by the caller on top of it.
+func LoadConfig(file string) (*ConfigType, error) {
+	cfg := DefaultConfig()
+	if file != "" {
+		if err := cfg.LoadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.LoadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *ConfigType) LoadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("invalid config file '%s': %v", file, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("invalid config file '%s': %v", file, err)
+		}
+	default:
+		return fmt.Errorf("unknown config format for file '%s'", file)
+	}
+	return nil
+}
+
+func (cfg *ConfigType) LoadEnv(lookup func(string) (string, bool)) error {
+	rv := reflect.ValueOf(cfg).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag := rt.Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		// the first variable set wins when a field lists several names
+		for _, name := range strings.Split(tag, ",") {
+			if v, ok := lookup(name); ok {
+				if err := cfg.Set(rt.Field(i).Name, v); err != nil {
+					return fmt.Errorf("invalid %s: %v", name, err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Set assigns a field by its Go name from its string form, as read from the environment or a flag.
+func (cfg *ConfigType) Set(field string, value string) error {
+	f := reflect.ValueOf(cfg).Elem().FieldByName(field)
+	if !f.IsValid() {
+		return fmt.Errorf("unknown config field '%s'", field)
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		// any non empty value other than false enables it, so LOGGER=1 keeps working
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			v = value != ""
+		}
+		f.SetBool(v)
+	case reflect.Uint8, reflect.Uint32:
+		v, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(v)
+	default:
+		return fmt.Errorf("unsupported config field '%s'", field)
+	}
+	return nil
+}
+
+func (cfg *ConfigType) Validate() error {
+	switch cfg.Mode {
+	case ModeDevelopment, ModeProduction:
+	default:
+		return fmt.Errorf("invalid mode '%s', expected %s or %s", cfg.Mode, ModeDevelopment, ModeProduction)
+	}
+	switch cfg.Driver {
+	case DialectPostgres, DialectMySQL, DialectSQLite, DialectMemory:
+	default:
+		return fmt.Errorf("invalid driver '%s', expected %s, %s, %s or %s", cfg.Driver, DialectPostgres, DialectMySQL, DialectSQLite, DialectMemory)
+	}
+	switch cfg.LogFormat {
+	case LogFormatJSON, LogFormatText:
+	default:
+		return fmt.Errorf("invalid logFormat '%s', expected %s or %s", cfg.LogFormat, LogFormatJSON, LogFormatText)
+	}
+	if cfg.TokenSecret == "" {
+		return fmt.Errorf("tokenSecret is required")
+	}
+	switch cfg.TracingExporter {
+	case TracingNone, TracingStdout, TracingOTLP:
+	case TracingFile:
+		if cfg.TracingEndpoint == "" {
+			return fmt.Errorf("tracingEndpoint is required for the %s exporter", cfg.TracingExporter)
+		}
+	default:
+		return fmt.Errorf("invalid tracingExporter '%s', expected %s, %s or %s", cfg.TracingExporter, TracingStdout, TracingFile, TracingOTLP)
+	}
+	switch cfg.PersistedQueryStore {
+	case PersistedQueryStoreMemory:
+	case PersistedQueryStoreDatabase:
+		if cfg.Driver == DialectMemory {
+			return fmt.Errorf("persistedQueryStore %s requires a database driver", cfg.PersistedQueryStore)
+		}
+	default:
+		return fmt.Errorf("invalid persistedQueryStore '%s', expected %s or %s", cfg.PersistedQueryStore, PersistedQueryStoreMemory, PersistedQueryStoreDatabase)
+	}
+	if cfg.PersistedQueryCache == 0 {
+		return fmt.Errorf("persistedQueryCache must be positive")
+	}
+	if cfg.StrictOperations && cfg.OperationManifest == "" {
+		return fmt.Errorf("operationManifest is required for strictOperations")
+	}
+	if cfg.RateLimit > 0 && cfg.RateLimitBurst == 0 {
+		return fmt.Errorf("rateLimitBurst must be positive when rateLimit is set")
+	}
+	if _, err := ParseRateLimitOverrides(cfg.RateLimitOverrides); err != nil {
+		return err
+	}
+	if err := ValidTenant(cfg.Tenant); err != nil {
+		return err
+	}
+	// the webhook durations are in seconds
+	if cfg.WebhookMaxAttempts == 0 || cfg.WebhookInterval == 0 || cfg.WebhookTimeout == 0 {
+		return fmt.Errorf("webhookMaxAttempts, webhookInterval and webhookTimeout must be positive")
+	}
+	// so are the change feed ones
+	if cfg.ChangeFeedWait == 0 || cfg.ChangeFeedPoll == 0 {
+		return fmt.Errorf("changeFeedWait and changeFeedPoll must be positive")
+	}
+	if cfg.HashedPasswordLength == 0 || cfg.Argon2_Time == 0 || cfg.Argon2_Memory == 0 || cfg.Argon2_Thread == 0 {
+		return fmt.Errorf("argon2 parameters must be positive")
+	}
+	if cfg.Mode == ModeProduction {
+		if cfg.TokenSecret == DefaultTokenSecret {
+			return fmt.Errorf("refusing to run in %s mode with the default tokenSecret", cfg.Mode)
+		}
+		if cfg.Driver != DialectSQLite && cfg.Driver != DialectMemory && cfg.DSN == DefaultDSN {
+			return fmt.Errorf("refusing to run in %s mode with the default database credentials", cfg.Mode)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package graph_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("load yaml file and env", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(file, []byte("application: Books\nport: \"9000\"\nargon2Time: 5\n"), 0600)
+		cfg := graph.DefaultConfig()
+		assert.NoError(t, cfg.LoadFile(file))
+		assert.NoError(t, cfg.LoadEnv(func(name string) (string, bool) {
+			v, ok := map[string]string{"PORT": "9090", "LOGGER": "1"}[name]
+			return v, ok
+		}))
+		assert.Equal(t, "Books", cfg.Application)
+		assert.Equal(t, "9090", cfg.Port)
+		assert.Equal(t, true, cfg.LogSQL)
+		assert.Equal(t, uint32(5), cfg.Argon2_Time)
+		assert.Equal(t, uint8(2), cfg.Argon2_Thread)
+	})
+
+	t.Run("load toml file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "config.toml")
+		os.WriteFile(file, []byte("mode = \"production\"\ntokenSecret = \"s3cret\"\ndsn = \"host=db\"\n"), 0600)
+		cfg := graph.DefaultConfig()
+		assert.NoError(t, cfg.LoadFile(file))
+		assert.Equal(t, graph.ModeProduction, cfg.Mode)
+		assert.Equal(t, "s3cret", cfg.TokenSecret)
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("invalid env", func(t *testing.T) {
+		cfg := graph.DefaultConfig()
+		err := cfg.LoadEnv(func(name string) (string, bool) {
+			return "x", name == "ARGON2_THREAD"
+		})
+		assert.EqualError(t, err, `invalid ARGON2_THREAD: strconv.ParseUint: parsing "x": invalid syntax`)
+	})
+
+	t.Run("refuse default secrets in production", func(t *testing.T) {
+		cfg := graph.DefaultConfig()
+		assert.NoError(t, cfg.Validate())
+		cfg.Mode = graph.ModeProduction
+		assert.EqualError(t, cfg.Validate(), "refusing to run in production mode with the default tokenSecret")
+		cfg.TokenSecret = "s3cret"
+		assert.EqualError(t, cfg.Validate(), "refusing to run in production mode with the default database credentials")
+		cfg.DSN = "host=db"
+		assert.NoError(t, cfg.Validate())
+		cfg.Mode = "staging"
+		assert.EqualError(t, cfg.Validate(), "invalid mode 'staging', expected development or production")
+	})
+
+	t.Run("tracing exporter", func(t *testing.T) {
+		cfg := graph.DefaultConfig()
+		cfg.TracingExporter = graph.TracingFile
+		assert.EqualError(t, cfg.Validate(), "tracingEndpoint is required for the file exporter")
+		cfg.TracingEndpoint = "traces.json"
+		assert.NoError(t, cfg.Validate())
+		cfg.TracingExporter = "zipkin"
+		assert.EqualError(t, cfg.Validate(), "invalid tracingExporter 'zipkin', expected stdout, file or otlp")
+	})
+}
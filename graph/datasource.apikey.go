@@ -0,0 +1,100 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func (ds *DataSource) APIKeys(ctx context.Context, includeRevoked *bool) ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	tx := ds.DB.WithContext(ctx)
+	if includeRevoked == nil || !*includeRevoked {
+		tx = tx.Where("revoked_at IS NULL")
+	}
+	result := tx.Order("id").Find(&keys)
+	return keys, result.Error
+}
+
+func (ds *DataSource) APIKeyByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	var key model.APIKey
+	result := ds.DB.WithContext(ctx).Preload("User").Where("prefix = ?", prefix).Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+// auditAPIKey leaves the hash out of the audit trail.
+func auditAPIKey(key *model.APIKey) *model.APIKey {
+	if key == nil {
+		return nil
+	}
+	c := *key
+	c.Hash = ""
+	return &c
+}
+
+func (ds *DataSource) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	if key.UserID != nil {
+		var count int64
+		if err := ds.DB.WithContext(ctx).Model(&model.User{}).Where("id = ?", *key.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("user with id '%v' does not exist", *key.UserID)
+		}
+	}
+	key.CreatedBy = Actor(ctx)
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Create(key)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "api_keys", key.ID, nil, auditAPIKey(key)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+	tx.Rollback()
+	return fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
+
+func (ds *DataSource) RevokeAPIKey(ctx context.Context, id int, at time.Time) (*model.APIKey, error) {
+	var key model.APIKey
+	result := ds.DB.WithContext(ctx).Where("id = ?", id).Where("revoked_at IS NULL").Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("active api key with id '%v' does not exist", id)
+	}
+	before := auditAPIKey(&key)
+	key.RevokedAt = &at
+	tx := ds.DB.WithContext(ctx).Begin()
+	result = tx.Model(&key).Where("revoked_at IS NULL").Update("revoked_at", at)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionUpdate, "api_keys", key.ID, before, auditAPIKey(&key)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		result = tx.Commit()
+		return &key, result.Error
+	}
+	tx.Rollback()
+	return nil, fmt.Errorf("active api key with id '%v' does not exist", id)
+}
+
+func (ds *DataSource) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	return ds.DB.WithContext(ctx).Model(&model.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
@@ -0,0 +1,269 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/graph-gophers/dataloader"
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const Context_Actor = ContextID("Actor")
+const Context_Operation = ContextID("Operation")
+
+func Actor(ctx context.Context) *string {
+	if actor, ok := ctx.Value(Context_Actor).(string); ok && actor != "" {
+		return &actor
+	}
+	return nil
+}
+
+func OperationName(ctx context.Context) string {
+	if op, ok := ctx.Value(Context_Operation).(string); ok && op != "" {
+		return op
+	}
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil {
+		return ""
+	}
+	for fc.Parent != nil && fc.Parent.Field.Field != nil {
+		fc = fc.Parent
+	}
+	return fc.Field.Name
+}
+
+// Snapshot renders a model as its column values rather than its json representation, so hidden
+// foreign keys like books.series_id survive, along with any has-many or many2many association loaded.
+func (ds *DataSource) Snapshot(ctx context.Context, value interface{}) (map[string]interface{}, error) {
+	return Snapshot(ctx, ds.DB.NamingStrategy, value)
+}
+
+// FromSnapshot is the reverse of Snapshot, dest must be a pointer to the model.
+func (ds *DataSource) FromSnapshot(ctx context.Context, dest interface{}, snapshot map[string]interface{}) error {
+	return FromSnapshot(ctx, ds.DB.NamingStrategy, dest, snapshot)
+}
+
+var snapshotSchemas sync.Map
+
+func Snapshot(ctx context.Context, namer schema.Namer, value interface{}) (map[string]interface{}, error) {
+	s, err := schema.Parse(value, &snapshotSchemas, namer)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	res := map[string]interface{}{}
+	for _, f := range s.Fields {
+		if f.DBName == "" {
+			continue
+		}
+		if f.Serializer != nil {
+			// ValueOf wraps serialized fields for the statement, the snapshot keeps the plain value
+			res[f.DBName] = f.ReflectValueOf(ctx, rv).Interface()
+		} else {
+			v, _ := f.ValueOf(ctx, rv)
+			res[f.DBName] = v
+		}
+	}
+	for name, rel := range s.Relationships.Relations {
+		if rel.Field.Schema != s || (rel.Type != schema.HasMany && rel.Type != schema.Many2Many) {
+			continue
+		}
+		rf := rel.Field.ReflectValueOf(ctx, rv)
+		if rf.IsNil() {
+			continue
+		}
+		list := []map[string]interface{}{}
+		for i, il := 0, rf.Len(); i < il; i++ {
+			if v, err := Snapshot(ctx, namer, rf.Index(i).Interface()); err != nil {
+				return nil, err
+			} else {
+				list = append(list, v)
+			}
+		}
+		res[namer.ColumnName("", name)] = list
+	}
+	return res, nil
+}
+
+func FromSnapshot(ctx context.Context, namer schema.Namer, dest interface{}, snapshot map[string]interface{}) error {
+	s, err := schema.Parse(dest, &snapshotSchemas, namer)
+	if err != nil {
+		return err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(dest))
+	for _, f := range s.Fields {
+		v, ok := snapshot[f.DBName]
+		if f.DBName == "" || !ok {
+			continue
+		}
+		if str, ok := v.(string); ok && (f.FieldType == reflect.TypeOf(time.Time{}) || f.FieldType == reflect.TypeOf(&time.Time{})) {
+			if t, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return err
+			} else {
+				v = t
+			}
+		}
+		if err := f.Set(ctx, rv, v); err != nil {
+			return err
+		}
+	}
+	for name, rel := range s.Relationships.Relations {
+		list, ok := snapshot[namer.ColumnName("", name)].([]interface{})
+		if !ok || rel.Field.Schema != s || (rel.Type != schema.HasMany && rel.Type != schema.Many2Many) {
+			continue
+		}
+		rf := rel.Field.ReflectValueOf(ctx, rv)
+		rs := reflect.MakeSlice(rf.Type(), 0, len(list))
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid snapshot of %s.%s", s.Table, name)
+			}
+			elem := reflect.New(rel.FieldSchema.ModelType)
+			if err := FromSnapshot(ctx, namer, elem.Interface(), m); err != nil {
+				return err
+			}
+			if rf.Type().Elem().Kind() == reflect.Ptr {
+				rs = reflect.Append(rs, elem)
+			} else {
+				rs = reflect.Append(rs, elem.Elem())
+			}
+		}
+		rf.Set(rs)
+	}
+	return nil
+}
+
+// NewAuditEntry records the snapshots of a change, before is nil for inserts and after is nil for
+// deletes.
+func NewAuditEntry(ctx context.Context, namer schema.Namer, action model.AuditAction, entity string, id int, before interface{}, after interface{}) (*model.AuditEntry, error) {
+	entry := &model.AuditEntry{
+		Entity:    entity,
+		EntityID:  id,
+		Action:    action,
+		Operation: OperationName(ctx),
+		Actor:     Actor(ctx),
+		At:        time.Now(),
+	}
+	if before != nil {
+		if m, err := Snapshot(ctx, namer, before); err != nil {
+			return nil, err
+		} else if v, err := json.Marshal(m); err != nil {
+			return nil, err
+		} else {
+			entry.Before = Of(string(v))
+		}
+	}
+	if after != nil {
+		if m, err := Snapshot(ctx, namer, after); err != nil {
+			return nil, err
+		} else if v, err := json.Marshal(m); err != nil {
+			return nil, err
+		} else {
+			entry.After = Of(string(v))
+		}
+	}
+	return entry, nil
+}
+
+// BookOf restores the book an audit entry left behind, nil when the entry deleted it.
+func BookOf(ctx context.Context, namer schema.Namer, entry *model.AuditEntry) (*model.Book, error) {
+	if entry.After == nil {
+		return nil, nil
+	}
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal([]byte(*entry.After), &snapshot); err != nil {
+		return nil, err
+	}
+	var book model.Book
+	if err := FromSnapshot(ctx, namer, &book, snapshot); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func (ds *DataSource) Audit(ctx context.Context, tx *gorm.DB, action model.AuditAction, entity string, id int, before interface{}, after interface{}) error {
+	entry, err := NewAuditEntry(ctx, ds.DB.NamingStrategy, action, entity, id, before, after)
+	if err != nil {
+		return err
+	}
+	result := tx.Create(entry)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected != 1 {
+		return fmt.Errorf("RowsAffected %v", result.RowsAffected)
+	}
+	if err := ds.recordChange(ctx, tx, action, entity, id, before, after, entry.At); err != nil {
+		return err
+	}
+	return ds.enqueueWebhooks(ctx, tx, action, entity, id, before, after, entry.At)
+}
+
+func (ds *DataSource) BookHistory(ctx context.Context, obj *model.Book) ([]*model.AuditEntry, error) {
+	var scopeFn = func(bookIDs []int) func(tx *gorm.DB) *gorm.DB {
+		return func(tx *gorm.DB) *gorm.DB {
+			tx.Where("audit_entries.entity = ?", "books")
+			tx.Where("audit_entries.entity_id IN ?", bookIDs)
+			tx.Order("audit_entries.at").Order("audit_entries.id")
+			return tx
+		}
+	}
+	tx := ds.dryRun().Scopes(scopeFn([]int{obj.ID})).Find(&model.AuditEntry{})
+	group := tx.Statement.SQL.String()
+	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
+		ids := make([]int, len(keys))
+		for i, k := range keys {
+			ids[i] = k.Param.(*model.Book).ID
+		}
+		var entries []*model.AuditEntry
+		result := ds.DB.WithContext(ctx).Scopes(scopeFn(ids)).Find(&entries)
+		if result.Error != nil {
+			return &dataloader.Result{
+				Error: result.Error,
+			}
+		}
+		return &dataloader.Result{
+			Data: entries,
+		}
+	}
+	filterFn := func(key *BatchLoaderKey, groupResults *dataloader.Result) *dataloader.Result {
+		if groupResults.Error != nil {
+			return groupResults
+		}
+		book := key.Param.(*model.Book)
+		gentries := groupResults.Data.([]*model.AuditEntry)
+		entries := []*model.AuditEntry{}
+		for _, e := range gentries {
+			if e.EntityID == book.ID {
+				entries = append(entries, e)
+			}
+		}
+		return &dataloader.Result{Data: entries}
+	}
+	data, err := ds.BatchLoad(ctx, &group, key, []int{obj.ID}, obj, queryFn, filterFn)
+	if data != nil {
+		return data.([]*model.AuditEntry), err
+	}
+	return nil, err
+}
+
+func (ds *DataSource) BookAsOf(ctx context.Context, id int, at time.Time) (*model.Book, error) {
+	var entry model.AuditEntry
+	result := ds.DB.WithContext(ctx).Where("audit_entries.entity = ?", "books").Where("audit_entries.entity_id = ?", id).Where("audit_entries.at <= ?", at).
+		Order("audit_entries.at DESC").Order("audit_entries.id DESC").Limit(1).Find(&entry)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	return BookOf(ctx, ds.DB.NamingStrategy, &entry)
+}
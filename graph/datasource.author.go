@@ -15,58 +15,104 @@ func (ds *DataSource) CreateAuthor(ctx context.Context, input model.NewAuthor) (
 	author := &model.Author{
 		Name: input.Name,
 	}
-	result := ds.DB.Create(author)
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Create(author)
 	if result.Error != nil {
+		tx.Rollback()
 		return author, result.Error
 	} else if result.RowsAffected == 1 {
-		return author, nil
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "authors", author.ID, nil, author); err != nil {
+			tx.Rollback()
+			return author, err
+		}
+		result = tx.Commit()
+		return author, result.Error
 	}
+	tx.Rollback()
 	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
 }
 
 func (ds *DataSource) Authors(ctx context.Context, offset *int, limit *int, filter *model.AuthorFilter) (*model.AuthorList, error) {
+	needCount := false
 	var fields []string
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
-		fields = append(fields, f.Name)
+		switch f.Name {
+		case "count":
+			needCount = true
+		case "list":
+			for _, f := range graphql.CollectFields(graphql.GetOperationContext(ctx), f.SelectionSet, nil) {
+				fields = append(fields, ds.Quote("authors."+f.Name))
+			}
+		}
+	}
+	var scopeFn = func(offset *int, limit *int) func(tx *gorm.DB) *gorm.DB {
+		return func(tx *gorm.DB) *gorm.DB {
+			tx.Model(&model.Author{})
+			if filter != nil {
+				if filter.ID != nil {
+					tx.Where("authors.id = ?", filter.ID)
+				}
+				if filter.Name != nil {
+					FilterText(filter.Name, tx, "authors.name")
+				}
+			}
+			if offset != nil {
+				tx.Offset(*offset)
+			}
+			if limit != nil {
+				tx.Limit(*limit)
+			}
+			return tx
+		}
 	}
 	var authors []*model.Author
 	var count int64
-	result := ds.DB.Select(fields).Find(&authors).Count(&count)
+	if needCount {
+		result := ds.DB.WithContext(ctx).Scopes(scopeFn(nil, nil)).Count(&count)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if count == 0 {
+			return &model.AuthorList{List: []*model.Author{}, Count: 0}, nil
+		}
+	}
+	result := ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(offset, limit)).Find(&authors)
 	if result.Error != nil {
 		return nil, result.Error
 	}
-
 	return &model.AuthorList{List: authors, Count: int(count)}, nil
 }
 
 func (ds *DataSource) BookAuthors(ctx context.Context, obj *model.Book) ([]*model.Author, error) {
-	fields := []string{`"book_authors"."book_id"`}
+	fields := []string{ds.Quote("book_authors.book_id")}
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
-		fields = append(fields, fmt.Sprintf(`"authors"."%s"`, f.Name))
+		fields = append(fields, ds.Quote("authors."+f.Name))
 	}
 	type bookAuthor struct {
 		Book_ID int
 		ID      int
 		Name    string
+		Version int
 	}
 	var scopeFn = func(bookIDs []int) func(tx *gorm.DB) *gorm.DB {
 		return func(tx *gorm.DB) *gorm.DB {
 			tx.Model(&model.Author{})
 			tx.Joins("JOIN book_authors ON authors.id = book_authors.author_id")
 			tx.Where("book_authors.book_id IN ?", bookIDs)
+			tx.Order("authors.id")
 			return tx
 		}
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Scopes(scopeFn([]int{obj.ID})).Find(&model.Book{})
+	tx := ds.dryRun().Scopes(scopeFn([]int{obj.ID})).Find(&model.Book{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		ids := make([]int, len(keys))
 		for i, k := range keys {
 			ids[i] = k.Param.(*model.Book).ID
 		}
 		var authors []*bookAuthor
-		result := ds.DB.Select(fields).Scopes(scopeFn(ids)).Find(&authors)
+		result := ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(ids)).Find(&authors)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
@@ -86,8 +132,9 @@ func (ds *DataSource) BookAuthors(ctx context.Context, obj *model.Book) ([]*mode
 		for _, a := range gauthors {
 			if a.Book_ID == book.ID {
 				authors = append(authors, &model.Author{
-					ID:   a.ID,
-					Name: a.Name,
+					ID:      a.ID,
+					Name:    a.Name,
+					Version: a.Version,
 				})
 			}
 		}
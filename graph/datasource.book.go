@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/graph-gophers/dataloader"
@@ -13,7 +14,7 @@ import (
 
 func (ds *DataSource) CreateBook(ctx context.Context, input model.NewBook) (*model.Book, error) {
 	var authors []*model.Author
-	result := ds.DB.Where("name IN (?)", input.AuthorsName).Find(&authors)
+	result := ds.DB.WithContext(ctx).Where("name IN (?)", input.AuthorsName).Order("authors.id").Find(&authors)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -36,40 +37,51 @@ func (ds *DataSource) CreateBook(ctx context.Context, input model.NewBook) (*mod
 		Title:   input.Title,
 		Authors: authors,
 	}
-	result = ds.DB.Omit("Authors.*").Create(book)
+	tx := ds.DB.WithContext(ctx).Begin()
+	result = tx.Omit("Authors.*").Create(book)
 	if result.Error != nil {
-		emsg := result.Error.Error()
-		if strings.Contains(emsg, "duplicate key value violates unique constraint") {
-			if strings.Contains(emsg, `"books_title_key"`) {
-				return book, fmt.Errorf(`duplicate key books.title "%s"`, book.Title)
-			}
+		tx.Rollback()
+		if ds.IsUniqueViolation(result.Error, "books", "title") {
+			return book, fmt.Errorf(`duplicate key books.title "%s"`, book.Title)
 		}
 		return book, result.Error
 	} else if result.RowsAffected == 1 {
-		return book, nil
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "books", book.ID, nil, book); err != nil {
+			tx.Rollback()
+			return book, err
+		}
+		result = tx.Commit()
+		return book, result.Error
 	}
+	tx.Rollback()
 	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
 }
 
 func (ds *DataSource) UpdateBook(ctx context.Context, input model.UpdateBook) (*model.Book, error) {
 	var book model.Book
-	result := ds.DB.Where("books.id = ?", input.ID).Limit(1).Find(&book)
+	result := ds.DB.WithContext(ctx).Preload("Authors").Where("books.id = ?", input.ID).Where("books.deleted_at IS NULL").Limit(1).Find(&book)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected != 1 {
 		return nil, fmt.Errorf("book with id '%v' does not exist", input.ID)
 	}
-	fields := []string{}
+	if book.Version != input.ExpectedVersion {
+		return nil, ds.bookConflict(ctx, input.ID, input.ExpectedVersion)
+	}
+	before := book
+	book.Version = input.ExpectedVersion + 1
+	fields := []string{"version"}
 	if input.Title != nil {
 		book.Title = *input.Title
 		fields = append(fields, "title")
 	}
-	tx := ds.DB.Begin()
+	tx := ds.DB.WithContext(ctx).Begin()
 	if input.AuthorsName != nil {
 		var authors []*model.Author
-		result := ds.DB.Where("name IN (?)", input.AuthorsName).Find(&authors)
+		result := tx.Where("name IN (?)", input.AuthorsName).Order("authors.id").Find(&authors)
 		if result.Error != nil {
+			tx.Rollback()
 			return nil, result.Error
 		}
 		if len(authors) != len(input.AuthorsName) {
@@ -100,44 +112,147 @@ func (ds *DataSource) UpdateBook(ctx context.Context, input model.UpdateBook) (*
 			return &book, result.Error
 		}
 	}
-	result = tx.Select(fields).Omit("Authors.*").Updates(&book)
+	result = tx.Select(fields).Omit("Authors.*").Where("version = ?", input.ExpectedVersion).Updates(&book)
 	if result.Error != nil {
-		emsg := result.Error.Error()
-		if strings.Contains(emsg, "duplicate key value violates unique constraint") {
-			if strings.Contains(emsg, `"books_title_key"`) {
-				tx.Rollback()
-				return &book, fmt.Errorf(`duplicate key books.title "%s"`, book.Title)
-			}
-		}
 		tx.Rollback()
+		if ds.IsUniqueViolation(result.Error, "books", "title") {
+			return &book, fmt.Errorf(`duplicate key books.title "%s"`, book.Title)
+		}
 		return &book, result.Error
 	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionUpdate, "books", book.ID, &before, &book); err != nil {
+			tx.Rollback()
+			return &book, err
+		}
 		result = tx.Commit()
 		return &book, result.Error
+	} else if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ds.bookConflict(ctx, input.ID, input.ExpectedVersion)
 	}
 	tx.Rollback()
 	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
 }
 
+func (ds *DataSource) bookConflict(ctx context.Context, id int, expectedVersion int) error {
+	var book model.Book
+	result := ds.DB.WithContext(ctx).Preload("Authors").Where("books.id = ?", id).Limit(1).Find(&book)
+	if result.Error != nil {
+		return result.Error
+	}
+	return NewConflictError(fmt.Sprintf("book with id '%v' has version %v, expected %v", id, book.Version, expectedVersion), &book)
+}
+
 func (ds *DataSource) DeleteBook(ctx context.Context, id int) (*model.Book, error) {
 	var book model.Book
-	result := ds.DB.Where("books.id = ?", id).Limit(1).Find(&book)
+	result := ds.DB.WithContext(ctx).Preload("Authors").Where("books.id = ?", id).Where("books.deleted_at IS NULL").Limit(1).Find(&book)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected != 1 {
 		return nil, fmt.Errorf("book with id '%v' does not exist", id)
 	}
-	result = ds.DB.Delete(&book)
+	before := book
+	book.DeletedAt = Of(time.Now())
+	book.Version = before.Version + 1
+	tx := ds.DB.WithContext(ctx).Begin()
+	result = tx.Model(&book).Select("deleted_at", "version").Where("version = ?", before.Version).Updates(&book)
 	if result.Error != nil {
+		tx.Rollback()
 		return &book, result.Error
 	} else if result.RowsAffected == 1 {
-		return &book, nil
+		if err := ds.Audit(ctx, tx, model.AuditActionDelete, "books", book.ID, &before, &book); err != nil {
+			tx.Rollback()
+			return &book, err
+		}
+		result = tx.Commit()
+		return &book, result.Error
+	} else if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ds.bookConflict(ctx, id, before.Version)
 	}
+	tx.Rollback()
 	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
 }
 
-func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter) (*model.BookList, error) {
+func (ds *DataSource) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	var book model.Book
+	result := ds.DB.WithContext(ctx).Preload("Authors").Where("books.id = ?", id).Where("books.deleted_at IS NOT NULL").Limit(1).Find(&book)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("deleted book with id '%v' does not exist", id)
+	}
+	before := book
+	book.DeletedAt = nil
+	book.Version = before.Version + 1
+	tx := ds.DB.WithContext(ctx).Begin()
+	result = tx.Model(&book).Select("deleted_at", "version").Where("version = ?", before.Version).Updates(&book)
+	if result.Error != nil {
+		tx.Rollback()
+		return &book, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionUpdate, "books", book.ID, &before, &book); err != nil {
+			tx.Rollback()
+			return &book, err
+		}
+		result = tx.Commit()
+		return &book, result.Error
+	} else if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ds.bookConflict(ctx, id, before.Version)
+	}
+	tx.Rollback()
+	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
+
+func (ds *DataSource) PurgeDeleted(ctx context.Context, olderThan time.Time) (int, error) {
+	var books []*model.Book
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Preload("Authors").Where("books.deleted_at < ?", olderThan).Find(&books)
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	if len(books) == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+	ids := make([]int, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+	// the foreign key cascades are not relied on, sqlite does not enforce them by default
+	result = tx.Where("book_id IN ?", ids).Delete(&model.Review{})
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	result = tx.Exec("DELETE FROM book_authors WHERE book_id IN ?", ids)
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	result = tx.Where("books.id IN ?", ids).Delete(&model.Book{})
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	for _, b := range books {
+		if err := ds.Audit(ctx, tx, model.AuditActionDelete, "books", b.ID, b, nil); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	result = tx.Commit()
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return len(ids), nil
+}
+
+func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
 	needCount := false
 	var fields []string
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
@@ -147,9 +262,9 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 		case "list":
 			for _, f := range graphql.CollectFields(graphql.GetOperationContext(ctx), f.SelectionSet, nil) {
 				switch f.Name {
-				case "authors", "reviews":
+				case "authors", "reviews", "history":
 				default:
-					fields = append(fields, fmt.Sprintf(`"books"."%s"`, f.Name))
+					fields = append(fields, ds.Quote("books."+ds.DB.NamingStrategy.ColumnName("", f.Name)))
 				}
 			}
 		}
@@ -157,6 +272,9 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 	var scopeFn = func(offset *int, limit *int) func(tx *gorm.DB) *gorm.DB {
 		return func(tx *gorm.DB) *gorm.DB {
 			tx.Model(&model.Book{})
+			if includeDeleted == nil || !*includeDeleted {
+				tx.Where("books.deleted_at IS NULL")
+			}
 			if filter != nil {
 				if filter.ID != nil {
 					tx.Where("books.id = ?", filter.ID)
@@ -165,7 +283,7 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 					FilterText(filter.Title, tx, "books.title")
 				}
 				if filter.AuthorName != nil {
-					sq := ds.DB.Select("book_id")
+					sq := tx.Session(&gorm.Session{NewDB: true}).Select("book_id")
 					sq.Joins("JOIN book_authors ON authors.id = book_authors.author_id")
 					op := FilterSubQueryText(filter.AuthorName, sq, `authors.name`)
 					tx.Where(fmt.Sprintf("books.id %s (?)", op), sq.Model(&model.Author{}))
@@ -173,7 +291,7 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 				if filter.Star != nil && (filter.Star.Min != nil || filter.Star.Max != nil) {
 					tx.Distinct()
 					tx.Joins("JOIN reviews ON books.id = reviews.book_id")
-					FilterIntRange(filter.Star, tx, `"reviews"."star"`)
+					FilterIntRange(filter.Star, tx, ds.Quote("reviews.star"))
 				}
 			}
 			if offset != nil {
@@ -185,15 +303,15 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 			return tx
 		}
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
+	tx := ds.dryRun().Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		var books []*model.Book
 		var count int64
 		var result *gorm.DB
 		if needCount {
-			result = ds.DB.Scopes(scopeFn(nil, nil)).Count(&count)
+			result = ds.DB.WithContext(ctx).Scopes(scopeFn(nil, nil)).Count(&count)
 			if result.Error != nil {
 				return &dataloader.Result{
 					Error: result.Error,
@@ -205,7 +323,7 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 				}
 			}
 		}
-		result = ds.DB.Select(fields).Scopes(scopeFn(offset, limit)).Find(&books)
+		result = ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(offset, limit)).Find(&books)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
@@ -222,7 +340,7 @@ func (ds *DataSource) Books(ctx context.Context, offset *int, limit *int, filter
 	return nil, err
 }
 
-func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter) (*model.BookList, error) {
+func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
 	needCount := false
 	var fields []string
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
@@ -232,9 +350,9 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 		case "list":
 			for _, f := range graphql.CollectFields(graphql.GetOperationContext(ctx), f.SelectionSet, nil) {
 				switch f.Name {
-				case "authors", "reviews":
+				case "authors", "reviews", "history":
 				default:
-					fields = append(fields, fmt.Sprintf(`"books"."%s"`, f.Name))
+					fields = append(fields, ds.Quote("books."+ds.DB.NamingStrategy.ColumnName("", f.Name)))
 				}
 			}
 		}
@@ -243,6 +361,9 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 		return func(tx *gorm.DB) *gorm.DB {
 			tx.Model(&model.Book{})
          tx.Where("books.series_id = ?", obj.ID)
+			if includeDeleted == nil || !*includeDeleted {
+				tx.Where("books.deleted_at IS NULL")
+			}
 			if filter != nil {
 				if filter.ID != nil {
 					tx.Where("books.id = ?", filter.ID)
@@ -251,7 +372,7 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 					FilterText(filter.Title, tx, "books.title")
 				}
 				if filter.AuthorName != nil {
-					sq := ds.DB.Select("book_id")
+					sq := tx.Session(&gorm.Session{NewDB: true}).Select("book_id")
 					sq.Joins("JOIN book_authors ON authors.id = book_authors.author_id")
 					op := FilterSubQueryText(filter.AuthorName, sq, `authors.name`)
 					tx.Where(fmt.Sprintf("books.id %s (?)", op), sq.Model(&model.Author{}))
@@ -259,7 +380,7 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 				if filter.Star != nil && (filter.Star.Min != nil || filter.Star.Max != nil) {
 					tx.Distinct()
 					tx.Joins("JOIN reviews ON books.id = reviews.book_id")
-					FilterIntRange(filter.Star, tx, `"reviews"."star"`)
+					FilterIntRange(filter.Star, tx, ds.Quote("reviews.star"))
 				}
 			}
 			if offset != nil {
@@ -271,15 +392,15 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 			return tx
 		}
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
+	tx := ds.dryRun().Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		var books []*model.Book
 		var count int64
 		var result *gorm.DB
 		if needCount {
-			result = ds.DB.Scopes(scopeFn(nil, nil)).Count(&count)
+			result = ds.DB.WithContext(ctx).Scopes(scopeFn(nil, nil)).Count(&count)
 			if result.Error != nil {
 				return &dataloader.Result{
 					Error: result.Error,
@@ -291,7 +412,7 @@ func (ds *DataSource) BooksSeriesBooks(ctx context.Context, obj *model.BookSerie
 				}
 			}
 		}
-		result = ds.DB.Select(fields).Scopes(scopeFn(offset, limit)).Find(&books)
+		result = ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(offset, limit)).Find(&books)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
@@ -312,27 +433,32 @@ func (ds *DataSource) ReviewBook(ctx context.Context, obj *model.Review) (*model
 	fields := []string{}
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
 		switch f.Name {
-		case "authors", "reviews":
+		case "authors", "reviews", "history":
 		default:
-			fields = append(fields, fmt.Sprintf(`"books"."%s"`, f.Name))
+			fields = append(fields, ds.Quote("books."+ds.DB.NamingStrategy.ColumnName("", f.Name)))
 		}
 	}
+	// the book of a review is gone with the book for everyone but admins, like in the book lists
+	includeDeleted := HasRoleOf(ctx, RoleAdmin)
 	var scopeFn = func(bookIDs []int) func(tx *gorm.DB) *gorm.DB {
 		return func(tx *gorm.DB) *gorm.DB {
 			tx.Where("books.id IN ?", bookIDs)
+			if !includeDeleted {
+				tx.Where("books.deleted_at IS NULL")
+			}
 			return tx
 		}
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Select(fields).Scopes(scopeFn([]int{obj.BookID})).Find(&model.Book{})
+	tx := ds.dryRun().Select(fields).Scopes(scopeFn([]int{obj.BookID})).Find(&model.Book{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		ids := make([]int, len(keys))
 		for i, k := range keys {
 			ids[i] = k.Param.(*model.Review).BookID
 		}
 		var books []*model.Book
-		result := ds.DB.Select(fields).Scopes(scopeFn(ids)).Find(&books)
+		result := ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(ids)).Find(&books)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
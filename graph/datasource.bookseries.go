@@ -2,7 +2,6 @@ package graph
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/graph-gophers/dataloader"
@@ -22,7 +21,7 @@ func (ds *DataSource) BookSeries(ctx context.Context, offset *int, limit *int, f
 				switch f.Name {
             case "books":
 				default:
-					fields = append(fields, fmt.Sprintf(`"book_series"."%s"`, f.Name))
+					fields = append(fields, ds.Quote("book_series."+f.Name))
 				}
 			}
 		}
@@ -47,15 +46,15 @@ func (ds *DataSource) BookSeries(ctx context.Context, offset *int, limit *int, f
 			return tx
 		}
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
+	tx := ds.dryRun().Select(fields).Scopes(scopeFn(offset, limit)).Find(&model.Book{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		var bookSeries []*model.BookSeries
 		var count int64
 		var result *gorm.DB
 		if needCount {
-			result = ds.DB.Scopes(scopeFn(nil, nil)).Count(&count)
+			result = ds.DB.WithContext(ctx).Scopes(scopeFn(nil, nil)).Count(&count)
 			if result.Error != nil {
 				return &dataloader.Result{
 					Error: result.Error,
@@ -67,7 +66,7 @@ func (ds *DataSource) BookSeries(ctx context.Context, offset *int, limit *int, f
 				}
 			}
 		}
-		result = ds.DB.Select(fields).Scopes(scopeFn(offset, limit)).Find(&bookSeries)
+		result = ds.DB.WithContext(ctx).Select(fields).Scopes(scopeFn(offset, limit)).Find(&bookSeries)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
@@ -0,0 +1,35 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm"
+)
+
+// recordChange writes the change event of an audited change in the transaction of the change.
+func (ds *DataSource) recordChange(ctx context.Context, tx *gorm.DB, action model.AuditAction, entity string, id int, before interface{}, after interface{}, at time.Time) error {
+	event, err := NewChangeEvent(ctx, ds.DB.NamingStrategy, action, entity, id, before, after, at)
+	if err != nil || event == nil {
+		return err
+	}
+	result := tx.Create(event)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected != 1 {
+		return fmt.Errorf("RowsAffected %v", result.RowsAffected)
+	}
+	return nil
+}
+
+func (ds *DataSource) Changes(ctx context.Context, since model.Cursor, limit int) (*model.ChangeFeed, error) {
+	var events []*model.ChangeEvent
+	// gaps are found among the events of every tenant, changeFeed leaves out the other tenants
+	result := ds.DB.WithContext(AnyTenant(ctx)).Where("id > ?", int(since)).Order("id").Limit(limit + 1).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return changeFeed(ctx, ds.DB.Config, events, since, limit, time.Now()), nil
+}
@@ -0,0 +1,210 @@
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatCSV    ExportFormat = "csv"
+	ExportFormatJSONL  ExportFormat = "jsonl"
+	ExportFormatNDJSON ExportFormat = "ndjson"
+)
+
+func (e ExportFormat) IsValid() bool {
+	switch e {
+	case ExportFormatCSV, ExportFormatJSONL, ExportFormatNDJSON:
+		return true
+	}
+	return false
+}
+
+func (e ExportFormat) ContentType() string {
+	switch e {
+	case ExportFormatCSV:
+		return "text/csv"
+	case ExportFormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/jsonl"
+}
+
+type ExportRow struct {
+	ID      int      `json:"id"`
+	Title   string   `json:"title"`
+	Series  *string  `json:"series"`
+	Authors []string `json:"authors"`
+	// ReviewCount is named apart from the reviews an import takes, which are not exported
+	ReviewCount int      `json:"review_count"`
+	Rating      *float64 `json:"rating"`
+}
+
+type exportWriter interface {
+	Write(row *ExportRow) error
+	Flush() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) Write(row *ExportRow) error {
+	series, rating := "", ""
+	if row.Series != nil {
+		series = *row.Series
+	}
+	if row.Rating != nil {
+		rating = strconv.FormatFloat(*row.Rating, 'f', 2, 64)
+	}
+	return e.w.Write([]string{strconv.Itoa(row.ID), row.Title, series, strings.Join(row.Authors, ";"), strconv.Itoa(row.ReviewCount), rating})
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonExportWriter) Write(row *ExportRow) error {
+	return e.enc.Encode(row)
+}
+
+func (e *jsonExportWriter) Flush() error {
+	return nil
+}
+
+func newExportWriter(w io.Writer, format ExportFormat) (exportWriter, error) {
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "title", "series", "authors", "review_count", "rating"}); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{w: cw}, nil
+	case ExportFormatJSONL, ExportFormatNDJSON:
+		return &jsonExportWriter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format '%s'", format)
+}
+
+// flushExport pushes buffered rows to the client every 100 rows so a long export streams.
+func flushExport(w io.Writer, out exportWriter, count int) error {
+	if count%100 != 0 {
+		return nil
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// ExportBooks streams every book that is not deleted, one row per book, with its authors, series
+// and aggregated review rating. Rows are read from a database cursor and written as they are
+// scanned, so memory use does not grow with the size of the catalog.
+func (ds *DataSource) ExportBooks(ctx context.Context, w io.Writer, format ExportFormat) (int, error) {
+	out, err := newExportWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	ratings := ds.DB.WithContext(ctx).Model(&model.Review{}).Select("reviews.book_id, COUNT(*) AS reviews, AVG(reviews.star) AS rating").Group("reviews.book_id")
+	rows, err := ds.DB.WithContext(ctx).Model(&model.Book{}).
+		Select("books.id, books.title, book_series.title, authors.name, ratings.reviews, ratings.rating").
+		Joins("LEFT JOIN book_series ON book_series.id = books.series_id").
+		Joins("LEFT JOIN book_authors ON book_authors.book_id = books.id").
+		Joins("LEFT JOIN authors ON authors.id = book_authors.author_id").
+		Joins("LEFT JOIN (?) AS ratings ON ratings.book_id = books.id", ratings).
+		Where("books.deleted_at IS NULL").
+		Order("books.id").Order("authors.name").
+		Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	var row *ExportRow
+	emit := func() error {
+		if row == nil {
+			return nil
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+		count++
+		return flushExport(w, out, count)
+	}
+	for rows.Next() {
+		var id int
+		var title string
+		var series, author sql.NullString
+		var reviews sql.NullInt64
+		var rating sql.NullFloat64
+		if err := rows.Scan(&id, &title, &series, &author, &reviews, &rating); err != nil {
+			return count, err
+		}
+		if row == nil || row.ID != id {
+			if err := emit(); err != nil {
+				return count, err
+			}
+			row = &ExportRow{ID: id, Title: title, Authors: []string{}, ReviewCount: int(reviews.Int64)}
+			if series.Valid {
+				row.Series = &series.String
+			}
+			if rating.Valid {
+				row.Rating = &rating.Float64
+			}
+		}
+		if author.Valid {
+			row.Authors = append(row.Authors, author.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if err := emit(); err != nil {
+		return count, err
+	}
+	return count, out.Flush()
+}
+
+func ExportHandler(repo BookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := ExportFormat(strings.ToLower(r.URL.Query().Get("format")))
+		if format == "" {
+			format = ExportFormatNDJSON
+		}
+		if !format.IsValid() {
+			http.Error(w, fmt.Sprintf("unsupported export format '%s'", format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="books.%s"`, format))
+		if count, err := repo.ExportBooks(r.Context(), w, format); err != nil {
+			DefaultLogger.Error(r.Context(), "export failed", map[string]interface{}{"rows": count, "error": err.Error()})
+			if count == 0 {
+				http.Error(w, "export failed", http.StatusInternalServerError)
+				return
+			}
+			// rows were already streamed, aborting lets the client see the export is incomplete
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
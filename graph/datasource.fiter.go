@@ -42,6 +42,6 @@ func FilterIntRange(filter *model.FilterIntRange, tx *gorm.DB, field string) {
 		tx.Where(fmt.Sprintf("%s >= ?", field), filter.Min)
 	}
 	if filter.Max != nil {
-		tx.Where(fmt.Sprintf("%s >= ?", field), filter.Max)
+		tx.Where(fmt.Sprintf("%s <= ?", field), filter.Max)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/graph-gophers/dataloader"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 	"time"
 )
 
@@ -23,8 +24,10 @@ type BatchLoaderKey struct {
 	idx           int
 	key           string
 	group         *string
+	tenant        string
 	processed     bool
-	queryFn       func(keys []*BatchLoaderKey) *dataloader.Result
+	queued        time.Time
+	queryFn       func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result
 	queryFilterFn func(key *BatchLoaderKey, groupResults *dataloader.Result) *dataloader.Result
 	Param         interface{}
 }
@@ -42,6 +45,15 @@ func NewDataSource(db *gorm.DB) *DataSource {
 	d.BatchLoader = dataloader.NewBatchedLoader(func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
 		results := make([]*dataloader.Result, len(keys))
 		keysLen := len(keys)
+		var queued time.Time
+		for i := 0; i < keysLen; i++ {
+			if q := keys[i].(*BatchLoaderKey).queued; queued.IsZero() || q.Before(queued) {
+				queued = q
+			}
+		}
+		wait := time.Since(queued)
+		ctx, span := startBatchSpan(ctx, queued, keysLen)
+		groups := 0
 		for i := 0; i < keysLen; i++ {
 			key := keys[i].(*BatchLoaderKey)
 			if !key.processed {
@@ -51,7 +63,7 @@ func NewDataSource(db *gorm.DB) *DataSource {
 					for j := i + 1; j < keysLen; j++ {
 						jkey := keys[j].(*BatchLoaderKey)
 						if !jkey.processed {
-							if *key.group == *jkey.group {
+							if *key.group == *jkey.group && key.tenant == jkey.tenant {
 								jkey.idx = j
 								jkey.processed = true
 								gkeys = append(gkeys, jkey)
@@ -59,7 +71,9 @@ func NewDataSource(db *gorm.DB) *DataSource {
 						}
 					}
 				}
-				result := key.queryFn(gkeys)
+				groups++
+				// the keys of a batch may come from requests of different tenants
+				result := key.queryFn(WithTenant(ctx, key.tenant), gkeys)
 				for _, g := range gkeys {
 					if g.queryFilterFn != nil {
 						results[g.idx] = g.queryFilterFn(g, result)
@@ -69,27 +83,39 @@ func NewDataSource(db *gorm.DB) *DataSource {
 				}
 			}
 		}
+		observeBatch(keysLen, groups, wait)
+		endBatchSpan(span, groups, results)
 		return results
 	}, dataloader.WithWait(100*time.Millisecond))
 	return &d
 }
 
-func (ds *DataSource) BatchLoad(ctx context.Context, group *string, key string, params interface{}, obj interface{}, queryFn func(keys []*BatchLoaderKey) *dataloader.Result, queryFilterFn func(key *BatchLoaderKey, groupResults *dataloader.Result) *dataloader.Result) (interface{}, error) {
+// dryRun builds statements without running them, batch keys and groups come from the SQL. They
+// are kept out of the SQL log since they never reach the database, and out of the tenant scope
+// since BatchLoad keys them by tenant.
+func (ds *DataSource) dryRun() *gorm.DB {
+	return ds.DB.Session(&gorm.Session{DryRun: true, Logger: logger.Discard, Context: AnyTenant(context.Background())})
+}
+
+func (ds *DataSource) BatchLoad(ctx context.Context, group *string, key string, params interface{}, obj interface{}, queryFn func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result, queryFilterFn func(key *BatchLoaderKey, groupResults *dataloader.Result) *dataloader.Result) (interface{}, error) {
 	if key == "" {
 		panic(fmt.Sprintf("invalid key %s", key))
 	}
+	tenant := tenantScope(ctx)
 	batchLoaderKey := &BatchLoaderKey{
-		key:           key,
+		key:           tenant + ":" + key,
 		group:         group,
+		tenant:        tenant,
 		Param:         obj,
 		queryFn:       queryFn,
 		queryFilterFn: queryFilterFn,
+		queued:        time.Now(),
 	}
 	return ds.BatchLoader.Load(ctx, batchLoaderKey)()
 }
 
 func (ds *DataSource) Load(ctx context.Context, dest interface{}, fields []string, scopes ...func(*gorm.DB) *gorm.DB) *dataloader.Result {
-	result := ds.DB.Select(fields).Scopes(scopes...).Find(&dest)
+	result := ds.DB.WithContext(ctx).Select(fields).Scopes(scopes...).Find(&dest)
 	if result.Error != nil {
 		return &dataloader.Result{
 			Error: errors.Wrap(result.Error, "failed to load"),
@@ -0,0 +1,351 @@
+package graph
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm"
+)
+
+const DefaultImportBatchSize = 100
+
+type ImportReview struct {
+	Star int    `json:"star"`
+	Text string `json:"text"`
+}
+
+type ImportRow struct {
+	Title   string         `json:"title"`
+	Series  *string        `json:"series"`
+	Authors []string       `json:"authors"`
+	Reviews []ImportReview `json:"reviews"`
+}
+
+type importItem struct {
+	row int
+	rec *ImportRow
+}
+
+func ImportFormatOf(filename string) (model.ImportFormat, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return model.ImportFormatCSV, nil
+	case ".json", ".jsonl", ".ndjson":
+		return model.ImportFormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown import format for file '%s'", filename)
+}
+
+// ReadImportRows calls fn for every row in r, rows are numbered from 1.
+//
+// CSV input needs a header naming its columns: title, series, authors and reviews. Authors are
+// separated by ';' and reviews are written as 'star:text' separated by ';'. A CSV written by
+// ExportBooks imports as is, its id, review_count and rating columns are ignored. The reviews
+// themselves are not exported, so the books imported from an export have none.
+// JSON input is either a stream of ImportRow objects (JSON lines) or a single array of them.
+func ReadImportRows(r io.Reader, format model.ImportFormat, fn func(row int, rec *ImportRow, err error) error) error {
+	switch format {
+	case model.ImportFormatCSV:
+		return readImportCSV(r, fn)
+	case model.ImportFormatJSON:
+		return readImportJSON(r, fn)
+	}
+	return fmt.Errorf("unsupported import format '%s'", format)
+}
+
+func readImportCSV(r io.Reader, fn func(row int, rec *ImportRow, err error) error) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("invalid csv header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch h {
+		case "title", "series", "authors", "reviews":
+			columns[h] = i
+		case "id", "review_count", "rating":
+			// written by ExportBooks, derived from the imported rows
+		default:
+			return fmt.Errorf("unknown csv column '%s'", h)
+		}
+	}
+	if _, ok := columns["title"]; !ok {
+		return fmt.Errorf("missing csv column 'title'")
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			if err := fn(row, nil, err); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		rec := &ImportRow{Title: column(record, "title")}
+		if v := column(record, "series"); v != "" {
+			rec.Series = &v
+		}
+		for _, v := range strings.Split(column(record, "authors"), ";") {
+			if v = strings.TrimSpace(v); v != "" {
+				rec.Authors = append(rec.Authors, v)
+			}
+		}
+		err = nil
+		for _, v := range strings.Split(column(record, "reviews"), ";") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			pair := strings.SplitN(v, ":", 2)
+			star, serr := strconv.Atoi(strings.TrimSpace(pair[0]))
+			if len(pair) != 2 || serr != nil {
+				err = fmt.Errorf("invalid review '%s', expected 'star:text'", v)
+				break
+			}
+			rec.Reviews = append(rec.Reviews, ImportReview{Star: star, Text: strings.TrimSpace(pair[1])})
+		}
+		if err != nil {
+			rec = nil
+		}
+		if err := fn(row, rec, err); err != nil {
+			return err
+		}
+	}
+}
+
+func readImportJSON(r io.Reader, fn func(row int, rec *ImportRow, err error) error) error {
+	br := bufio.NewReader(r)
+	array := false
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			array = b == '['
+			br.UnreadByte()
+			break
+		}
+	}
+	dec := json.NewDecoder(br)
+	if array {
+		// the decoder has to see the opening bracket to take the commas between elements
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("invalid json: %v", err)
+		}
+	}
+	for row := 1; ; row++ {
+		if array && !dec.More() {
+			if _, err := dec.Token(); err != nil {
+				return fmt.Errorf("invalid json at row %v: %v", row, err)
+			}
+			return nil
+		}
+		var rec ImportRow
+		err := dec.Decode(&rec)
+		if err == io.EOF && !array {
+			return nil
+		}
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &terr) {
+			if err := fn(row, nil, err); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("invalid json at row %v: %v", row, err)
+		}
+		if err := fn(row, &rec, nil); err != nil {
+			return err
+		}
+	}
+}
+
+func (ds *DataSource) ImportBooks(ctx context.Context, r io.Reader, format model.ImportFormat, batchSize int) (*model.ImportResult, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size %v", batchSize)
+	}
+	res := &model.ImportResult{Errors: []*model.ImportError{}}
+	batch := []importItem{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		imported := 0
+		tx := ds.DB.WithContext(ctx).Begin()
+		for _, item := range batch {
+			sp := fmt.Sprintf("import_row_%v", item.row)
+			if result := tx.SavePoint(sp); result.Error != nil {
+				tx.Rollback()
+				return result.Error
+			}
+			if err := ds.importRow(ctx, tx, item.rec); err != nil {
+				if result := tx.RollbackTo(sp); result.Error != nil {
+					tx.Rollback()
+					return result.Error
+				}
+				res.Errors = append(res.Errors, &model.ImportError{Row: item.row, Message: err.Error()})
+			} else {
+				imported++
+			}
+		}
+		if result := tx.Commit(); result.Error != nil {
+			return result.Error
+		}
+		res.Imported += imported
+		batch = []importItem{}
+		return nil
+	}
+	var flushErr error
+	err := ReadImportRows(r, format, func(row int, rec *ImportRow, err error) error {
+		res.Rows++
+		if err != nil {
+			res.Errors = append(res.Errors, &model.ImportError{Row: row, Message: err.Error()})
+			return nil
+		}
+		batch = append(batch, importItem{row: row, rec: rec})
+		if len(batch) >= batchSize {
+			flushErr = flush()
+			return flushErr
+		}
+		return nil
+	})
+	// rows read before a malformed one are imported, as they would be by an earlier batch
+	if flushErr == nil {
+		if ferr := flush(); err == nil {
+			err = ferr
+		}
+	}
+	return res, err
+}
+
+// importAuthor is the user the reviews of the row are written by: the importing user, or nobody for
+// an operator import from the command line. A key that acts for no user cannot import reviews.
+func importAuthor(ctx context.Context, rec *ImportRow) (*int, error) {
+	if len(rec.Reviews) == 0 {
+		return nil, nil
+	}
+	user := UserOf(ctx)
+	if user == nil {
+		if APIKeyOf(ctx) != nil {
+			return nil, fmt.Errorf("importing reviews requires a user")
+		}
+		return nil, nil
+	}
+	if len(rec.Reviews) > 1 {
+		return nil, fmt.Errorf("user '%s' already reviewed book '%s'", user.Name, rec.Title)
+	}
+	return Of(user.ID), nil
+}
+
+func (ds *DataSource) importRow(ctx context.Context, tx *gorm.DB, rec *ImportRow) error {
+	if rec.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	author, err := importAuthor(ctx, rec)
+	if err != nil {
+		return err
+	}
+	book := &model.Book{
+		Title:   rec.Title,
+		Authors: []*model.Author{},
+	}
+	if rec.Series != nil && *rec.Series != "" {
+		var series model.BookSeries
+		result := tx.Where("title = ?", *rec.Series).Limit(1).Find(&series)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			series = model.BookSeries{Title: *rec.Series}
+			if result := tx.Create(&series); result.Error != nil {
+				return result.Error
+			}
+			if err := ds.Audit(ctx, tx, model.AuditActionInsert, "book_series", series.ID, nil, &series); err != nil {
+				return err
+			}
+		}
+		book.SeriesID = &series.ID
+	}
+	if len(rec.Authors) > 0 {
+		var authors []*model.Author
+		result := tx.Where("name IN (?)", rec.Authors).Find(&authors)
+		if result.Error != nil {
+			return result.Error
+		}
+		for _, name := range rec.Authors {
+			var author *model.Author
+			for _, a := range authors {
+				if a.Name == name {
+					author = a
+				}
+			}
+			if author == nil {
+				author = &model.Author{Name: name}
+				if result := tx.Create(author); result.Error != nil {
+					return result.Error
+				}
+				if err := ds.Audit(ctx, tx, model.AuditActionInsert, "authors", author.ID, nil, author); err != nil {
+					return err
+				}
+				authors = append(authors, author)
+			}
+			duplicate := false
+			for _, a := range book.Authors {
+				duplicate = duplicate || a.ID == author.ID
+			}
+			if !duplicate {
+				book.Authors = append(book.Authors, author)
+			}
+		}
+	}
+	result := tx.Omit("Authors.*").Create(book)
+	if result.Error != nil {
+		if ds.IsUniqueViolation(result.Error, "books", "title") {
+			return fmt.Errorf(`duplicate key books.title "%s"`, book.Title)
+		}
+		return result.Error
+	}
+	if err := ds.Audit(ctx, tx, model.AuditActionInsert, "books", book.ID, nil, book); err != nil {
+		return err
+	}
+	for _, r := range rec.Reviews {
+		review := &model.Review{
+			BookID: book.ID,
+			UserID: author,
+			Star:   r.Star,
+			Text:   r.Text,
+		}
+		if result := tx.Create(review); result.Error != nil {
+			return result.Error
+		}
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "reviews", review.ID, nil, review); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,62 +11,187 @@ import (
 )
 
 func (ds *DataSource) CreateReview(ctx context.Context, input model.NewReview) (*model.Review, error) {
+	user := UserOf(ctx)
+	if user == nil {
+		return nil, NewUnauthenticatedError("creating a review requires a user")
+	}
 	var book model.Book
-	result := ds.DB.Where("id = ?", input.BookID).Limit(1).Find(&book)
+	result := ds.DB.WithContext(ctx).Where("id = ?", input.BookID).Where("deleted_at IS NULL").Limit(1).Find(&book)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected != 1 {
 		return nil, fmt.Errorf("book with id '%v' does not exist", input.BookID)
 	}
+	var count int64
+	result = ds.DB.WithContext(ctx).Model(&model.Review{}).Where("book_id = ?", input.BookID).Where("user_id = ?", user.ID).Count(&count)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if count != 0 {
+		return nil, fmt.Errorf("user '%s' already reviewed book with id '%v'", user.Name, input.BookID)
+	}
 	review := &model.Review{
 		BookID: input.BookID,
+		UserID: &user.ID,
 		Star:   input.Star,
 		Text:   input.Text,
 	}
-	result = ds.DB.Create(review)
+	tx := ds.DB.WithContext(ctx).Begin()
+	result = tx.Create(review)
+	if result.Error != nil {
+		tx.Rollback()
+		return review, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "reviews", review.ID, nil, review); err != nil {
+			tx.Rollback()
+			return review, err
+		}
+		result = tx.Commit()
+		return review, result.Error
+	}
+	tx.Rollback()
+	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
+
+func (ds *DataSource) findReview(ctx context.Context, id int) (*model.Review, error) {
+	var review model.Review
+	result := ds.DB.WithContext(ctx).Where("id = ?", id).Limit(1).Find(&review)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("review with id '%v' does not exist", id)
+	}
+	return &review, nil
+}
+
+func (ds *DataSource) UpdateReview(ctx context.Context, input model.UpdateReview) (*model.Review, error) {
+	review, err := ds.findReview(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CanModifyReview(ctx, review); err != nil {
+		return nil, err
+	}
+	if review.Version != input.ExpectedVersion {
+		return nil, ds.reviewConflict(ctx, input.ID, input.ExpectedVersion)
+	}
+	before := *review
+	review.Version = input.ExpectedVersion + 1
+	fields := []string{"version"}
+	if input.Star != nil {
+		review.Star = *input.Star
+		fields = append(fields, "star")
+	}
+	if input.Text != nil {
+		review.Text = *input.Text
+		fields = append(fields, "text")
+	}
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Select(fields).Where("version = ?", input.ExpectedVersion).Updates(review)
 	if result.Error != nil {
+		tx.Rollback()
 		return review, result.Error
 	} else if result.RowsAffected == 1 {
-		return review, nil
+		if err := ds.Audit(ctx, tx, model.AuditActionUpdate, "reviews", review.ID, &before, review); err != nil {
+			tx.Rollback()
+			return review, err
+		}
+		result = tx.Commit()
+		return review, result.Error
+	} else if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ds.reviewConflict(ctx, input.ID, input.ExpectedVersion)
 	}
+	tx.Rollback()
+	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
+
+func (ds *DataSource) reviewConflict(ctx context.Context, id int, expectedVersion int) error {
+	review, err := ds.findReview(ctx, id)
+	if err != nil {
+		return err
+	}
+	return NewConflictError(fmt.Sprintf("review with id '%v' has version %v, expected %v", id, review.Version, expectedVersion), review)
+}
+
+func (ds *DataSource) DeleteReview(ctx context.Context, id int) (*model.Review, error) {
+	review, err := ds.findReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := CanModifyReview(ctx, review); err != nil {
+		return nil, err
+	}
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Where("version = ?", review.Version).Delete(review)
+	if result.Error != nil {
+		tx.Rollback()
+		return review, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionDelete, "reviews", review.ID, review, nil); err != nil {
+			tx.Rollback()
+			return review, err
+		}
+		result = tx.Commit()
+		return review, result.Error
+	} else if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ds.reviewConflict(ctx, id, review.Version)
+	}
+	tx.Rollback()
 	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
 }
 
 func (ds *DataSource) BookReviews(ctx context.Context, obj *model.Book, offset *int, limit *int, filter *model.ReviewFilter) ([]*model.Review, error) {
 	fields := []string{"book_id"}
 	for _, f := range graphql.CollectFieldsCtx(ctx, nil) {
-		if f.Name != "book_id" {
+		switch f.Name {
+		case "book":
+		case "author":
+			fields = append(fields, "user_id")
+		default:
 			fields = append(fields, f.Name)
 		}
 	}
-	var scopeFn = func(bookIDs []int, offset *int, limit *int, filter *model.ReviewFilter) func(tx *gorm.DB) *gorm.DB {
-		return func(tx *gorm.DB) *gorm.DB {
-			tx.Where("book_id IN ?", bookIDs)
-			if filter != nil {
-				if filter.Star != nil {
-					FilterIntRange(filter.Star, tx, `"reviews"."star"`)
-				}
+	// the reviews of every book in the batch are fetched at once, so the page is taken per book by
+	// numbering the rows of each book rather than with LIMIT and OFFSET
+	var queryScope = func(db *gorm.DB, bookIDs []int) *gorm.DB {
+		tx := db.Model(&model.Review{}).Where("book_id IN ?", bookIDs)
+		if filter != nil {
+			if filter.Star != nil {
+				FilterIntRange(filter.Star, tx, ds.Quote("reviews.star"))
 			}
+		}
+		if offset == nil && limit == nil {
+			return tx.Select(fields)
+		}
+		tx.Select(append(append([]string{}, fields...), "ROW_NUMBER() OVER (PARTITION BY book_id ORDER BY id) AS row_num"))
+		// tx is scoped to the tenant, the derived table selecting from it is lifted
+		page := db.Session(&gorm.Session{NewDB: true, Context: AnyTenant(db.Statement.Context)}).Table("(?) AS reviews", tx).Select(fields)
+		if offset != nil && *offset > 0 {
+			page.Where("row_num > ?", *offset)
+		}
+		if limit != nil {
+			from := 0
 			if offset != nil {
-				tx.Offset(*offset)
-			}
-			if limit != nil {
-				tx.Limit(*limit)
+				from = *offset
 			}
-			return tx
+			page.Where("row_num <= ?", from+*limit)
 		}
+		return page.Order("book_id, row_num")
 	}
-	tx := ds.DB.Session(&gorm.Session{DryRun: true}).Select(fields).Scopes(scopeFn([]int{obj.ID}, offset, limit, filter)).Find(&model.Review{})
+	tx := queryScope(ds.dryRun(), []int{obj.ID}).Find(&[]*model.Review{})
 	group := tx.Statement.SQL.String()
 	key := ds.DB.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
-	var queryFn = func(keys []*BatchLoaderKey) *dataloader.Result {
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
 		ids := make([]int, len(keys))
 		for i, k := range keys {
 			ids[i] = k.Param.(*model.Book).ID
 		}
 		var reviews []*model.Review
-		result := ds.DB.Select(fields).Scopes(scopeFn(ids, offset, limit, filter)).Find(&reviews)
+		result := queryScope(ds.DB.WithContext(ctx), ids).Find(&reviews)
 		if result.Error != nil {
 			return &dataloader.Result{
 				Error: result.Error,
@@ -0,0 +1,71 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/dataloader"
+	"github.com/senomas/gographql/graph/model"
+)
+
+func (ds *DataSource) User(ctx context.Context, id int) (*model.User, error) {
+	group := "users"
+	var queryFn = func(ctx context.Context, keys []*BatchLoaderKey) *dataloader.Result {
+		ids := make([]int, len(keys))
+		for i, k := range keys {
+			ids[i] = k.Param.(int)
+		}
+		var users []*model.User
+		result := ds.DB.WithContext(ctx).Where("id IN ?", ids).Find(&users)
+		if result.Error != nil {
+			return &dataloader.Result{
+				Error: result.Error,
+			}
+		}
+		return &dataloader.Result{
+			Data: users,
+		}
+	}
+	filterFn := func(key *BatchLoaderKey, groupResults *dataloader.Result) *dataloader.Result {
+		if groupResults.Error != nil {
+			return groupResults
+		}
+		for _, u := range groupResults.Data.([]*model.User) {
+			if u.ID == key.Param.(int) {
+				return &dataloader.Result{Data: u}
+			}
+		}
+		return &dataloader.Result{Data: nil}
+	}
+	data, err := ds.BatchLoad(ctx, &group, fmt.Sprintf("users:%v", id), []int{id}, id, queryFn, filterFn)
+	if data != nil {
+		return data.(*model.User), err
+	}
+	return nil, err
+}
+
+func (ds *DataSource) CreateUser(ctx context.Context, input model.NewUser) (*model.User, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, fmt.Errorf("user name is required")
+	}
+	user := &model.User{Name: input.Name, Email: input.Email, CreatedAt: time.Now()}
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Create(user)
+	if result.Error != nil {
+		tx.Rollback()
+		if ds.IsUniqueViolation(result.Error, "users", "name") {
+			return nil, fmt.Errorf(`duplicate key users.name "%s"`, user.Name)
+		}
+		return nil, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "users", user.ID, nil, user); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return user, tx.Commit().Error
+	}
+	tx.Rollback()
+	return nil, fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
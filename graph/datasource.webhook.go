@@ -0,0 +1,156 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm"
+)
+
+// enqueueWebhooks writes the deliveries of an audited change in the transaction of the change, so
+// a webhook hears of every committed change and of nothing rolled back.
+func (ds *DataSource) enqueueWebhooks(ctx context.Context, tx *gorm.DB, action model.AuditAction, entity string, id int, before interface{}, after interface{}, at time.Time) error {
+	if _, ok := webhookEvent(action, entity); !ok {
+		return nil
+	}
+	var webhooks []*model.Webhook
+	if result := tx.Find(&webhooks); result.Error != nil {
+		return result.Error
+	}
+	deliveries, err := NewWebhookDeliveries(ctx, ds.DB.NamingStrategy, webhooks, action, entity, id, before, after, at)
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+	result := tx.Create(&deliveries)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected != int64(len(deliveries)) {
+		return fmt.Errorf("RowsAffected %v", result.RowsAffected)
+	}
+	return nil
+}
+
+func (ds *DataSource) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	result := ds.DB.WithContext(ctx).Order("id").Find(&webhooks)
+	return webhooks, result.Error
+}
+
+func (ds *DataSource) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	webhook.CreatedBy = Actor(ctx)
+	tx := ds.DB.WithContext(ctx).Begin()
+	result := tx.Create(webhook)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionInsert, "webhooks", webhook.ID, nil, auditWebhook(webhook)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+	tx.Rollback()
+	return fmt.Errorf("RowsAffected %v", result.RowsAffected)
+}
+
+// DeleteWebhook drops the deliveries of the webhook along with it, pending ones are never posted.
+func (ds *DataSource) DeleteWebhook(ctx context.Context, id int) (*model.Webhook, error) {
+	var webhook model.Webhook
+	result := ds.DB.WithContext(ctx).Where("id = ?", id).Limit(1).Find(&webhook)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("webhook with id '%v' does not exist", id)
+	}
+	tx := ds.DB.WithContext(ctx).Begin()
+	if result := tx.Where("webhook_id = ?", id).Delete(&model.WebhookDelivery{}); result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	result = tx.Delete(&webhook)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	} else if result.RowsAffected == 1 {
+		if err := ds.Audit(ctx, tx, model.AuditActionDelete, "webhooks", webhook.ID, auditWebhook(&webhook), nil); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		return &webhook, tx.Commit().Error
+	}
+	tx.Rollback()
+	return nil, fmt.Errorf("webhook with id '%v' does not exist", id)
+}
+
+func (ds *DataSource) WebhookDeliveries(ctx context.Context, status model.WebhookDeliveryStatus, limit int) ([]*model.WebhookDelivery, error) {
+	var deliveries []*model.WebhookDelivery
+	result := ds.DB.WithContext(ctx).Preload("Webhook").Where("status = ?", status).Order("id DESC").Limit(limit).Find(&deliveries)
+	return deliveries, result.Error
+}
+
+func (ds *DataSource) RetryWebhookDelivery(ctx context.Context, id int, at time.Time) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	result := ds.DB.WithContext(ctx).Preload("Webhook").Where("id = ?", id).Limit(1).Find(&delivery)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("webhook delivery with id '%v' does not exist", id)
+	}
+	if delivery.Status == model.WebhookDeliveryStatusPending {
+		return nil, fmt.Errorf("webhook delivery with id '%v' is already pending", id)
+	}
+	status := delivery.Status
+	delivery.Status = model.WebhookDeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = at
+	delivery.DeliveredAt = nil
+	result = ds.DB.WithContext(ctx).Model(&delivery).Where("status = ?", status).
+		Select("status", "attempts", "next_attempt_at", "delivered_at").Updates(&delivery)
+	if result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected != 1 {
+		return nil, fmt.Errorf("webhook delivery with id '%v' is already pending", id)
+	}
+	return &delivery, nil
+}
+
+func (ds *DataSource) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]*model.WebhookDelivery, error) {
+	ctx = AnyTenant(ctx)
+	var due []*model.WebhookDelivery
+	result := ds.DB.WithContext(ctx).Preload("Webhook").Where("status = ?", model.WebhookDeliveryStatusPending).Where("next_attempt_at <= ?", now).
+		Order("next_attempt_at").Order("id").Limit(limit).Find(&due)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	claimed := []*model.WebhookDelivery{}
+	for _, d := range due {
+		// another dispatcher claiming it first moved its next attempt past now
+		result := ds.DB.WithContext(ctx).Model(&model.WebhookDelivery{}).Where("id = ?", d.ID).
+			Where("status = ?", model.WebhookDeliveryStatusPending).Where("next_attempt_at <= ?", now).Update("next_attempt_at", lease)
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 1 {
+			d.NextAttemptAt = lease
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+func (ds *DataSource) SaveWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, lease time.Time) error {
+	ctx = AnyTenant(ctx)
+	result := ds.DB.WithContext(ctx).Model(delivery).Where("status = ?", model.WebhookDeliveryStatusPending).Where("next_attempt_at = ?", lease).
+		Select("status", "attempts", "next_attempt_at", "last_error", "delivered_at").Updates(delivery)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected != 1 {
+		return fmt.Errorf("webhook delivery with id '%v' was claimed by another dispatcher", delivery.ID)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	DialectSQLite   = "sqlite"
+	// DialectMemory selects the in-process MemoryRepository, it has no database behind it
+	DialectMemory = "memory"
+)
+
+func (ds *DataSource) Dialect() string {
+	return ds.DB.Dialector.Name()
+}
+
+// Quote quotes a possibly qualified identifier like books.id the way the current dialect expects.
+func (ds *DataSource) Quote(name string) string {
+	return Quote(ds.DB, name)
+}
+
+func Quote(db *gorm.DB, name string) string {
+	var sb strings.Builder
+	db.Dialector.QuoteTo(&sb, name)
+	return sb.String()
+}
+
+// IsUniqueViolation reports whether err was raised by the unique constraint on table.column, either
+// the per tenant index idx_<table>_<column> or the single column constraint it replaced.
+func (ds *DataSource) IsUniqueViolation(err error, table string, column string) bool {
+	if err == nil {
+		return false
+	}
+	emsg := err.Error()
+	index := fmt.Sprintf("idx_%s_%s", table, column)
+	switch ds.Dialect() {
+	case DialectPostgres:
+		return strings.Contains(emsg, "duplicate key value violates unique constraint") &&
+			(strings.Contains(emsg, fmt.Sprintf(`"%s_%s_key"`, table, column)) || strings.Contains(emsg, fmt.Sprintf(`"%s"`, index)))
+	case DialectMySQL:
+		// mysql 8 qualifies the key with its table, mariaDB and older mysql name the key only
+		if !strings.Contains(emsg, "Error 1062: Duplicate entry") {
+			return false
+		}
+		for _, key := range []string{column, index} {
+			if strings.HasSuffix(emsg, fmt.Sprintf("for key '%s.%s'", table, key)) || strings.HasSuffix(emsg, fmt.Sprintf("for key '%s'", key)) {
+				return true
+			}
+		}
+		return false
+	case DialectSQLite:
+		// a composite index lists every column, "UNIQUE constraint failed: books.tenant_id, books.title"
+		if _, columns, ok := strings.Cut(emsg, "UNIQUE constraint failed: "); ok {
+			for _, c := range strings.Split(columns, ", ") {
+				if c == table+"."+column {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package graph_test
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDialect struct {
+	name         string
+	open         func(conn *sql.DB) gorm.Dialector
+	expectOpen   func(mock sqlmock.Sqlmock)
+	returning    bool
+	duplicateErr string
+}
+
+var identifier = regexp.MustCompile(`"([a-z_]+)"`)
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+// SQL turns a statement written for postgres into the one the dialect generates.
+func (d testDialect) SQL(sql string) string {
+	if d.name != graph.DialectPostgres {
+		sql = identifier.ReplaceAllString(sql, "`$1`")
+		sql = placeholder.ReplaceAllString(sql, "?")
+	}
+	return QuoteMeta(sql)
+}
+
+var testDialects = []testDialect{
+	{
+		name: graph.DialectPostgres,
+		open: func(conn *sql.DB) gorm.Dialector {
+			return postgres.New(postgres.Config{Conn: conn})
+		},
+		returning:    true,
+		duplicateErr: `ERROR: duplicate key value violates unique constraint "idx_books_title" (SQLSTATE 23505)`,
+	},
+	{
+		name: graph.DialectMySQL,
+		open: func(conn *sql.DB) gorm.Dialector {
+			return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+		},
+		duplicateErr: `Error 1062: Duplicate entry 'Harry Potter and the Unknown' for key 'books.idx_books_title'`,
+	},
+	{
+		name: graph.DialectSQLite,
+		open: func(conn *sql.DB) gorm.Dialector {
+			return &sqlite.Dialector{Conn: conn}
+		},
+		expectOpen: func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(QuoteMeta(`select sqlite_version()`)).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("3.38.2"))
+		},
+		returning:    true,
+		duplicateErr: `UNIQUE constraint failed: books.tenant_id, books.title`,
+	},
+}
+
+func TestDialect(t *testing.T) {
+	_, _, c := SetupTest()
+
+	for _, d := range testDialects {
+		sqlDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.expectOpen != nil {
+			d.expectOpen(mock)
+		}
+		db, err := gorm.Open(d.open(sqlDB), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatalf("open %s error %v", d.name, err)
+		}
+		if err := db.Use(graph.TenantPlugin{}); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(d.name+" find books", func(t *testing.T) {
+			mock.ExpectQuery(d.SQL(`SELECT count(*) FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1`)).WithArgs("default").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(d.SQL(`SELECT "books"."id","books"."title" FROM "books" WHERE books.deleted_at IS NULL AND "books"."tenant_id" = $1 LIMIT 10`)).WithArgs("default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "Harry Potter and the Sorcerer's Stone"))
+			defer func() {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}()
+
+			type respType struct {
+				Books model.BookList
+			}
+			var resp respType
+			c.MustPost(`{
+            books {
+               count
+               list {
+                  id
+                  title
+               }
+            }
+         }`, &resp, addContext(graph.NewDataSource(db)))
+			JsonMatch(t, &respType{
+				Books: model.BookList{
+					Count: 1,
+					List: []*model.Book{
+						{
+							ID:    1,
+							Title: "Harry Potter and the Sorcerer's Stone",
+						},
+					},
+				},
+			}, &resp)
+		})
+
+		t.Run(d.name+" create duplicate book", func(t *testing.T) {
+			mock.ExpectQuery(d.SQL(`SELECT * FROM "authors" WHERE name IN ($1) AND "authors"."tenant_id" = $2 ORDER BY authors.id`)).WithArgs("J.K. Rowling", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "J.K. Rowling"))
+			mock.ExpectBegin()
+			if d.returning {
+				mock.ExpectQuery(d.SQL(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`)).
+					WithArgs("Harry Potter and the Unknown", nil, nil, 1, "default").
+					WillReturnError(errors.New(d.duplicateErr))
+			} else {
+				mock.ExpectExec(d.SQL(`INSERT INTO "books" ("title","series_id","deleted_at","version","tenant_id") VALUES ($1,$2,$3,$4,$5)`)).
+					WithArgs("Harry Potter and the Unknown", nil, nil, 1, "default").
+					WillReturnError(errors.New(d.duplicateErr))
+			}
+			mock.ExpectRollback()
+			defer func() {
+				assert.NoError(t, mock.ExpectationsWereMet())
+			}()
+
+			var resp struct {
+				CreateBook model.Book
+			}
+			err := c.Post(`mutation {
+            createBook(input: {
+               title: "Harry Potter and the Unknown"
+               authors_name: ["J.K. Rowling"]
+            }) {
+               id
+            }
+         }`, &resp, addContext(graph.NewDataSource(db)))
+			assert.ErrorContains(t, err, `duplicate key books.title \"Harry Potter and the Unknown\"`)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package graph
+
+import (
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	ErrorCode_Conflict = "CONFLICT"
+)
+
+func NewConflictError(message string, current interface{}) error {
+	return &gqlerror.Error{
+		Message: message,
+		Extensions: map[string]interface{}{
+			"code":    ErrorCode_Conflict,
+			"current": current,
+		},
+	}
+}
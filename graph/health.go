@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"gorm.io/gorm"
+)
+
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	BuiltAt    string `json:"builtAt"`
+	GoVersion  string `json:"goVersion"`
+	SchemaHash string `json:"schemaHash"`
+}
+
+// NewBuildInfo falls back to the VCS revision stamped by the go tool when commit is empty, so
+// builds without -ldflags still report where they came from.
+func NewBuildInfo(version string, commit string, builtAt string, schema *ast.Schema) BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuiltAt: builtAt, GoVersion: runtime.Version(), SchemaHash: SchemaHash(schema)}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuiltAt == "" {
+					info.BuiltAt = s.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// SchemaHash is the sha256 of the formatted schema, clients compare it to detect schema changes.
+func SchemaHash(schema *ast.Schema) string {
+	var buf bytes.Buffer
+	formatter.NewFormatter(&buf).FormatSchema(schema)
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// HealthHandler only tells the process is alive and serving HTTP.
+func HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// Readiness reports whether the database can take traffic. The schema check runs until it passes
+// once, after that only the connection is pinged.
+type Readiness struct {
+	DB       *gorm.DB
+	Timeout  time.Duration
+	migrated int32
+}
+
+func (rd *Readiness) Check(ctx context.Context) error {
+	if rd.DB == nil {
+		return nil
+	}
+	if rd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rd.Timeout)
+		defer cancel()
+	}
+	sqlDB, err := rd.DB.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database ping failed: %v", err)
+	}
+	if atomic.LoadInt32(&rd.migrated) == 1 {
+		return nil
+	}
+	status, err := Migrations(rd.DB.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	pending := []string{}
+	for _, s := range status {
+		if !s.UpToDate() {
+			pending = append(pending, s.Table)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations for %s", strings.Join(pending, ", "))
+	}
+	atomic.StoreInt32(&rd.migrated, 1)
+	return nil
+}
+
+func (rd *Readiness) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rd.Check(r.Context()); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+func VersionHandler(info BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	}
+}
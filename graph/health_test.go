@@ -0,0 +1,59 @@
+package graph_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	get := func(h http.Handler, path string) (*httptest.ResponseRecorder, map[string]string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		body := map[string]string{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	t.Run("healthz", func(t *testing.T) {
+		rec, body := get(graph.HealthHandler(), "/healthz")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ok", body["status"])
+	})
+
+	t.Run("readyz", func(t *testing.T) {
+		db := OpenSQLite(t)
+		readiness := &graph.Readiness{DB: db}
+
+		rec, body := get(readiness.Handler(), "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, body["error"], "pending migrations for authors")
+
+		if err := graph.Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+		rec, body = get(readiness.Handler(), "/readyz")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ok", body["status"])
+	})
+
+	t.Run("readyz without database", func(t *testing.T) {
+		rec, _ := get((&graph.Readiness{}).Handler(), "/readyz")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("version", func(t *testing.T) {
+		schema := generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{}}).Schema()
+		rec, body := get(graph.VersionHandler(graph.NewBuildInfo("1.2.3", "abc", "", schema)), "/version")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "1.2.3", body["version"])
+		assert.Equal(t, "abc", body["commit"])
+		assert.Len(t, body["schemaHash"], 64)
+		assert.Equal(t, graph.SchemaHash(schema), body["schemaHash"])
+	})
+}
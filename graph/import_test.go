@@ -0,0 +1,143 @@
+package graph_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImport(t *testing.T) {
+	t.Run("database", func(t *testing.T) {
+		db := SetupSQLite(t)
+		testImport(t, graph.NewDataSource(db))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		mem := graph.NewMemoryRepository()
+		mem.Populate()
+		testImport(t, mem)
+	})
+}
+
+func testImport(t *testing.T, repo graph.Repository) {
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+
+	t.Run("json array", func(t *testing.T) {
+		res, err := repo.ImportBooks(ctx, strings.NewReader(`[
+  {"title": "Quidditch Through the Ages", "authors": ["Kennilworthy Whisp"], "reviews": [{"star": 3, "text": "Sporty"}]},
+  {"title": "The Tales of Beedle the Bard", "series": "Wizarding World", "authors": ["J.K. Rowling"]},
+  {"title": 7}
+]`), model.ImportFormatJSON, 2)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 3, res.Rows)
+			assert.Equal(t, 2, res.Imported)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, 3, res.Errors[0].Row)
+			}
+		}
+	})
+
+	t.Run("unterminated json array", func(t *testing.T) {
+		res, err := repo.ImportBooks(ctx, strings.NewReader(`[{"title": "Fantastic Beasts and Where to Find Them"}`), model.ImportFormatJSON, 100)
+		assert.ErrorContains(t, err, "invalid json at row 2")
+		if assert.NotNil(t, res) {
+			assert.Equal(t, 1, res.Imported)
+		}
+	})
+
+	t.Run("csv export imports as is", func(t *testing.T) {
+		var buf bytes.Buffer
+		count, err := repo.ExportBooks(ctx, &buf, graph.ExportFormatCSV)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, strings.HasPrefix(buf.String(), "id,title,series,authors,review_count,rating\n"), buf.String())
+		initech := graph.WithTenant(context.TODO(), "initech")
+		res, err := repo.ImportBooks(initech, &buf, model.ImportFormatCSV, 100)
+		if assert.NoError(t, err) {
+			assert.Empty(t, res.Errors, graph.JsonStr(res.Errors))
+			assert.Equal(t, count, res.Imported)
+		}
+
+		buf.Reset()
+		_, err = repo.ExportBooks(initech, &buf, graph.ExportFormatJSONL)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var row graph.ExportRow
+		if assert.Len(t, lines, count) && assert.NoError(t, json.Unmarshal([]byte(lines[count-2]), &row)) {
+			assert.Equal(t, "The Tales of Beedle the Bard", row.Title)
+			assert.Equal(t, graph.Of("Wizarding World"), row.Series)
+			assert.Equal(t, []string{"J.K. Rowling"}, row.Authors)
+			assert.Equal(t, 0, row.ReviewCount)
+		}
+
+		// the lines of a json export import as is as well
+		res, err = repo.ImportBooks(graph.WithTenant(context.TODO(), "umbrella"), &buf, model.ImportFormatJSON, 100)
+		if assert.NoError(t, err) {
+			assert.Empty(t, res.Errors, graph.JsonStr(res.Errors))
+			assert.Equal(t, count, res.Imported)
+		}
+	})
+
+	t.Run("review count in reviews column", func(t *testing.T) {
+		res, err := repo.ImportBooks(ctx, strings.NewReader("title,reviews\nThe Monster Book of Monsters,2\n"), model.ImportFormatCSV, 100)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, res.Imported)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, 1, res.Errors[0].Row)
+				assert.Equal(t, "invalid review '2', expected 'star:text'", res.Errors[0].Message)
+			}
+		}
+	})
+
+	t.Run("reviews by the importing user", func(t *testing.T) {
+		user, err := repo.CreateUser(ctx, model.NewUser{Name: "luna"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		userCtx := graph.WithUser(ctx, user)
+		res, err := repo.ImportBooks(userCtx, strings.NewReader(`[
+  {"title": "Hogwarts: A History", "reviews": [{"star": 5, "text": "Thorough"}]},
+  {"title": "The Quibbler", "reviews": [{"star": 4, "text": "Odd"}, {"star": 5, "text": "Odder"}]}
+]`), model.ImportFormatJSON, 100)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, res.Imported)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, 2, res.Errors[0].Row)
+				assert.Equal(t, "user 'luna' already reviewed book 'The Quibbler'", res.Errors[0].Message)
+			}
+		}
+
+		var buf bytes.Buffer
+		_, err = repo.ExportBooks(ctx, &buf, graph.ExportFormatJSONL)
+		assert.NoError(t, err)
+		var book graph.ExportRow
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var row graph.ExportRow
+			if assert.NoError(t, json.Unmarshal([]byte(line), &row)) && row.Title == "Hogwarts: A History" {
+				book = row
+			}
+		}
+		if assert.Equal(t, 1, book.ReviewCount) {
+			_, err = repo.CreateReview(userCtx, model.NewReview{BookID: book.ID, Star: 4, Text: "Again"})
+			assert.ErrorContains(t, err, "already reviewed")
+		}
+	})
+
+	t.Run("reviews by a key without a user", func(t *testing.T) {
+		keyCtx := graph.WithAPIKey(ctx, &model.APIKey{Name: "ci", TenantID: graph.DefaultTenant})
+		res, err := repo.ImportBooks(keyCtx, strings.NewReader(`{"title": "Moste Potente Potions", "reviews": [{"star": 3, "text": "Risky"}]}`), model.ImportFormatJSON, 100)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, res.Imported)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, "importing reviews requires a user", res.Errors[0].Message)
+			}
+		}
+	})
+}
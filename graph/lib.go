@@ -8,35 +8,21 @@ import (
 	"log"
 	"math/big"
 	"os"
-	"strings"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/senomas/gographql/graph/model"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-var Models = []interface{}{&model.Author{}, &model.Book{}, &model.BookSeries{}, &model.Review{}}
+var Models = []interface{}{&model.Author{}, &model.Book{}, &model.BookSeries{}, &model.User{}, &model.Review{}, &model.AuditEntry{}, &model.APIKey{}, &PersistedQuery{}, &model.Webhook{}, &model.WebhookDelivery{}, &model.ChangeEvent{}}
 var RefTables = []interface{}{}
 
-type ConfigType struct {
-	Application          string
-	TokenSecret          string
-	HashedPasswordLength uint32
-	Argon2_Time          uint32
-	Argon2_Memory        uint32
-	Argon2_Thread        uint8
-}
-
-var Config = ConfigType{
-	Application:          "MyApp",
-	TokenSecret:          "supersecure",
-	HashedPasswordLength: 32,
-	Argon2_Time:          3,
-	Argon2_Memory:        64 * 1024,
-	Argon2_Thread:        2,
-}
+const DefaultDSN = "host=localhost user=demo password=password dbname=demo port=5432 sslmode=disable TimeZone=Asia/Jakarta"
 
 func Of[E any](e E) *E {
 	return &e
@@ -69,59 +55,132 @@ func GenerateRandomString(n int) string {
 	return string(ret)
 }
 
+// migrator lifts the tenant scope of db, the schema is shared by every tenant.
+func migrator(db *gorm.DB) *gorm.DB {
+	return db.WithContext(AnyTenant(db.Statement.Context))
+}
+
 func Migrate(db *gorm.DB) error {
-	if err := db.Migrator().DropTable(Models...); err != nil {
+	if err := MigrateDown(db); err != nil {
 		return err
 	}
-	if err := db.Migrator().DropTable(RefTables...); err != nil {
+	return MigrateUp(db)
+}
+
+func MigrateUp(db *gorm.DB) error {
+	db = migrator(db)
+	if err := dropLegacyUniques(db); err != nil {
 		return err
 	}
-	if err := db.AutoMigrate(Models...); err != nil {
+	return db.AutoMigrate(Models...)
+}
+
+func MigrateDown(db *gorm.DB) error {
+	db = migrator(db)
+	if err := db.Migrator().DropTable(Models...); err != nil {
+		return err
+	}
+	if err := db.Migrator().DropTable(RefTables...); err != nil {
 		return err
 	}
 	return nil
 }
 
-func Setup() (*sql.DB, *gorm.DB, error) {
-	// if salt, ok := os.LookupEnv("PASSWORD_SALT"); ok {
-	// 	Config.Salt = salt
-	// }
-
-	var dsnPostgre string
-	var gormLogger logger.Interface
-	for _, e := range os.Environ() {
-		pair := strings.SplitN(e, "=", 2)
-		if pair[0] == "DB_POSTGRES" {
-			dsnPostgre = pair[1]
-		} else if pair[0] == "LOGGER" && pair[1] != "" {
-			gormLogger = logger.New(
-				log.New(os.Stdout, "\r\n", log.LstdFlags),
-				logger.Config{
-					SlowThreshold:             time.Second,
-					LogLevel:                  logger.Info,
-					IgnoreRecordNotFoundError: true,
-					Colorful:                  true,
-				},
-			)
+type MigrationStatus struct {
+	Table          string
+	Exists         bool
+	MissingColumns []string
+}
+
+func (s MigrationStatus) UpToDate() bool {
+	return s.Exists && len(s.MissingColumns) == 0
+}
+
+// Migrations compares every model against the database, a table is pending when it does not
+// exist or lacks any of the model columns.
+func Migrations(db *gorm.DB) ([]MigrationStatus, error) {
+	db = migrator(db)
+	res := []MigrationStatus{}
+	for _, m := range Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, err
 		}
+		status := MigrationStatus{Table: stmt.Schema.Table, MissingColumns: []string{}}
+		status.Exists = db.Migrator().HasTable(m)
+		if status.Exists {
+			for _, f := range stmt.Schema.Fields {
+				if f.DBName != "" && !db.Migrator().HasColumn(m, f.DBName) {
+					status.MissingColumns = append(status.MissingColumns, f.DBName)
+				}
+			}
+		}
+		res = append(res, status)
+	}
+	return res, nil
+}
+
+// Open connects to the database of cfg with every statement scoped to the tenant of its context.
+func Open(cfg *ConfigType) (*gorm.DB, error) {
+	db, err := open(cfg)
+	if err != nil {
+		return nil, err
 	}
-	if gormLogger == nil {
+	if err := db.Use(TenantPlugin{}); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func open(cfg *ConfigType) (*gorm.DB, error) {
+	logLevel := logger.Silent
+	if cfg.LogSQL {
+		logLevel = logger.Info
+	}
+	var gormLogger logger.Interface = &SQLLogger{Logger: DefaultLogger, Level: logLevel, SlowThreshold: time.Second}
+	if cfg.LogFormat == LogFormatText {
 		gormLogger = logger.New(
 			log.New(os.Stdout, "\r\n", log.LstdFlags),
 			logger.Config{
 				SlowThreshold:             time.Second,
-				LogLevel:                  logger.Silent,
+				LogLevel:                  logLevel,
 				IgnoreRecordNotFoundError: true,
 				Colorful:                  true,
 			},
 		)
 	}
 
-	if dsnPostgre == "" {
-		dsnPostgre = "host=localhost user=demo password=password dbname=demo port=5432 sslmode=disable TimeZone=Asia/Jakarta"
-	}
+	switch cfg.Driver {
+	case DialectSQLite:
+		db, err := gorm.Open(sqlite.Open(cfg.DSN), &gorm.Config{Logger: gormLogger})
+		if err != nil {
+			return nil, err
+		}
+		// sqlite allows a single writer, and every connection to :memory: opens a new empty database
+		if sqlDB, err := db.DB(); err != nil {
+			return nil, err
+		} else {
+			sqlDB.SetMaxOpenConns(1)
+		}
+		return db, nil
+	case DialectMySQL:
+		dsn, err := mysqldriver.ParseDSN(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		dsn.ParseTime = true
+		// without a default size strings map to longtext, which mysql cannot index
+		return gorm.Open(mysql.New(mysql.Config{DSN: dsn.FormatDSN(), DefaultStringSize: 191}), &gorm.Config{Logger: gormLogger})
+	case DialectPostgres, "":
+		return gorm.Open(postgres.New(postgres.Config{DSN: cfg.DSN}), &gorm.Config{Logger: gormLogger})
+	case DialectMemory:
+		return nil, fmt.Errorf("driver '%s' has no database", cfg.Driver)
+	}
+	return nil, fmt.Errorf("unsupported driver '%s'", cfg.Driver)
+}
 
-	if db, err := gorm.Open(postgres.New(postgres.Config{DSN: dsnPostgre}), &gorm.Config{Logger: gormLogger}); err != nil {
+func Setup(cfg *ConfigType) (*sql.DB, *gorm.DB, error) {
+	if db, err := Open(cfg); err != nil {
 		return nil, nil, err
 	} else {
 		if err := Migrate(db); err != nil {
@@ -130,9 +189,10 @@ func Setup() (*sql.DB, *gorm.DB, error) {
 
 		tx := db.Begin()
 		if err := Populate(tx); err != nil {
+			tx.Rollback()
 			return nil, nil, err
 		}
-		db.Commit()
+		tx.Commit()
 
 		if sqlDB, err := db.DB(); err != nil {
 			return nil, nil, err
@@ -142,7 +202,12 @@ func Setup() (*sql.DB, *gorm.DB, error) {
 	}
 }
 
+// Populate loads the sample data into the tenant of the tx context, the default tenant when the
+// context has none.
 func Populate(tx *gorm.DB) error {
+	if tenantScope(tx.Statement.Context) == "" {
+		tx = tx.WithContext(WithTenant(tx.Statement.Context, DefaultTenant))
+	}
 	var author = model.Author{
 		Name: "J.K. Rowling",
 	}
@@ -185,6 +250,7 @@ func Populate(tx *gorm.DB) error {
 	if result := tx.Create(&book); result.Error != nil {
 		return result.Error
 	}
+	sorcerersStone := book
 	book = model.Book{
 		Title:   "Harry Potter and the Chamber of Secrets",
 		Series:  &bookSeries,
@@ -193,6 +259,7 @@ func Populate(tx *gorm.DB) error {
 	if result := tx.Create(&book); result.Error != nil {
 		return result.Error
 	}
+	chamberOfSecrets := book
 	book = model.Book{
 		Title:   "Harry Potter and the Book of Evil",
 		Authors: []*model.Author{&lordVoldermort},
@@ -200,6 +267,7 @@ func Populate(tx *gorm.DB) error {
 	if result := tx.Create(&book); result.Error != nil {
 		return result.Error
 	}
+	bookOfEvil := book
 	book = model.Book{
 		Title:   "Harry Potter and the Snake Dictionary",
 		Authors: []*model.Author{&lordVoldermort, &salazarSlitherin},
@@ -209,7 +277,7 @@ func Populate(tx *gorm.DB) error {
 	}
 
 	review := model.Review{
-		BookID: 1,
+		BookID: sorcerersStone.ID,
 		Star:   5,
 		Text:   "The Boy Who Live",
 	}
@@ -217,7 +285,7 @@ func Populate(tx *gorm.DB) error {
 		return result.Error
 	}
 	review = model.Review{
-		BookID: 2,
+		BookID: chamberOfSecrets.ID,
 		Star:   5,
 		Text:   "The Girl Who Kill",
 	}
@@ -226,7 +294,7 @@ func Populate(tx *gorm.DB) error {
 	}
 
 	review = model.Review{
-		BookID: 3,
+		BookID: bookOfEvil.ID,
 		Star:   1,
 		Text:   "Fake Books",
 	}
@@ -235,7 +303,7 @@ func Populate(tx *gorm.DB) error {
 	}
 
 	review = model.Review{
-		BookID: 1,
+		BookID: sorcerersStone.ID,
 		Star:   3,
 		Text:   "The Man With Funny Hat",
 	}
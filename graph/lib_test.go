@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -17,8 +19,11 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/senomas/gographql/graph"
 	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -31,13 +36,29 @@ type GraphqlError struct {
 	Extensions map[string]interface{}
 }
 
-func addContext(ds *graph.DataSource) client.Option {
+// addContext attaches repo to the request and scopes it to the default tenant, like
+// Resolver.Middleware does for a request without a tenant header.
+func addContext(repo graph.Repository) client.Option {
 	return func(bd *client.Request) {
-		ctx := context.WithValue(context.TODO(), graph.Context_DataSource, ds)
+		ctx := graph.WithRepository(graph.WithTenant(context.TODO(), graph.DefaultTenant), repo)
 		bd.HTTP = bd.HTTP.WithContext(ctx)
 	}
 }
 
+// addUser authenticates the request as user, it goes after addContext.
+func addUser(user *model.User) client.Option {
+	return func(bd *client.Request) {
+		bd.HTTP = bd.HTTP.WithContext(graph.WithUser(bd.HTTP.Context(), user))
+	}
+}
+
+// addRoles grants the request roles, it goes after addContext.
+func addRoles(roles ...string) client.Option {
+	return func(bd *client.Request) {
+		bd.HTTP = bd.HTTP.WithContext(graph.WithRoles(bd.HTTP.Context(), roles))
+	}
+}
+
 func JsonMatch(t *testing.T, expected interface{}, resp interface{}, msg ...string) {
 	rJSON, _ := json.MarshalIndent(resp, "", "\t")
 	eJSON, _ := json.MarshalIndent(expected, "", "\t")
@@ -45,6 +66,17 @@ func JsonMatch(t *testing.T, expected interface{}, resp interface{}, msg ...stri
 	assert.Equal(t, string(eJSON), string(rJSON), msg)
 }
 
+// SortBooks orders books by id on dialects other than postgres, where unordered scans do not
+// return rows in the heap order the expected lists were written against.
+func SortBooks(db *gorm.DB, books []*model.Book) []*model.Book {
+	if db.Dialector.Name() != graph.DialectPostgres {
+		sort.SliceStable(books, func(i, j int) bool {
+			return books[i].ID < books[j].ID
+		})
+	}
+	return books
+}
+
 type ArrayIntArgs struct {
 	value map[int64]bool
 }
@@ -71,19 +103,30 @@ func (m *ArrayIntArgs) Match(v driver.Value) bool {
 }
 
 func SetupTest() (generated.Config, *handler.Server, *client.Client) {
-	cfg := generated.Config{Resolvers: &graph.Resolver{}}
+	authorizer := graph.NewAuthorizer()
+	cfg := generated.Config{Resolvers: &graph.Resolver{Config: graph.DefaultConfig()}, Directives: generated.DirectiveRoot{HasRole: graph.HasRole, Auth: authorizer.Auth}}
 	h := handler.NewDefaultServer(generated.NewExecutableSchema(cfg))
+	h.Use(authorizer)
 	c := client.New(h)
 	return cfg, h, c
 }
 
 func Setup() (*sql.DB, *gorm.DB, sqlmock.Sqlmock, error) {
 	var dsnPostgre *string
+	var dsnMySQL *string
+	var dsnSQLite *string
 	var gormLogger logger.Interface
 	for _, e := range os.Environ() {
 		pair := strings.SplitN(e, "=", 2)
 		if pair[0] == "TEST_DB_POSTGRES" {
 			dsnPostgre = &pair[1]
+		} else if pair[0] == "TEST_DB_MYSQL" {
+			dsnMySQL = &pair[1]
+		} else if pair[0] == "TEST_DB_SQLITE" {
+			dsnSQLite = &pair[1]
+			if *dsnSQLite == "" || *dsnSQLite == "1" {
+				dsnSQLite = graph.Of(":memory:")
+			}
 		} else if pair[0] == "LOGGER" && pair[1] != "" {
 			gormLogger = logger.New(
 				log.New(os.Stdout, "\r\n", log.LstdFlags),
@@ -108,10 +151,28 @@ func Setup() (*sql.DB, *gorm.DB, sqlmock.Sqlmock, error) {
 		)
 	}
 
+	var dialector gorm.Dialector
 	if dsnPostgre != nil {
-		if db, err := gorm.Open(postgres.New(postgres.Config{DSN: *dsnPostgre}), &gorm.Config{Logger: gormLogger}); err != nil {
+		dialector = postgres.New(postgres.Config{DSN: *dsnPostgre})
+	} else if dsnMySQL != nil {
+		dialector = mysql.New(mysql.Config{DSN: *dsnMySQL, DefaultStringSize: 191})
+	} else if dsnSQLite != nil {
+		dialector = sqlite.Open(*dsnSQLite)
+	}
+	if dialector != nil {
+		if db, err := gorm.Open(dialector, &gorm.Config{Logger: gormLogger}); err != nil {
 			return nil, nil, nil, err
 		} else {
+			if dsnSQLite != nil {
+				if sqlDB, err := db.DB(); err != nil {
+					return nil, nil, nil, err
+				} else {
+					sqlDB.SetMaxOpenConns(1)
+				}
+			}
+			if err := db.Use(graph.TenantPlugin{}); err != nil {
+				return nil, nil, nil, err
+			}
 			if err := graph.Migrate(db); err != nil {
 				return nil, nil, nil, err
 			}
@@ -135,11 +196,46 @@ func Setup() (*sql.DB, *gorm.DB, sqlmock.Sqlmock, error) {
 		if db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: gormLogger}); err != nil {
 			return sqlDB, db, mock, err
 		} else {
-			return sqlDB, db, mock, nil
+			return sqlDB, db, mock, db.Use(graph.TenantPlugin{})
 		}
 	}
 }
 
+// OpenSQLite opens a private in-memory sqlite database scoped by
+// graph.TenantPlugin, the way graph.Open scopes the served database. The
+// database is closed when the test ends.
+func OpenSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.Use(graph.TenantPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// SetupSQLite is OpenSQLite with the schema migrated and the sample data
+// populated into the default tenant.
+func SetupSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := OpenSQLite(t)
+	if err := graph.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Populate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
 func Populate(tx *gorm.DB) error {
 	return graph.Populate(tx)
 }
@@ -150,3 +246,46 @@ func QuoteMeta(r string) string {
 	r = strings.ReplaceAll(r, " )", ")")
 	return "^" + regexp.QuoteMeta(r) + "$"
 }
+
+func ExpectAudit(mock sqlmock.Sqlmock, auditID int, entity string, id int, action string, operation string) {
+	mock.ExpectQuery(QuoteMeta(`
+      INSERT INTO "audit_entries" ("entity","entity_id","action","operation","actor","before","after","at","tenant_id") 
+      VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"
+   `)).WithArgs(entity, id, action, operation, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(auditID))
+	if e, ok := map[string]string{"authors": "AUTHOR", "books": "BOOK", "book_series": "BOOK_SERIES", "reviews": "REVIEW"}[entity]; ok {
+		mock.ExpectQuery(QuoteMeta(`
+         INSERT INTO "change_events" ("entity","entity_id","action","operation","actor","data","at","tenant_id")
+         VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"
+      `)).WithArgs(e, id, action, operation, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "default").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(auditID))
+	}
+	if entity == "books" {
+		// no webhook is subscribed, nothing is queued
+		mock.ExpectQuery(QuoteMeta(`SELECT * FROM "webhooks" WHERE "webhooks"."tenant_id" = $1`)).WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"id", "url", "events"}))
+	}
+}
+
+func ExpectPreloadBookAuthors(mock sqlmock.Sqlmock, bookID int, authors map[int]string) {
+	bookAuthors := sqlmock.NewRows([]string{"book_id", "author_id"})
+	rows := sqlmock.NewRows([]string{"id", "name", "version"})
+	ids := []int{}
+	for id := range authors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	args := []driver.Value{}
+	params := []string{}
+	for i, id := range ids {
+		bookAuthors.AddRow(bookID, id)
+		rows.AddRow(id, authors[id], 1)
+		args = append(args, id)
+		params = append(params, fmt.Sprintf("$%d", i+1))
+	}
+	mock.ExpectQuery(QuoteMeta(`SELECT * FROM "book_authors" WHERE "book_authors"."book_id" = $1`)).WithArgs(bookID).
+		WillReturnRows(bookAuthors)
+	if len(ids) > 0 {
+		mock.ExpectQuery(QuoteMeta(fmt.Sprintf(`SELECT * FROM "authors" WHERE "authors"."id" IN (%s)`, strings.Join(params, ",")))).WithArgs(args...).
+			WillReturnRows(rows)
+	}
+}
@@ -0,0 +1,326 @@
+package graph
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const Context_RequestID = ContextID("RequestID")
+
+// RequestIDHeaders are checked in order for an id set by a proxy or the client, the first one is
+// also echoed back on the response.
+var RequestIDHeaders = []string{"X-Request-ID", "X-Correlation-ID"}
+
+// RedactedFields are matched case-insensitively against variable names at any depth, a name
+// containing any of them has its value replaced.
+var RedactedFields = []string{"password", "secret", "token", "apikey", "api_key", "authorization", "credential"}
+
+const redacted = "[REDACTED]"
+
+func RequestIDOf(ctx context.Context) string {
+	id, _ := ctx.Value(Context_RequestID).(string)
+	return id
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, Context_RequestID, id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Logger writes one JSON object per line. Entries logged with a request context carry its
+// request_id, and trace_id when traced, so the request, its operation and its SQL can be joined.
+type Logger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewLogger(out io.Writer) *Logger {
+	return &Logger{out: out}
+}
+
+// DefaultLogger writes to stdout, the server and the SQL logger of Open share it.
+var DefaultLogger = NewLogger(os.Stdout)
+
+func (l *Logger) Log(ctx context.Context, level string, msg string, fields map[string]interface{}) {
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+	if id := RequestIDOf(ctx); id != "" {
+		entry["request_id"] = id
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry["trace_id"] = sc.TraceID().String()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{"time": entry["time"], "level": "error", "msg": "unable to encode log entry", "error": err.Error()})
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, fields map[string]interface{}) {
+	l.Log(ctx, "info", msg, fields)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, fields map[string]interface{}) {
+	l.Log(ctx, "error", msg, fields)
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// statusRecorder keeps the status for the access log and still lets export flush and
+// subscriptions hijack the connection.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		if r.status == 0 {
+			r.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Middleware assigns the request id and writes an access log entry once the request completes.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		for _, h := range RequestIDHeaders {
+			if id = r.Header.Get(h); id != "" {
+				break
+			}
+		}
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeaders[0], id)
+		ctx := WithRequestID(r.Context(), id)
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		l.Info(ctx, "request", map[string]interface{}{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      rec.status,
+			"duration_ms": durationMillis(time.Since(start)),
+			"remote":      r.RemoteAddr,
+		})
+	})
+}
+
+func isRedacted(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range RedactedFields {
+		if strings.Contains(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactVariables returns a copy of the variables with the values of sensitive fields replaced.
+func RedactVariables(vars map[string]interface{}) map[string]interface{} {
+	return redact(vars).(map[string]interface{})
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			if isRedacted(k) {
+				res[k] = redacted
+			} else {
+				res[k] = redact(value)
+			}
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, value := range v {
+			res[i] = redact(value)
+		}
+		return res
+	}
+	return v
+}
+
+// Logging is a gqlgen extension writing one entry per operation.
+type Logging struct {
+	Logger *Logger
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = Logging{}
+
+func (Logging) ExtensionName() string {
+	return "Logging"
+}
+
+func (Logging) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e Logging) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	start := time.Now()
+	resp := next(ctx)
+	fields := map[string]interface{}{
+		"operation":   oc.OperationName,
+		"variables":   RedactVariables(oc.Variables),
+		"duration_ms": durationMillis(time.Since(start)),
+	}
+	if oc.Operation != nil {
+		fields["type"] = string(oc.Operation.Operation)
+		if oc.OperationName == "" {
+			fields["operation"] = oc.Operation.Name
+		}
+	}
+	level := "info"
+	if resp != nil && len(resp.Errors) > 0 {
+		level = "error"
+		codes := []string{}
+		messages := []string{}
+		for _, err := range resp.Errors {
+			if code, ok := err.Extensions["code"].(string); ok {
+				codes = append(codes, code)
+			}
+			messages = append(messages, err.Message)
+		}
+		fields["error_codes"] = codes
+		fields["errors"] = messages
+	}
+	e.Logger.Log(ctx, level, "graphql", fields)
+	return resp
+}
+
+// SQLLogger is a gorm logger writing statements through Logger, correlated by the request id of
+// the statement context.
+type SQLLogger struct {
+	Logger        *Logger
+	Level         logger.LogLevel
+	SlowThreshold time.Duration
+}
+
+var _ logger.Interface = (*SQLLogger)(nil)
+
+func (s *SQLLogger) LogMode(level logger.LogLevel) logger.Interface {
+	c := *s
+	c.Level = level
+	return &c
+}
+
+func (s *SQLLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if s.Level >= logger.Info {
+		s.Logger.Info(ctx, fmt.Sprintf(msg, data...), nil)
+	}
+}
+
+func (s *SQLLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if s.Level >= logger.Warn {
+		s.Logger.Log(ctx, "warn", fmt.Sprintf(msg, data...), nil)
+	}
+}
+
+func (s *SQLLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if s.Level >= logger.Error {
+		s.Logger.Error(ctx, fmt.Sprintf(msg, data...), nil)
+	}
+}
+
+func (s *SQLLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if s.Level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	failed := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+	slow := s.SlowThreshold > 0 && elapsed > s.SlowThreshold
+	level := "info"
+	switch {
+	case failed && s.Level >= logger.Error:
+		level = "error"
+	case slow && s.Level >= logger.Warn:
+		level = "warn"
+	case s.Level < logger.Info:
+		return
+	}
+	sql, rows := fc()
+	fields := map[string]interface{}{
+		"sql":         sql,
+		"rows":        rows,
+		"duration_ms": durationMillis(elapsed),
+	}
+	if failed {
+		fields["error"] = err.Error()
+	}
+	if slow {
+		fields["slow"] = true
+	}
+	s.Logger.Log(ctx, level, "sql", fields)
+}
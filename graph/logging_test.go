@@ -0,0 +1,106 @@
+package graph_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/logger"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log := graph.NewLogger(&buf)
+
+	db := SetupSQLite(t)
+	db.Logger = &graph.SQLLogger{Logger: log, Level: logger.Info}
+
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.Logging{Logger: log})
+	c := client.New(log.Middleware(resolver.Middleware(srv)))
+
+	entries := func() []map[string]interface{} {
+		res := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			entry := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("invalid log line %s: %v", line, err)
+			}
+			res = append(res, entry)
+		}
+		buf.Reset()
+		return res
+	}
+
+	t.Run("operation with request id", func(t *testing.T) {
+		var resp struct {
+			UpdateBook struct{ ID int }
+		}
+		err := c.Post(`mutation StaleUpdate($id: Int!) {
+         updateBook(input: {id: $id, expectedVersion: 5, title: "Stale"}) {
+            id
+         }
+      }`, &resp, client.Var("id", 1), client.AddHeader("X-Request-ID", "req-1"))
+		assert.ErrorContains(t, err, "CONFLICT")
+
+		msgs := map[string]map[string]interface{}{}
+		for _, e := range entries() {
+			assert.Equal(t, "req-1", e["request_id"], e["msg"])
+			msgs[e["msg"].(string)] = e
+		}
+		if assert.Contains(t, msgs, "request") {
+			assert.Equal(t, "/", msgs["request"]["path"])
+			assert.Equal(t, float64(200), msgs["request"]["status"])
+		}
+		if assert.Contains(t, msgs, "graphql") {
+			assert.Equal(t, "error", msgs["graphql"]["level"])
+			assert.Equal(t, "StaleUpdate", msgs["graphql"]["operation"])
+			assert.Equal(t, "mutation", msgs["graphql"]["type"])
+			assert.Equal(t, map[string]interface{}{"id": float64(1)}, msgs["graphql"]["variables"])
+			assert.Equal(t, []interface{}{"CONFLICT"}, msgs["graphql"]["error_codes"])
+		}
+		if assert.Contains(t, msgs, "sql") {
+			assert.Contains(t, msgs["sql"]["sql"], "books")
+		}
+	})
+
+	t.Run("generated request id", func(t *testing.T) {
+		var resp struct {
+			Books struct{ Count int }
+		}
+		c.MustPost(`{ books { count } }`, &resp)
+		ids := map[interface{}]bool{}
+		for _, e := range entries() {
+			ids[e["request_id"]] = true
+		}
+		assert.Len(t, ids, 1)
+		for id := range ids {
+			assert.Len(t, id, 32)
+		}
+	})
+
+	t.Run("redact variables", func(t *testing.T) {
+		assert.Equal(t, map[string]interface{}{
+			"input": map[string]interface{}{
+				"name":     "admin",
+				"password": "[REDACTED]",
+				"keys":     []interface{}{map[string]interface{}{"apiKey": "[REDACTED]"}},
+			},
+			"resetToken": "[REDACTED]",
+		}, graph.RedactVariables(map[string]interface{}{
+			"input": map[string]interface{}{
+				"name":     "admin",
+				"password": "s3cret",
+				"keys":     []interface{}{map[string]interface{}{"apiKey": "abc"}},
+			},
+			"resetToken": "xyz",
+		}))
+	})
+}
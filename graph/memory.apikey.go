@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func copyAPIKey(key *model.APIKey) *model.APIKey {
+	c := *key
+	c.Scopes = append([]string{}, key.Scopes...)
+	return &c
+}
+
+func (m *MemoryRepository) APIKeys(ctx context.Context, includeRevoked *bool) ([]*model.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []*model.APIKey{}
+	for _, k := range m.apiKeys {
+		if !inTenant(ctx, k.TenantID) {
+			continue
+		}
+		if k.RevokedAt == nil || (includeRevoked != nil && *includeRevoked) {
+			keys = append(keys, copyAPIKey(k))
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryRepository) APIKeyByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.apiKeys {
+		if k.Prefix == prefix && inTenant(ctx, k.TenantID) {
+			c := copyAPIKey(k)
+			if k.UserID != nil {
+				c.User = m.findUser(ctx, *k.UserID)
+			}
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryRepository) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.Prefix == key.Prefix {
+			return fmt.Errorf(`duplicate key api_keys.prefix "%s"`, key.Prefix)
+		}
+	}
+	if key.UserID != nil && m.findUser(ctx, *key.UserID) == nil {
+		return fmt.Errorf("user with id '%v' does not exist", *key.UserID)
+	}
+	tenant, err := tenantFor(ctx, "api_keys")
+	if err != nil {
+		return err
+	}
+	key.ID = m.nextID("api_keys")
+	key.TenantID = tenant
+	key.CreatedBy = Actor(ctx)
+	if err := m.record(ctx, model.AuditActionInsert, "api_keys", key.ID, nil, auditAPIKey(key)); err != nil {
+		return err
+	}
+	m.apiKeys = append(m.apiKeys, copyAPIKey(key))
+	return nil
+}
+
+func (m *MemoryRepository) RevokeAPIKey(ctx context.Context, id int, at time.Time) (*model.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.ID == id && k.RevokedAt == nil && inTenant(ctx, k.TenantID) {
+			before := auditAPIKey(k)
+			k.RevokedAt = &at
+			if err := m.record(ctx, model.AuditActionUpdate, "api_keys", k.ID, before, auditAPIKey(k)); err != nil {
+				k.RevokedAt = nil
+				return nil, err
+			}
+			return copyAPIKey(k), nil
+		}
+	}
+	return nil, fmt.Errorf("active api key with id '%v' does not exist", id)
+}
+
+func (m *MemoryRepository) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.ID == id && inTenant(ctx, k.TenantID) {
+			k.LastUsedAt = &at
+		}
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func (m *MemoryRepository) findBook(ctx context.Context, id int) *model.Book {
+	for _, b := range m.books {
+		if b.ID == id && inTenant(ctx, b.TenantID) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) titleTaken(tenant string, title string, id int) bool {
+	for _, b := range m.books {
+		if b.Title == title && b.ID != id && b.TenantID == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryRepository) matchBook(book *model.Book, filter *model.BookFilter, includeDeleted *bool) bool {
+	if book.DeletedAt != nil && (includeDeleted == nil || !*includeDeleted) {
+		return false
+	}
+	if filter == nil {
+		return true
+	}
+	if filter.ID != nil && book.ID != *filter.ID {
+		return false
+	}
+	if filter.Title != nil && !MatchText(filter.Title, book.Title) {
+		return false
+	}
+	if filter.AuthorName != nil {
+		names := make([]string, len(book.Authors))
+		for i, a := range book.Authors {
+			names[i] = a.Name
+		}
+		if !MatchSubQueryText(filter.AuthorName, names) {
+			return false
+		}
+	}
+	if filter.Star != nil && (filter.Star.Min != nil || filter.Star.Max != nil) {
+		found := false
+		for _, r := range m.reviews {
+			found = found || (r.BookID == book.ID && MatchIntRange(filter.Star, r.Star))
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemoryRepository) findBooks(ctx context.Context, seriesID *int, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) *model.BookList {
+	books := []*model.Book{}
+	for _, b := range m.books {
+		if !inTenant(ctx, b.TenantID) {
+			continue
+		}
+		if seriesID != nil && (b.SeriesID == nil || *b.SeriesID != *seriesID) {
+			continue
+		}
+		if m.matchBook(b, filter, includeDeleted) {
+			books = append(books, b)
+		}
+	}
+	start, end := paginate(len(books), offset, limit)
+	list := make([]*model.Book, 0, end-start)
+	for _, b := range books[start:end] {
+		list = append(list, copyBook(b))
+	}
+	return &model.BookList{List: list, Count: len(books)}
+}
+
+func (m *MemoryRepository) Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findBooks(ctx, nil, offset, limit, filter, includeDeleted), nil
+}
+
+func (m *MemoryRepository) BooksSeriesBooks(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findBooks(ctx, &obj.ID, offset, limit, filter, includeDeleted), nil
+}
+
+func (m *MemoryRepository) ReviewBook(ctx context.Context, obj *model.Review) (*model.Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if book := m.findBook(ctx, obj.BookID); book != nil && (book.DeletedAt == nil || HasRoleOf(ctx, RoleAdmin)) {
+		return copyBook(book), nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryRepository) BookHistory(ctx context.Context, obj *model.Book) ([]*model.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := []*model.AuditEntry{}
+	for _, e := range m.audit {
+		if e.Entity == "books" && e.EntityID == obj.ID && inTenant(ctx, e.TenantID) {
+			c := *e
+			entries = append(entries, &c)
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryRepository) BookAsOf(ctx context.Context, id int, at time.Time) (*model.Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entry *model.AuditEntry
+	for _, e := range m.audit {
+		if e.Entity == "books" && e.EntityID == id && !e.At.After(at) && inTenant(ctx, e.TenantID) {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return BookOf(ctx, m.namer, entry)
+}
+
+func (m *MemoryRepository) CreateBook(ctx context.Context, input model.NewBook) (*model.Book, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	authors, err := m.authorsByName(ctx, input.AuthorsName)
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := tenantFor(ctx, "books")
+	if err != nil {
+		return nil, err
+	}
+	if m.titleTaken(tenant, input.Title, 0) {
+		return nil, fmt.Errorf(`duplicate key books.title "%s"`, input.Title)
+	}
+	book := &model.Book{ID: m.nextID("books"), Title: input.Title, Authors: authors, Version: 1, TenantID: tenant}
+	if err := m.record(ctx, model.AuditActionInsert, "books", book.ID, nil, book); err != nil {
+		return nil, err
+	}
+	m.books = append(m.books, book)
+	return copyBook(book), nil
+}
+
+func (m *MemoryRepository) UpdateBook(ctx context.Context, input model.UpdateBook) (*model.Book, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	book := m.findBook(ctx, input.ID)
+	if book == nil || book.DeletedAt != nil {
+		return nil, fmt.Errorf("book with id '%v' does not exist", input.ID)
+	}
+	if book.Version != input.ExpectedVersion {
+		return nil, NewConflictError(fmt.Sprintf("book with id '%v' has version %v, expected %v", input.ID, book.Version, input.ExpectedVersion), copyBook(book))
+	}
+	updated := *book
+	updated.Version = input.ExpectedVersion + 1
+	if input.Title != nil {
+		if m.titleTaken(book.TenantID, *input.Title, book.ID) {
+			return nil, fmt.Errorf(`duplicate key books.title "%s"`, *input.Title)
+		}
+		updated.Title = *input.Title
+	}
+	if input.AuthorsName != nil {
+		authors, err := m.authorsByName(ctx, input.AuthorsName)
+		if err != nil {
+			return nil, err
+		}
+		updated.Authors = authors
+	}
+	if err := m.record(ctx, model.AuditActionUpdate, "books", book.ID, book, &updated); err != nil {
+		return nil, err
+	}
+	*book = updated
+	return copyBook(book), nil
+}
+
+func (m *MemoryRepository) DeleteBook(ctx context.Context, id int) (*model.Book, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	book := m.findBook(ctx, id)
+	if book == nil || book.DeletedAt != nil {
+		return nil, fmt.Errorf("book with id '%v' does not exist", id)
+	}
+	deleted := *book
+	deleted.DeletedAt = Of(time.Now())
+	deleted.Version = book.Version + 1
+	if err := m.record(ctx, model.AuditActionDelete, "books", book.ID, book, &deleted); err != nil {
+		return nil, err
+	}
+	*book = deleted
+	return copyBook(book), nil
+}
+
+func (m *MemoryRepository) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	book := m.findBook(ctx, id)
+	if book == nil || book.DeletedAt == nil {
+		return nil, fmt.Errorf("deleted book with id '%v' does not exist", id)
+	}
+	restored := *book
+	restored.DeletedAt = nil
+	restored.Version = book.Version + 1
+	if err := m.record(ctx, model.AuditActionUpdate, "books", book.ID, book, &restored); err != nil {
+		return nil, err
+	}
+	*book = restored
+	return copyBook(book), nil
+}
+
+func (m *MemoryRepository) PurgeDeleted(ctx context.Context, olderThan time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	purged := map[int]bool{}
+	books := []*model.Book{}
+	for _, b := range m.books {
+		if b.DeletedAt != nil && b.DeletedAt.Before(olderThan) && inTenant(ctx, b.TenantID) {
+			if err := m.record(ctx, model.AuditActionDelete, "books", b.ID, b, nil); err != nil {
+				return 0, err
+			}
+			purged[b.ID] = true
+		} else {
+			books = append(books, b)
+		}
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	reviews := []*model.Review{}
+	for _, r := range m.reviews {
+		if !purged[r.BookID] {
+			reviews = append(reviews, r)
+		}
+	}
+	m.books = books
+	m.reviews = reviews
+	return len(purged), nil
+}
+
+func (m *MemoryRepository) ImportBooks(ctx context.Context, r io.Reader, format model.ImportFormat, batchSize int) (*model.ImportResult, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size %v", batchSize)
+	}
+	res := &model.ImportResult{Errors: []*model.ImportError{}}
+	err := ReadImportRows(r, format, func(row int, rec *ImportRow, err error) error {
+		res.Rows++
+		if err == nil {
+			err = m.importRow(ctx, rec)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, &model.ImportError{Row: row, Message: err.Error()})
+		} else {
+			res.Imported++
+		}
+		return nil
+	})
+	return res, err
+}
+
+// importRow validates the row before touching the store, so a failed row leaves nothing behind.
+func (m *MemoryRepository) importRow(ctx context.Context, rec *ImportRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	author, err := importAuthor(ctx, rec)
+	if err != nil {
+		return err
+	}
+	tenant, err := tenantFor(ctx, "books")
+	if err != nil {
+		return err
+	}
+	if m.titleTaken(tenant, rec.Title, 0) {
+		return fmt.Errorf(`duplicate key books.title "%s"`, rec.Title)
+	}
+	book := &model.Book{Title: rec.Title, Authors: []*model.Author{}, Version: 1, TenantID: tenant}
+	if rec.Series != nil && *rec.Series != "" {
+		var series *model.BookSeries
+		for _, s := range m.series {
+			if s.Title == *rec.Series && s.TenantID == tenant {
+				series = s
+			}
+		}
+		if series == nil {
+			series = &model.BookSeries{ID: m.nextID("book_series"), Title: *rec.Series, Version: 1, TenantID: tenant}
+			if err := m.record(ctx, model.AuditActionInsert, "book_series", series.ID, nil, series); err != nil {
+				return err
+			}
+			m.series = append(m.series, series)
+		}
+		book.SeriesID = &series.ID
+	}
+	for _, name := range rec.Authors {
+		var author *model.Author
+		for _, a := range m.authors {
+			if a.Name == name && a.TenantID == tenant {
+				author = a
+			}
+		}
+		if author == nil {
+			author = &model.Author{ID: m.nextID("authors"), Name: name, Version: 1, TenantID: tenant}
+			if err := m.record(ctx, model.AuditActionInsert, "authors", author.ID, nil, author); err != nil {
+				return err
+			}
+			m.authors = append(m.authors, author)
+		}
+		duplicate := false
+		for _, a := range book.Authors {
+			duplicate = duplicate || a.ID == author.ID
+		}
+		if !duplicate {
+			book.Authors = append(book.Authors, author)
+		}
+	}
+	book.ID = m.nextID("books")
+	if err := m.record(ctx, model.AuditActionInsert, "books", book.ID, nil, book); err != nil {
+		return err
+	}
+	m.books = append(m.books, book)
+	for _, r := range rec.Reviews {
+		review := &model.Review{ID: m.nextID("reviews"), BookID: book.ID, UserID: author, Star: r.Star, Text: r.Text, Version: 1, TenantID: tenant}
+		if err := m.record(ctx, model.AuditActionInsert, "reviews", review.ID, nil, review); err != nil {
+			return err
+		}
+		m.reviews = append(m.reviews, review)
+	}
+	return nil
+}
+
+func (m *MemoryRepository) ExportBooks(ctx context.Context, w io.Writer, format ExportFormat) (int, error) {
+	out, err := newExportWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, b := range m.books {
+		if b.DeletedAt != nil || !inTenant(ctx, b.TenantID) {
+			continue
+		}
+		row := &ExportRow{ID: b.ID, Title: b.Title, Authors: []string{}}
+		for _, s := range m.series {
+			if b.SeriesID != nil && s.ID == *b.SeriesID {
+				row.Series = Of(s.Title)
+			}
+		}
+		for _, a := range b.Authors {
+			row.Authors = append(row.Authors, a.Name)
+		}
+		sort.Strings(row.Authors)
+		stars := 0
+		for _, r := range m.reviews {
+			if r.BookID == b.ID {
+				row.ReviewCount++
+				stars += r.Star
+			}
+		}
+		if row.ReviewCount > 0 {
+			row.Rating = Of(float64(stars) / float64(row.ReviewCount))
+		}
+		if err := out.Write(row); err != nil {
+			return count, err
+		}
+		count++
+		if err := flushExport(w, out, count); err != nil {
+			return count, err
+		}
+	}
+	return count, out.Flush()
+}
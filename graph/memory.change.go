@@ -0,0 +1,21 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func (m *MemoryRepository) Changes(ctx context.Context, since model.Cursor, limit int) (*model.ChangeFeed, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events := []*model.ChangeEvent{}
+	for _, e := range m.changes {
+		if e.ID > int(since) && len(events) <= limit {
+			c := *e
+			events = append(events, &c)
+		}
+	}
+	return changeFeed(ctx, m, events, since, limit, time.Now()), nil
+}
@@ -0,0 +1,450 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm/schema"
+)
+
+// MemoryRepository keeps the whole catalog in process. It follows the same filter, pagination and
+// error semantics as DataSource so resolvers can be exercised without a database; every value it
+// hands out is a copy, callers never share state with the store.
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	namer   schema.Namer
+	lastID  map[string]int
+	authors []*model.Author
+	series  []*model.BookSeries
+	books   []*model.Book
+	reviews []*model.Review
+	users   []*model.User
+	audit   []*model.AuditEntry
+	apiKeys []*model.APIKey
+
+	webhooks   []*model.Webhook
+	deliveries []*model.WebhookDelivery
+	changes    []*model.ChangeEvent
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		namer:  schema.NamingStrategy{},
+		lastID: map[string]int{},
+	}
+}
+
+// inTenant reports whether a row of tenant is visible in ctx. Like TenantPlugin a context without a
+// tenant sees nothing, only a context lifted by AnyTenant sees every tenant.
+func inTenant(ctx context.Context, tenant string) bool {
+	t := tenantScope(ctx)
+	return t == anyTenant || (t != "" && t == tenant)
+}
+
+// tenantFor returns the tenant of the rows of table created in ctx, failing like TenantPlugin when
+// the context has no tenant.
+func tenantFor(ctx context.Context, table string) (string, error) {
+	if t := TenantOf(ctx); t != "" {
+		return t, nil
+	}
+	return "", errNoTenant(table)
+}
+
+func (m *MemoryRepository) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+func (m *MemoryRepository) record(ctx context.Context, action model.AuditAction, entity string, id int, before interface{}, after interface{}) error {
+	entry, err := NewAuditEntry(ctx, m.namer, action, entity, id, before, after)
+	if err != nil {
+		return err
+	}
+	if entry.TenantID, err = tenantFor(ctx, "audit_entries"); err != nil {
+		return err
+	}
+	entry.ID = m.nextID("audit_entries")
+	change, err := NewChangeEvent(ctx, m.namer, action, entity, id, before, after, entry.At)
+	if err != nil {
+		return err
+	}
+	webhooks := []*model.Webhook{}
+	for _, w := range m.webhooks {
+		if w.TenantID == entry.TenantID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	deliveries, err := NewWebhookDeliveries(ctx, m.namer, webhooks, action, entity, id, before, after, entry.At)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		d.ID = m.nextID("webhook_deliveries")
+		d.TenantID = entry.TenantID
+		m.deliveries = append(m.deliveries, d)
+	}
+	if change != nil {
+		change.ID = m.nextID("change_events")
+		change.TenantID = entry.TenantID
+		m.changes = append(m.changes, change)
+	}
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+// Populate loads the same sample data as graph.Populate into the default tenant.
+func (m *MemoryRepository) Populate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	authors := map[string]*model.Author{}
+	for _, name := range []string{"J.K. Rowling", "Lord Voldermort", "Salazar Slitherin", "Albus Dumbledore"} {
+		authors[name] = &model.Author{ID: m.nextID("authors"), Name: name, Version: 1, TenantID: DefaultTenant}
+		m.authors = append(m.authors, authors[name])
+	}
+	series := &model.BookSeries{ID: m.nextID("book_series"), Title: "Harry Potter", Version: 1, TenantID: DefaultTenant}
+	m.series = append(m.series, series)
+	for _, b := range []struct {
+		title   string
+		series  *int
+		authors []string
+	}{
+		{"Harry Potter and the Sorcerer's Stone", &series.ID, []string{"J.K. Rowling"}},
+		{"Harry Potter and the Chamber of Secrets", &series.ID, []string{"J.K. Rowling"}},
+		{"Harry Potter and the Book of Evil", nil, []string{"Lord Voldermort"}},
+		{"Harry Potter and the Snake Dictionary", nil, []string{"Lord Voldermort", "Salazar Slitherin"}},
+	} {
+		book := &model.Book{ID: m.nextID("books"), Title: b.title, SeriesID: b.series, Authors: []*model.Author{}, Version: 1, TenantID: DefaultTenant}
+		for _, name := range b.authors {
+			book.Authors = append(book.Authors, authors[name])
+		}
+		m.books = append(m.books, book)
+	}
+	for _, r := range []struct {
+		bookID int
+		star   int
+		text   string
+	}{
+		{1, 5, "The Boy Who Live"},
+		{2, 5, "The Girl Who Kill"},
+		{3, 1, "Fake Books"},
+		{1, 3, "The Man With Funny Hat"},
+	} {
+		m.reviews = append(m.reviews, &model.Review{ID: m.nextID("reviews"), BookID: r.bookID, Star: r.star, Text: r.text, Version: 1, TenantID: DefaultTenant})
+	}
+}
+
+func copyAuthors(authors []*model.Author) []*model.Author {
+	res := make([]*model.Author, len(authors))
+	for i, a := range authors {
+		c := *a
+		res[i] = &c
+	}
+	return res
+}
+
+func copyBook(book *model.Book) *model.Book {
+	c := *book
+	c.Authors = copyAuthors(book.Authors)
+	c.Series = nil
+	c.Reviews = nil
+	c.History = nil
+	return &c
+}
+
+// likeRegexp translates a SQL LIKE pattern, % matches any run of characters and _ a single one.
+func likeRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func MatchText(filter *model.FilterText, value string) bool {
+	switch filter.Op {
+	case model.FilterTextOpLike:
+		return likeRegexp(filter.Value).MatchString(value)
+	case model.FilterTextOpNotLike:
+		return !likeRegexp(filter.Value).MatchString(value)
+	case model.FilterTextOpEq:
+		return value == filter.Value
+	case model.FilterTextOpNotEq:
+		return value != filter.Value
+	}
+	return true
+}
+
+// MatchSubQueryText mirrors FilterSubQueryText, the negative operators exclude a parent when any
+// of its children matches the positive form.
+func MatchSubQueryText(filter *model.FilterText, values []string) bool {
+	positive := *filter
+	negate := false
+	switch filter.Op {
+	case model.FilterTextOpNotLike:
+		positive.Op = model.FilterTextOpLike
+		negate = true
+	case model.FilterTextOpNotEq:
+		positive.Op = model.FilterTextOpEq
+		negate = true
+	}
+	found := false
+	for _, v := range values {
+		found = found || MatchText(&positive, v)
+	}
+	return found != negate
+}
+
+func MatchIntRange(filter *model.FilterIntRange, value int) bool {
+	if filter.Min != nil && value < *filter.Min {
+		return false
+	}
+	if filter.Max != nil && value > *filter.Max {
+		return false
+	}
+	return true
+}
+
+// paginate returns the bounds of the page of n items selected by offset and limit.
+func paginate(n int, offset *int, limit *int) (int, int) {
+	start, end := 0, n
+	if offset != nil && *offset > 0 {
+		start = *offset
+	}
+	if start > n {
+		start = n
+	}
+	if limit != nil && *limit >= 0 && start+*limit < end {
+		end = start + *limit
+	}
+	return start, end
+}
+
+func (m *MemoryRepository) Authors(ctx context.Context, offset *int, limit *int, filter *model.AuthorFilter) (*model.AuthorList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	authors := []*model.Author{}
+	for _, a := range m.authors {
+		if !inTenant(ctx, a.TenantID) {
+			continue
+		}
+		if filter != nil {
+			if filter.ID != nil && a.ID != *filter.ID {
+				continue
+			}
+			if filter.Name != nil && !MatchText(filter.Name, a.Name) {
+				continue
+			}
+		}
+		authors = append(authors, a)
+	}
+	start, end := paginate(len(authors), offset, limit)
+	return &model.AuthorList{List: copyAuthors(authors[start:end]), Count: len(authors)}, nil
+}
+
+func (m *MemoryRepository) BookAuthors(ctx context.Context, obj *model.Book) ([]*model.Author, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, b := range m.books {
+		if b.ID == obj.ID && inTenant(ctx, b.TenantID) {
+			return copyAuthors(b.Authors), nil
+		}
+	}
+	return []*model.Author{}, nil
+}
+
+func (m *MemoryRepository) CreateAuthor(ctx context.Context, input model.NewAuthor) (*model.Author, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tenant, err := tenantFor(ctx, "authors")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range m.authors {
+		if a.Name == input.Name && a.TenantID == tenant {
+			return nil, fmt.Errorf(`duplicate key authors.name "%s"`, input.Name)
+		}
+	}
+	author := &model.Author{ID: m.nextID("authors"), Name: input.Name, Version: 1, TenantID: tenant}
+	if err := m.record(ctx, model.AuditActionInsert, "authors", author.ID, nil, author); err != nil {
+		return nil, err
+	}
+	m.authors = append(m.authors, author)
+	c := *author
+	return &c, nil
+}
+
+func (m *MemoryRepository) BookSeries(ctx context.Context, offset *int, limit *int, filter *model.BookSeriesFilter) (*model.BookSeriesList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	series := []*model.BookSeries{}
+	for _, s := range m.series {
+		if !inTenant(ctx, s.TenantID) {
+			continue
+		}
+		if filter != nil {
+			if filter.ID != nil && s.ID != *filter.ID {
+				continue
+			}
+			if filter.Title != nil && !MatchText(filter.Title, s.Title) {
+				continue
+			}
+		}
+		series = append(series, s)
+	}
+	start, end := paginate(len(series), offset, limit)
+	list := make([]*model.BookSeries, 0, end-start)
+	for _, s := range series[start:end] {
+		c := *s
+		list = append(list, &c)
+	}
+	return &model.BookSeriesList{List: list, Count: len(series)}, nil
+}
+
+func (m *MemoryRepository) BookReviews(ctx context.Context, obj *model.Book, offset *int, limit *int, filter *model.ReviewFilter) ([]*model.Review, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	reviews := []*model.Review{}
+	for _, r := range m.reviews {
+		if r.BookID != obj.ID || !inTenant(ctx, r.TenantID) {
+			continue
+		}
+		if filter != nil && filter.Star != nil && !MatchIntRange(filter.Star, r.Star) {
+			continue
+		}
+		c := *r
+		reviews = append(reviews, &c)
+	}
+	start, end := paginate(len(reviews), offset, limit)
+	return reviews[start:end], nil
+}
+
+func (m *MemoryRepository) CreateReview(ctx context.Context, input model.NewReview) (*model.Review, error) {
+	user := UserOf(ctx)
+	if user == nil {
+		return nil, NewUnauthenticatedError("creating a review requires a user")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if book := m.findBook(ctx, input.BookID); book == nil || book.DeletedAt != nil {
+		return nil, fmt.Errorf("book with id '%v' does not exist", input.BookID)
+	}
+	for _, r := range m.reviews {
+		if r.BookID == input.BookID && r.UserID != nil && *r.UserID == user.ID {
+			return nil, fmt.Errorf("user '%s' already reviewed book with id '%v'", user.Name, input.BookID)
+		}
+	}
+	tenant, err := tenantFor(ctx, "reviews")
+	if err != nil {
+		return nil, err
+	}
+	review := &model.Review{ID: m.nextID("reviews"), BookID: input.BookID, UserID: Of(user.ID), Star: input.Star, Text: input.Text, Version: 1, TenantID: tenant}
+	if err := m.record(ctx, model.AuditActionInsert, "reviews", review.ID, nil, review); err != nil {
+		return nil, err
+	}
+	m.reviews = append(m.reviews, review)
+	c := *review
+	return &c, nil
+}
+
+func (m *MemoryRepository) findReview(ctx context.Context, id int) (int, error) {
+	for i, r := range m.reviews {
+		if r.ID == id && inTenant(ctx, r.TenantID) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("review with id '%v' does not exist", id)
+}
+
+func (m *MemoryRepository) UpdateReview(ctx context.Context, input model.UpdateReview) (*model.Review, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.findReview(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	review := m.reviews[i]
+	if err := CanModifyReview(ctx, review); err != nil {
+		return nil, err
+	}
+	if review.Version != input.ExpectedVersion {
+		c := *review
+		return nil, NewConflictError(fmt.Sprintf("review with id '%v' has version %v, expected %v", input.ID, review.Version, input.ExpectedVersion), &c)
+	}
+	updated := *review
+	updated.Version++
+	if input.Star != nil {
+		updated.Star = *input.Star
+	}
+	if input.Text != nil {
+		updated.Text = *input.Text
+	}
+	if err := m.record(ctx, model.AuditActionUpdate, "reviews", review.ID, review, &updated); err != nil {
+		return nil, err
+	}
+	*review = updated
+	return &updated, nil
+}
+
+func (m *MemoryRepository) DeleteReview(ctx context.Context, id int) (*model.Review, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, err := m.findReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	review := m.reviews[i]
+	if err := CanModifyReview(ctx, review); err != nil {
+		return nil, err
+	}
+	if err := m.record(ctx, model.AuditActionDelete, "reviews", review.ID, review, nil); err != nil {
+		return nil, err
+	}
+	m.reviews = append(m.reviews[:i], m.reviews[i+1:]...)
+	c := *review
+	return &c, nil
+}
+
+func (m *MemoryRepository) authorsByName(ctx context.Context, names []string) ([]*model.Author, error) {
+	authors := []*model.Author{}
+	missing := []string{}
+	for _, name := range names {
+		var author *model.Author
+		for _, a := range m.authors {
+			if a.Name == name && inTenant(ctx, a.TenantID) {
+				author = a
+			}
+		}
+		if author == nil {
+			missing = append(missing, name)
+			continue
+		}
+		duplicate := false
+		for _, a := range authors {
+			duplicate = duplicate || a.ID == author.ID
+		}
+		if !duplicate {
+			authors = append(authors, author)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("author with name '%s' does not exist", strings.Join(missing, "', '"))
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		return authors[i].ID < authors[j].ID
+	})
+	return authors, nil
+}
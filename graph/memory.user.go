@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func (m *MemoryRepository) findUser(ctx context.Context, id int) *model.User {
+	for _, u := range m.users {
+		if u.ID == id && inTenant(ctx, u.TenantID) {
+			c := *u
+			return &c
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) User(ctx context.Context, id int) (*model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findUser(ctx, id), nil
+}
+
+func (m *MemoryRepository) CreateUser(ctx context.Context, input model.NewUser) (*model.User, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, fmt.Errorf("user name is required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tenant, err := tenantFor(ctx, "users")
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range m.users {
+		if u.Name == input.Name && u.TenantID == tenant {
+			return nil, fmt.Errorf(`duplicate key users.name "%s"`, input.Name)
+		}
+	}
+	user := &model.User{ID: m.nextID("users"), Name: input.Name, Email: input.Email, CreatedAt: time.Now(), TenantID: tenant}
+	if err := m.record(ctx, model.AuditActionInsert, "users", user.ID, nil, user); err != nil {
+		return nil, err
+	}
+	m.users = append(m.users, user)
+	c := *user
+	return &c, nil
+}
@@ -0,0 +1,141 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+func copyWebhook(webhook *model.Webhook) *model.Webhook {
+	c := *webhook
+	c.Events = append([]model.WebhookEvent{}, webhook.Events...)
+	return &c
+}
+
+func (m *MemoryRepository) copyDelivery(delivery *model.WebhookDelivery) *model.WebhookDelivery {
+	c := *delivery
+	for _, w := range m.webhooks {
+		if w.ID == delivery.WebhookID {
+			c.Webhook = copyWebhook(w)
+		}
+	}
+	return &c
+}
+
+func (m *MemoryRepository) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhooks := []*model.Webhook{}
+	for _, w := range m.webhooks {
+		if inTenant(ctx, w.TenantID) {
+			webhooks = append(webhooks, copyWebhook(w))
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *MemoryRepository) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tenant, err := tenantFor(ctx, "webhooks")
+	if err != nil {
+		return err
+	}
+	webhook.ID = m.nextID("webhooks")
+	webhook.CreatedBy = Actor(ctx)
+	webhook.TenantID = tenant
+	if err := m.record(ctx, model.AuditActionInsert, "webhooks", webhook.ID, nil, auditWebhook(webhook)); err != nil {
+		return err
+	}
+	m.webhooks = append(m.webhooks, copyWebhook(webhook))
+	return nil
+}
+
+func (m *MemoryRepository) DeleteWebhook(ctx context.Context, id int) (*model.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.webhooks {
+		if w.ID == id && inTenant(ctx, w.TenantID) {
+			if err := m.record(ctx, model.AuditActionDelete, "webhooks", w.ID, auditWebhook(w), nil); err != nil {
+				return nil, err
+			}
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			deliveries := []*model.WebhookDelivery{}
+			for _, d := range m.deliveries {
+				if d.WebhookID != id {
+					deliveries = append(deliveries, d)
+				}
+			}
+			m.deliveries = deliveries
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook with id '%v' does not exist", id)
+}
+
+func (m *MemoryRepository) WebhookDeliveries(ctx context.Context, status model.WebhookDeliveryStatus, limit int) ([]*model.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	deliveries := []*model.WebhookDelivery{}
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if d := m.deliveries[i]; d.Status == status && inTenant(ctx, d.TenantID) {
+			deliveries = append(deliveries, m.copyDelivery(d))
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryRepository) RetryWebhookDelivery(ctx context.Context, id int, at time.Time) (*model.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.deliveries {
+		if d.ID == id && inTenant(ctx, d.TenantID) {
+			if d.Status == model.WebhookDeliveryStatusPending {
+				return nil, fmt.Errorf("webhook delivery with id '%v' is already pending", id)
+			}
+			d.Status = model.WebhookDeliveryStatusPending
+			d.Attempts = 0
+			d.NextAttemptAt = at
+			d.DeliveredAt = nil
+			return m.copyDelivery(d), nil
+		}
+	}
+	return nil, fmt.Errorf("webhook delivery with id '%v' does not exist", id)
+}
+
+func (m *MemoryRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]*model.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	claimed := []*model.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if d.Status == model.WebhookDeliveryStatusPending && !d.NextAttemptAt.After(now) {
+			d.NextAttemptAt = lease
+			claimed = append(claimed, m.copyDelivery(d))
+		}
+	}
+	return claimed, nil
+}
+
+func (m *MemoryRepository) SaveWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, lease time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.deliveries {
+		if d.ID == delivery.ID {
+			if d.Status != model.WebhookDeliveryStatusPending || !d.NextAttemptAt.Equal(lease) {
+				return fmt.Errorf("webhook delivery with id '%v' was claimed by another dispatcher", delivery.ID)
+			}
+			d.Status = delivery.Status
+			d.Attempts = delivery.Attempts
+			d.NextAttemptAt = delivery.NextAttemptAt
+			d.LastError = delivery.LastError
+			d.DeliveredAt = delivery.DeliveredAt
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook delivery with id '%v' does not exist", delivery.ID)
+}
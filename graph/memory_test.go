@@ -0,0 +1,425 @@
+package graph_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	_, _, c := SetupTest()
+	repo := graph.NewMemoryRepository()
+	repo.Populate()
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+
+	t.Run("find books filter by title and author_name", func(t *testing.T) {
+		type respType struct {
+			Books model.BookList
+		}
+		var resp respType
+		c.MustPost(`{
+         books(filter: {
+               title: {
+                  op: LIKE
+                  value: "%Harry Potter%"
+               }
+               author_name: {
+                  op: EQ
+                  value: "Lord Voldermort"
+               }
+            }
+         ) {
+            count
+            list {
+               id
+               title
+               authors {
+                  id
+                  name
+               }
+            }
+         }
+      }`, &resp, addContext(repo))
+
+		JsonMatch(t, &respType{
+			Books: model.BookList{
+				Count: 2,
+				List: []*model.Book{
+					{
+						ID:    3,
+						Title: "Harry Potter and the Book of Evil",
+						Authors: []*model.Author{
+							{ID: 2, Name: "Lord Voldermort"},
+						},
+					},
+					{
+						ID:    4,
+						Title: "Harry Potter and the Snake Dictionary",
+						Authors: []*model.Author{
+							{ID: 2, Name: "Lord Voldermort"},
+							{ID: 3, Name: "Salazar Slitherin"},
+						},
+					},
+				},
+			},
+		}, &resp)
+	})
+
+	t.Run("find books filter by author_name not and review.star", func(t *testing.T) {
+		type respType struct {
+			Books model.BookList
+		}
+		var resp respType
+		c.MustPost(`{
+         books(filter: {
+               author_name: {
+                  op: NOT_EQ
+                  value: "Lord Voldermort"
+               }
+               star: {
+                  min: 4
+               }
+            }
+         ) {
+            count
+            list {
+               id
+               title
+            }
+         }
+      }`, &resp, addContext(repo))
+
+		JsonMatch(t, &respType{
+			Books: model.BookList{
+				Count: 2,
+				List: []*model.Book{
+					{ID: 1, Title: "Harry Potter and the Sorcerer's Stone"},
+					{ID: 2, Title: "Harry Potter and the Chamber of Secrets"},
+				},
+			},
+		}, &resp)
+	})
+
+	t.Run("find book series limit 1", func(t *testing.T) {
+		type respType struct {
+			BookSeries model.BookSeriesList
+		}
+		var resp respType
+		c.MustPost(`{
+         bookSeries {
+            count
+            list {
+               id
+               title
+               books(offset: 1, limit: 1) {
+                  count
+                  list {
+                     id
+                     title
+                  }
+               }
+            }
+         }
+      }`, &resp, addContext(repo))
+
+		JsonMatch(t, &respType{
+			BookSeries: model.BookSeriesList{
+				Count: 1,
+				List: []*model.BookSeries{
+					{
+						ID:    1,
+						Title: "Harry Potter",
+						Books: &model.BookList{
+							List: []*model.Book{
+								{ID: 2, Title: "Harry Potter and the Chamber of Secrets"},
+							},
+							Count: 2,
+						},
+					},
+				},
+			},
+		}, &resp)
+	})
+
+	t.Run("create duplicate book", func(t *testing.T) {
+		type respType struct {
+			CreateBook model.Book
+		}
+		var resp respType
+		err := c.Post(`mutation {
+         createBook(input: {
+            title: "Harry Potter and the Sorcerer's Stone"
+            authors_name: ["J.K. Rowling"]
+         }) {
+            id
+         }
+      }`, &resp, addContext(repo))
+		assert.ErrorContains(t, err, `duplicate key books.title \"Harry Potter and the Sorcerer's Stone\"`)
+	})
+
+	t.Run("update book conflict", func(t *testing.T) {
+		type respType struct {
+			UpdateBook model.Book
+		}
+		var resp respType
+		c.MustPost(`mutation {
+         updateBook(input: {
+            id: 4
+            expectedVersion: 1
+            title: "Harry Potter and the Fake Book"
+            authors_name: ["Salazar Slitherin", "Albus Dumbledore"]
+         }) {
+            id
+            title
+            version
+         }
+      }`, &resp, addContext(repo))
+		JsonMatch(t, &respType{
+			UpdateBook: model.Book{ID: 4, Title: "Harry Potter and the Fake Book", Version: 2},
+		}, &resp)
+
+		err := c.Post(`mutation {
+         updateBook(input: {
+            id: 4
+            expectedVersion: 1
+            title: "Harry Potter and the Stale Book"
+         }) {
+            id
+         }
+      }`, &resp, addContext(repo))
+		assert.ErrorContains(t, err, `book with id '4' has version 2, expected 1`)
+		assert.ErrorContains(t, err, `"code":"CONFLICT"`)
+		assert.ErrorContains(t, err, `"name":"Albus Dumbledore"`)
+	})
+
+	t.Run("delete and restore book", func(t *testing.T) {
+		type respType struct {
+			DeleteBook  model.Book
+			RestoreBook model.Book
+			Books       model.BookList
+		}
+		var resp respType
+		c.MustPost(`mutation { deleteBook(id: 4) { id } }`, &resp, addContext(repo))
+		c.MustPost(`{ books { count } }`, &resp, addContext(repo))
+		assert.Equal(t, 3, resp.Books.Count)
+
+		err := c.Post(`mutation { deleteBook(id: 4) { id } }`, &resp, addContext(repo))
+		assert.ErrorContains(t, err, `book with id '4' does not exist`)
+
+		book, err := repo.ReviewBook(graph.WithTenant(context.TODO(), graph.DefaultTenant), &model.Review{BookID: 4})
+		assert.NoError(t, err)
+		assert.Nil(t, book)
+		book, err = repo.ReviewBook(graph.WithRoles(graph.WithTenant(context.TODO(), graph.DefaultTenant), []string{graph.RoleAdmin}), &model.Review{BookID: 4})
+		if assert.NoError(t, err) && assert.NotNil(t, book) {
+			assert.NotNil(t, book.DeletedAt)
+		}
+
+		var series struct {
+			BookSeries struct {
+				List []struct{ Books model.BookList }
+			}
+		}
+		err = c.Post(`{ bookSeries { list { books(includeDeleted: true) { count } } } }`, &series, addContext(repo))
+		assert.ErrorContains(t, err, "includeDeleted requires role 'admin'")
+		c.MustPost(`{ bookSeries { list { books(includeDeleted: true) { count } } } }`, &series, addContext(repo), addRoles(graph.RoleAdmin))
+
+		c.MustPost(`mutation { restoreBook(id: 4) { id version } }`, &resp, addContext(repo), addRoles(graph.RoleAdmin))
+		assert.Equal(t, 4, resp.RestoreBook.Version)
+		c.MustPost(`{ books { count } }`, &resp, addContext(repo))
+		assert.Equal(t, 4, resp.Books.Count)
+
+		err = c.Post(`mutation { updateBook(input: {id: 4, expectedVersion: 2, title: "Harry Potter and the Stale Book"}) { id } }`, &resp, addContext(repo))
+		assert.ErrorContains(t, err, `book with id '4' has version 4, expected 2`)
+	})
+
+	t.Run("book history and as of", func(t *testing.T) {
+		type AuditEntry struct {
+			Action    string
+			Operation string
+		}
+		type Book struct {
+			ID      int
+			Title   string
+			History []AuditEntry
+		}
+		type respType struct {
+			Books struct {
+				List []Book
+			}
+		}
+		var resp respType
+		c.MustPost(`{
+         books(filter: {id: 4}) {
+            list {
+               id
+               title
+               history {
+                  action
+                  operation
+               }
+            }
+         }
+      }`, &resp, addContext(repo))
+		JsonMatch(t, []Book{
+			{
+				ID:    4,
+				Title: "Harry Potter and the Fake Book",
+				History: []AuditEntry{
+					{Action: "UPDATE", Operation: "updateBook"},
+					{Action: "DELETE", Operation: "deleteBook"},
+					{Action: "UPDATE", Operation: "restoreBook"},
+				},
+			},
+		}, resp.Books.List)
+
+		book, err := repo.BookAsOf(ctx, 4, time.Now())
+		if assert.NoError(t, err) && assert.NotNil(t, book) {
+			assert.Equal(t, "Harry Potter and the Fake Book", book.Title)
+			assert.Nil(t, book.DeletedAt)
+			assert.Len(t, book.Authors, 2)
+		}
+	})
+
+	t.Run("purge deleted books", func(t *testing.T) {
+		type respType struct {
+			DeleteBook   model.Book
+			PurgeDeleted int
+		}
+		var resp respType
+		c.MustPost(`mutation { deleteBook(id: 1) { id } }`, &resp, addContext(repo))
+		c.MustPost(`mutation { purgeDeleted(olderThan: "2100-01-01T00:00:00Z") }`, &resp, addContext(repo), addRoles(graph.RoleAdmin))
+		assert.Equal(t, 1, resp.PurgeDeleted)
+
+		book, err := repo.BookAsOf(ctx, 1, time.Now())
+		assert.NoError(t, err)
+		assert.Nil(t, book)
+	})
+
+	t.Run("review ownership", func(t *testing.T) {
+		ron, err := repo.CreateUser(ctx, model.NewUser{Name: "ron"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		hermione, _ := repo.CreateUser(ctx, model.NewUser{Name: "hermione"})
+		_, err = repo.CreateReview(ctx, model.NewReview{BookID: 2, Star: 3, Text: "anonymous"})
+		assert.ErrorContains(t, err, "creating a review requires a user")
+
+		review, err := repo.CreateReview(graph.WithUser(ctx, ron), model.NewReview{BookID: 2, Star: 3, Text: "spiders"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = repo.CreateReview(graph.WithUser(ctx, ron), model.NewReview{BookID: 2, Star: 1, Text: "again"})
+		assert.ErrorContains(t, err, "user 'ron' already reviewed book with id '2'")
+
+		update := model.UpdateReview{ID: review.ID, ExpectedVersion: 1, Star: graph.Of(4)}
+		_, err = repo.UpdateReview(graph.WithUser(ctx, hermione), update)
+		assert.ErrorContains(t, err, "belongs to another user")
+		updated, err := repo.UpdateReview(graph.WithUser(ctx, ron), update)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 4, updated.Star)
+			assert.Equal(t, 2, updated.Version)
+		}
+
+		_, err = repo.DeleteReview(graph.WithUser(ctx, hermione), review.ID)
+		assert.ErrorContains(t, err, "belongs to another user")
+		_, err = repo.DeleteReview(graph.WithRoles(ctx, []string{graph.RoleModerator}), review.ID)
+		assert.NoError(t, err)
+		_, err = repo.DeleteReview(graph.WithRoles(ctx, []string{graph.RoleModerator}), review.ID)
+		assert.ErrorContains(t, err, "does not exist")
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		cfg := graph.DefaultConfig()
+		cfg.Argon2_Memory = 1024
+		apiKey, key, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: "batch", Scopes: []string{"reader"}}, time.Now())
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, repo.CreateAPIKey(ctx, apiKey))
+		auth := &graph.APIKeyAuth{}
+		found, err := auth.Authenticate(ctx, repo, key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, apiKey.ID, found.ID)
+			assert.NotNil(t, found.LastUsedAt)
+		}
+		_, err = repo.RevokeAPIKey(ctx, apiKey.ID, time.Now())
+		assert.NoError(t, err)
+		_, err = auth.Authenticate(ctx, repo, key)
+		assert.ErrorContains(t, err, "API key has been revoked")
+		keys, err := repo.APIKeys(ctx, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("import and export books", func(t *testing.T) {
+		res, err := repo.ImportBooks(ctx, strings.NewReader(`title,series,authors,reviews
+Fantastic Beasts,Wizarding World,J.K. Rowling;Newt Scamander,4:Magical
+Harry Potter and the Chamber of Secrets,,J.K. Rowling,
+`), model.ImportFormatCSV, 100)
+		if assert.NoError(t, err) {
+			JsonMatch(t, &model.ImportResult{
+				Rows:     2,
+				Imported: 1,
+				Errors: []*model.ImportError{
+					{Row: 2, Message: `duplicate key books.title "Harry Potter and the Chamber of Secrets"`},
+				},
+			}, res)
+		}
+
+		var buf bytes.Buffer
+		count, err := repo.ExportBooks(ctx, &buf, graph.ExportFormatJSONL)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, count)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var row graph.ExportRow
+		if assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &row)) {
+			assert.Equal(t, "Fantastic Beasts", row.Title)
+			assert.Equal(t, graph.Of("Wizarding World"), row.Series)
+			assert.Equal(t, []string{"J.K. Rowling", "Newt Scamander"}, row.Authors)
+			assert.Equal(t, 1, row.ReviewCount)
+			assert.Equal(t, graph.Of(4.0), row.Rating)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		acme := graph.WithTenant(context.TODO(), "acme")
+		_, err := repo.CreateAuthor(acme, model.NewAuthor{Name: "J.K. Rowling"})
+		assert.NoError(t, err)
+		book, err := repo.CreateBook(acme, model.NewBook{Title: "Harry Potter and the Book of Evil", AuthorsName: []string{"J.K. Rowling"}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = repo.CreateBook(acme, model.NewBook{Title: "Harry Potter and the Book of Evil", AuthorsName: []string{"J.K. Rowling"}})
+		assert.ErrorContains(t, err, "duplicate key books.title")
+
+		books, err := repo.Books(acme, nil, nil, nil, nil)
+		if assert.NoError(t, err) && assert.Len(t, books.List, 1) {
+			assert.Equal(t, book.ID, books.List[0].ID)
+		}
+		books, err = repo.Books(graph.WithTenant(context.TODO(), graph.DefaultTenant), nil, nil, &model.BookFilter{ID: &book.ID}, nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, books.Count)
+		}
+		_, err = repo.DeleteBook(acme, 2)
+		assert.ErrorContains(t, err, "book with id '2' does not exist")
+
+		// like TenantPlugin, a context without a tenant sees nothing and creates nothing
+		books, err = repo.Books(context.TODO(), nil, nil, nil, nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, books.Count)
+		}
+		_, err = repo.CreateAuthor(context.TODO(), model.NewAuthor{Name: "Newt Scamander"})
+		assert.ErrorContains(t, err, "statement on authors without a tenant")
+		books, err = repo.Books(graph.AnyTenant(context.TODO()), nil, nil, &model.BookFilter{ID: &book.ID}, nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, books.Count)
+		}
+	})
+}
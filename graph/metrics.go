@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+var (
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of GraphQL operations by operation name, type and status.",
+	}, []string{"operation", "type", "status"})
+	resolverDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "resolver_duration_seconds",
+		Help:      "Duration of GraphQL field resolvers by object, field and status.",
+	}, []string{"object", "field", "status"})
+	batchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "batch_keys",
+		Help:      "Number of keys dispatched by a batch loader call.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+	})
+	batchGroups = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "batch_groups",
+		Help:      "Number of queries a batch loader call was split into.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 8),
+	})
+	batchWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "batch_wait_seconds",
+		Help:      "Time between the first key of a batch being queued and the batch being dispatched.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 10),
+	})
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gographql",
+		Name:      "sql_duration_seconds",
+		Help:      "Duration of SQL statements issued through gorm by table, statement kind and status.",
+	}, []string{"table", "kind", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(operationDuration, resolverDuration, batchSize, batchGroups, batchWait, queryDuration)
+}
+
+func statusOf(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// MetricsHandler serves every collector registered with the default prometheus registry.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDBStats exposes the connection pool gauges of db.
+func RegisterDBStats(db *gorm.DB, name string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return prometheus.Register(collectors.NewDBStatsCollector(sqlDB, name))
+}
+
+func observeBatch(keys int, groups int, wait time.Duration) {
+	batchSize.Observe(float64(keys))
+	batchGroups.Observe(float64(groups))
+	batchWait.Observe(wait.Seconds())
+}
+
+// Metrics is a gqlgen extension timing every operation and every field backed by a resolver,
+// plain struct fields are left out as they would only add noise.
+type Metrics struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = Metrics{}
+
+func (Metrics) ExtensionName() string {
+	return "Metrics"
+}
+
+func (Metrics) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (Metrics) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	start := time.Now()
+	resp := next(ctx)
+	name, kind := oc.OperationName, ""
+	if oc.Operation != nil {
+		kind = string(oc.Operation.Operation)
+		if name == "" {
+			name = oc.Operation.Name
+		}
+	}
+	if name == "" {
+		name = "anonymous"
+	}
+	var err error
+	if resp == nil || len(resp.Errors) > 0 {
+		err = errors.New("graphql error")
+	}
+	operationDuration.WithLabelValues(name, kind, statusOf(err)).Observe(time.Since(start).Seconds())
+	return resp
+}
+
+func (Metrics) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+	start := time.Now()
+	res, err := next(ctx)
+	resolverDuration.WithLabelValues(fc.Object, fc.Field.Name, statusOf(err)).Observe(time.Since(start).Seconds())
+	return res, err
+}
+
+// MetricsPlugin times every statement gorm runs. Dry runs, used to build batch keys, never reach
+// the database and are skipped; record not found is a normal outcome of First, not an error.
+type MetricsPlugin struct{}
+
+const metricsStartKey = "metrics:start"
+
+func (MetricsPlugin) Name() string {
+	return "metrics"
+}
+
+func (MetricsPlugin) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		if db.DryRun {
+			return
+		}
+		db.InstanceSet(metricsStartKey, time.Now())
+	}
+	after := func(kind string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			v, ok := db.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			err := db.Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = nil
+			}
+			table := db.Statement.Table
+			if table == "" {
+				table = "unknown"
+			}
+			queryDuration.WithLabelValues(table, kind, statusOf(err)).Observe(time.Since(v.(time.Time)).Seconds())
+		}
+	}
+	return registerAround(db, "metrics", before, after)
+}
+
+// registerAround hooks before and after every gorm statement kind, after receives the kind so a
+// plugin can label what it observed.
+func registerAround(db *gorm.DB, plugin string, before func(*gorm.DB), after func(kind string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register(plugin+":before_create", before),
+		cb.Create().After("gorm:create").Register(plugin+":after_create", after("create")),
+		cb.Query().Before("gorm:query").Register(plugin+":before_query", before),
+		cb.Query().After("gorm:query").Register(plugin+":after_query", after("query")),
+		cb.Update().Before("gorm:update").Register(plugin+":before_update", before),
+		cb.Update().After("gorm:update").Register(plugin+":after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register(plugin+":before_delete", before),
+		cb.Delete().After("gorm:delete").Register(plugin+":after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register(plugin+":before_row", before),
+		cb.Row().After("gorm:row").Register(plugin+":after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register(plugin+":before_raw", before),
+		cb.Raw().After("gorm:raw").Register(plugin+":after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package graph_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	db := SetupSQLite(t)
+	if err := db.Use(graph.MetricsPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.Metrics{})
+	c := client.New(resolver.Middleware(srv))
+
+	var resp struct {
+		Books model.BookList
+	}
+	c.MustPost(`query BooksWithAuthors {
+      books {
+         count
+         list {
+            id
+            authors {
+               name
+            }
+         }
+      }
+   }`, &resp)
+	assert.Equal(t, 4, resp.Books.Count)
+
+	rec := httptest.NewRecorder()
+	graph.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `gographql_operation_duration_seconds_count{operation="BooksWithAuthors",status="ok",type="query"} 1`)
+	assert.Contains(t, body, `gographql_resolver_duration_seconds_count{field="books",object="Query",status="ok"} 1`)
+	assert.Contains(t, body, `gographql_resolver_duration_seconds_count{field="authors",object="Book",status="ok"} 4`)
+	assert.Contains(t, body, `gographql_batch_keys_count`)
+	assert.Contains(t, body, `gographql_batch_wait_seconds_count`)
+	assert.Contains(t, body, `gographql_sql_duration_seconds_count{kind="query",status="ok",table="books"}`)
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const cursorPrefix = "change:"
+
+// Cursor is a position in the change stream, the id of the last change event passed. Clients get
+// it as an opaque string.
+type Cursor int
+
+func ParseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || !strings.HasPrefix(string(b), cursorPrefix) {
+		return 0, fmt.Errorf("invalid cursor '%s'", s)
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(b), cursorPrefix))
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid cursor '%s'", s)
+	}
+	return Cursor(id), nil
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(int(c))))
+}
+
+func (c Cursor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Cursor) UnmarshalText(text []byte) error {
+	v, err := ParseCursor(string(text))
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+func (c Cursor) MarshalGQL(w io.Writer) {
+	io.WriteString(w, strconv.Quote(c.String()))
+}
+
+func (c *Cursor) UnmarshalGQL(v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("cursor must be a string")
+	}
+	return c.UnmarshalText([]byte(s))
+}
@@ -6,11 +6,45 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
-type Author struct {
+type APIKey struct {
 	ID   int    `json:"id" gorm:"primaryKey"`
-	Name string `json:"name" gorm:"unique"`
+	Name string `json:"name"`
+	// first characters of the key, shown so a key can be recognized without revealing it
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex;size:16"`
+	Hash       string     `json:"-" gorm:"not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	CreatedBy  *string    `json:"createdBy"`
+	// the user the key acts for, keys without a user can not write reviews
+	User     *User  `json:"user"`
+	UserID   *int   `json:"-"`
+	TenantID string `json:"-" gorm:"size:64;not null;default:'default';index"`
+}
+
+type AuditEntry struct {
+	ID        int         `json:"id" gorm:"primaryKey"`
+	Entity    string      `json:"entity" gorm:"index:idx_audit_entries_entity"`
+	EntityID  int         `json:"entityId" gorm:"index:idx_audit_entries_entity"`
+	Action    AuditAction `json:"action"`
+	Operation string      `json:"operation"`
+	Actor     *string     `json:"actor"`
+	Before    *string     `json:"before" gorm:"type:text"`
+	After     *string     `json:"after" gorm:"type:text"`
+	At        time.Time   `json:"at" gorm:"index"`
+	TenantID  string      `json:"-" gorm:"size:64;not null;default:'default';index"`
+}
+
+type Author struct {
+	ID       int    `json:"id" gorm:"primaryKey"`
+	Name     string `json:"name" gorm:"uniqueIndex:idx_authors_name"`
+	Version  int    `json:"version" gorm:"not null;default:1"`
+	TenantID string `json:"-" gorm:"size:64;not null;default:'default';index;uniqueIndex:idx_authors_name,priority:1"`
 }
 
 type AuthorFilter struct {
@@ -24,12 +58,16 @@ type AuthorList struct {
 }
 
 type Book struct {
-	ID       int         `json:"id" gorm:"primaryKey"`
-	Title    string      `json:"title" gorm:"unique"`
-	Series   *BookSeries `json:"series"`
-	SeriesID *int        `json:"-"`
-	Authors  []*Author   `json:"authors" gorm:"many2many:book_authors;constraint:OnDelete:CASCADE"`
-	Reviews  []*Review   `json:"reviews" gorm:"constraint:OnDelete:CASCADE"`
+	ID        int           `json:"id" gorm:"primaryKey"`
+	Title     string        `json:"title" gorm:"uniqueIndex:idx_books_title"`
+	Series    *BookSeries   `json:"series"`
+	SeriesID  *int          `json:"-"`
+	Authors   []*Author     `json:"authors" gorm:"many2many:book_authors;constraint:OnDelete:CASCADE"`
+	Reviews   []*Review     `json:"reviews" gorm:"constraint:OnDelete:CASCADE"`
+	DeletedAt *time.Time    `json:"deletedAt" gorm:"index"`
+	Version   int           `json:"version" gorm:"not null;default:1"`
+	History   []*AuditEntry `json:"history" gorm:"-"`
+	TenantID  string        `json:"-" gorm:"size:64;not null;default:'default';index;uniqueIndex:idx_books_title,priority:1"`
 }
 
 type BookFilter struct {
@@ -45,9 +83,11 @@ type BookList struct {
 }
 
 type BookSeries struct {
-	ID    int       `json:"id" gorm:"primaryKey"`
-	Title string    `json:"title" gorm:"unique"`
-	Books *BookList `json:"books" gorm:"-"`
+	ID       int       `json:"id" gorm:"primaryKey"`
+	Title    string    `json:"title" gorm:"uniqueIndex:idx_book_series_title"`
+	Books    *BookList `json:"books" gorm:"-"`
+	Version  int       `json:"version" gorm:"not null;default:1"`
+	TenantID string    `json:"-" gorm:"size:64;not null;default:'default';index;uniqueIndex:idx_book_series_title,priority:1"`
 }
 
 type BookSeriesFilter struct {
@@ -60,6 +100,26 @@ type BookSeriesList struct {
 	Count int           `json:"count"`
 }
 
+type ChangeEvent struct {
+	ID        int          `json:"id" gorm:"primaryKey"`
+	Entity    ChangeEntity `json:"entity"`
+	EntityID  int          `json:"entityId"`
+	Action    AuditAction  `json:"action"`
+	Operation string       `json:"operation"`
+	Actor     *string      `json:"actor"`
+	// the entity after the change, before it when it was deleted for good
+	Data     *string   `json:"data" gorm:"type:text"`
+	At       time.Time `json:"at"`
+	TenantID string    `json:"-" gorm:"size:64;not null;default:'default';index"`
+}
+
+type ChangeFeed struct {
+	Events []*ChangeEvent `json:"events"`
+	// pass as since to continue after the events
+	Cursor  Cursor `json:"cursor"`
+	HasMore bool   `json:"hasMore"`
+}
+
 type FilterIntRange struct {
 	Min *int `json:"min"`
 	Max *int `json:"max"`
@@ -70,6 +130,30 @@ type FilterText struct {
 	Value string       `json:"value"`
 }
 
+type ImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type ImportResult struct {
+	Rows     int            `json:"rows"`
+	Imported int            `json:"imported"`
+	Errors   []*ImportError `json:"errors"`
+}
+
+type NewAPIKey struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	UserID    *int       `json:"userId"`
+}
+
+type NewAPIKeyResult struct {
+	APIKey *APIKey `json:"apiKey"`
+	// the key itself, it is only returned once
+	Key string `json:"key"`
+}
+
 type NewAuthor struct {
 	Name string `json:"name"`
 }
@@ -86,12 +170,33 @@ type NewReview struct {
 	Text   string `json:"text"`
 }
 
+type NewUser struct {
+	Name  string  `json:"name"`
+	Email *string `json:"email"`
+}
+
+type NewWebhook struct {
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+}
+
+type NewWebhookResult struct {
+	Webhook *Webhook `json:"webhook"`
+	// the key of the HMAC signature of every delivery, it is only returned once
+	Secret string `json:"secret"`
+}
+
 type Review struct {
 	ID     int    `json:"id" gorm:"primaryKey"`
 	Star   int    `json:"star"`
-	Text   string `json:"text"`
+	Text   string `json:"text" gorm:"type:text"`
 	Book   *Book  `json:"book"`
-	BookID int    `json:"-"`
+	BookID int    `json:"-" gorm:"uniqueIndex:idx_reviews_book_user"`
+	// the user who wrote the review, null for reviews written before reviews were linked to users
+	Author   *User  `json:"author" gorm:"foreignKey:UserID"`
+	UserID   *int   `json:"-" gorm:"uniqueIndex:idx_reviews_book_user"`
+	Version  int    `json:"version" gorm:"not null;default:1"`
+	TenantID string `json:"-" gorm:"size:64;not null;default:'default';index;uniqueIndex:idx_reviews_book_user,priority:1"`
 }
 
 type ReviewFilter struct {
@@ -99,9 +204,140 @@ type ReviewFilter struct {
 }
 
 type UpdateBook struct {
-	ID          int      `json:"id"`
-	Title       *string  `json:"title"`
-	AuthorsName []string `json:"authors_name"`
+	ID              int      `json:"id"`
+	ExpectedVersion int      `json:"expectedVersion"`
+	Title           *string  `json:"title"`
+	AuthorsName     []string `json:"authors_name"`
+}
+
+type UpdateReview struct {
+	ID              int     `json:"id"`
+	ExpectedVersion int     `json:"expectedVersion"`
+	Star            *int    `json:"star"`
+	Text            *string `json:"text"`
+}
+
+type User struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_users_name"`
+	Email     *string   `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+	TenantID  string    `json:"-" gorm:"size:64;not null;default:'default';index;uniqueIndex:idx_users_name,priority:1"`
+}
+
+type Webhook struct {
+	ID  int    `json:"id" gorm:"primaryKey"`
+	URL string `json:"url"`
+	// the events posted to the url, every event when empty
+	Events    []WebhookEvent `json:"events" gorm:"serializer:json"`
+	Secret    string         `json:"-" gorm:"not null"`
+	CreatedAt time.Time      `json:"createdAt"`
+	CreatedBy *string        `json:"createdBy"`
+	TenantID  string         `json:"-" gorm:"size:64;not null;default:'default';index"`
+}
+
+// an event waiting in the outbox for, or already posted to, a webhook
+type WebhookDelivery struct {
+	ID            int                   `json:"id" gorm:"primaryKey"`
+	Webhook       *Webhook              `json:"webhook"`
+	WebhookID     int                   `json:"-" gorm:"index;not null"`
+	Event         WebhookEvent          `json:"event"`
+	Payload       string                `json:"payload" gorm:"type:text"`
+	Status        WebhookDeliveryStatus `json:"status" gorm:"index:idx_webhook_deliveries_due"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt time.Time             `json:"nextAttemptAt" gorm:"index:idx_webhook_deliveries_due"`
+	LastError     *string               `json:"lastError" gorm:"type:text"`
+	DeliveredAt   *time.Time            `json:"deliveredAt"`
+	CreatedAt     time.Time             `json:"createdAt"`
+	TenantID      string                `json:"-" gorm:"size:64;not null;default:'default';index"`
+}
+
+type AuditAction string
+
+const (
+	AuditActionInsert AuditAction = "INSERT"
+	AuditActionUpdate AuditAction = "UPDATE"
+	AuditActionDelete AuditAction = "DELETE"
+)
+
+var AllAuditAction = []AuditAction{
+	AuditActionInsert,
+	AuditActionUpdate,
+	AuditActionDelete,
+}
+
+func (e AuditAction) IsValid() bool {
+	switch e {
+	case AuditActionInsert, AuditActionUpdate, AuditActionDelete:
+		return true
+	}
+	return false
+}
+
+func (e AuditAction) String() string {
+	return string(e)
+}
+
+func (e *AuditAction) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AuditAction(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AuditAction", str)
+	}
+	return nil
+}
+
+func (e AuditAction) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ChangeEntity string
+
+const (
+	ChangeEntityAuthor     ChangeEntity = "AUTHOR"
+	ChangeEntityBook       ChangeEntity = "BOOK"
+	ChangeEntityBookSeries ChangeEntity = "BOOK_SERIES"
+	ChangeEntityReview     ChangeEntity = "REVIEW"
+)
+
+var AllChangeEntity = []ChangeEntity{
+	ChangeEntityAuthor,
+	ChangeEntityBook,
+	ChangeEntityBookSeries,
+	ChangeEntityReview,
+}
+
+func (e ChangeEntity) IsValid() bool {
+	switch e {
+	case ChangeEntityAuthor, ChangeEntityBook, ChangeEntityBookSeries, ChangeEntityReview:
+		return true
+	}
+	return false
+}
+
+func (e ChangeEntity) String() string {
+	return string(e)
+}
+
+func (e *ChangeEntity) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ChangeEntity(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ChangeEntity", str)
+	}
+	return nil
+}
+
+func (e ChangeEntity) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
 
 type FilterTextOp string
@@ -148,3 +384,176 @@ func (e *FilterTextOp) UnmarshalGQL(v interface{}) error {
 func (e FilterTextOp) MarshalGQL(w io.Writer) {
 	fmt.Fprint(w, strconv.Quote(e.String()))
 }
+
+type ImportFormat string
+
+const (
+	ImportFormatCSV  ImportFormat = "CSV"
+	ImportFormatJSON ImportFormat = "JSON"
+)
+
+var AllImportFormat = []ImportFormat{
+	ImportFormatCSV,
+	ImportFormatJSON,
+}
+
+func (e ImportFormat) IsValid() bool {
+	switch e {
+	case ImportFormatCSV, ImportFormatJSON:
+		return true
+	}
+	return false
+}
+
+func (e ImportFormat) String() string {
+	return string(e)
+}
+
+func (e *ImportFormat) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ImportFormat(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ImportFormat", str)
+	}
+	return nil
+}
+
+func (e ImportFormat) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// roles granted by the scopes of an API key
+type Role string
+
+const (
+	RoleAdmin     Role = "ADMIN"
+	RoleEditor    Role = "EDITOR"
+	RoleModerator Role = "MODERATOR"
+)
+
+var AllRole = []Role{
+	RoleAdmin,
+	RoleEditor,
+	RoleModerator,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleAdmin, RoleEditor, RoleModerator:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "DELIVERED"
+	// gave up after the last attempt failed
+	WebhookDeliveryStatusDead WebhookDeliveryStatus = "DEAD"
+)
+
+var AllWebhookDeliveryStatus = []WebhookDeliveryStatus{
+	WebhookDeliveryStatusPending,
+	WebhookDeliveryStatusDelivered,
+	WebhookDeliveryStatusDead,
+}
+
+func (e WebhookDeliveryStatus) IsValid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending, WebhookDeliveryStatusDelivered, WebhookDeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (e WebhookDeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookDeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookDeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e WebhookDeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// the book changes a webhook can subscribe to
+type WebhookEvent string
+
+const (
+	WebhookEventBookCreated WebhookEvent = "BOOK_CREATED"
+	WebhookEventBookUpdated WebhookEvent = "BOOK_UPDATED"
+	WebhookEventBookDeleted WebhookEvent = "BOOK_DELETED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventBookCreated,
+	WebhookEventBookUpdated,
+	WebhookEventBookDeleted,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventBookCreated, WebhookEventBookUpdated, WebhookEventBookDeleted:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
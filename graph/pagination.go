@@ -0,0 +1,157 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const ErrorCode_PageLimit = "PAGE_LIMIT"
+
+// PageLimit is a gqlgen extension rejecting operations passing a negative limit or offset, or one
+// above the maximum, to a paginated field before anything is resolved. The @page annotation of a
+// field overrides MaxLimit and MaxOffset, zero disables a maximum.
+type PageLimit struct {
+	MaxLimit  int
+	MaxOffset int
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = PageLimit{}
+
+func (PageLimit) ExtensionName() string {
+	return "PageLimit"
+}
+
+func (PageLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e PageLimit) MutateOperationContext(ctx context.Context, oc *graphql.OperationContext) *gqlerror.Error {
+	if oc.Operation == nil {
+		return nil
+	}
+	return e.checkSelection(oc.Operation.SelectionSet, oc.Doc.Fragments, oc.Variables)
+}
+
+func (e PageLimit) checkSelection(set ast.SelectionSet, fragments ast.FragmentDefinitionList, vars map[string]interface{}) *gqlerror.Error {
+	for _, sel := range set {
+		var err *gqlerror.Error
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if err = e.checkField(sel, vars); err == nil {
+				err = e.checkSelection(sel.SelectionSet, fragments, vars)
+			}
+		case *ast.InlineFragment:
+			err = e.checkSelection(sel.SelectionSet, fragments, vars)
+		case *ast.FragmentSpread:
+			if f := fragments.ForName(sel.Name); f != nil {
+				err = e.checkSelection(f.SelectionSet, fragments, vars)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageMax is the maximum limit and offset of def, its @page annotation overriding maxLimit and
+// maxOffset.
+func pageMax(def *ast.FieldDefinition, maxLimit int, maxOffset int, vars map[string]interface{}) (int, int) {
+	if d := def.Directives.ForName("page"); d != nil {
+		args := d.ArgumentMap(vars)
+		if v, ok := intArg(args["maxLimit"]); ok {
+			maxLimit = v
+		}
+		if v, ok := intArg(args["maxOffset"]); ok {
+			maxOffset = v
+		}
+	}
+	return maxLimit, maxOffset
+}
+
+func (e PageLimit) checkField(field *ast.Field, vars map[string]interface{}) *gqlerror.Error {
+	if field.Definition == nil {
+		return nil
+	}
+	maxLimit, maxOffset := pageMax(field.Definition, e.MaxLimit, e.MaxOffset, vars)
+	args := field.ArgumentMap(vars)
+	for _, arg := range []struct {
+		name string
+		max  int
+	}{{"limit", maxLimit}, {"offset", maxOffset}} {
+		if field.Definition.Arguments.ForName(arg.name) == nil || args[arg.name] == nil {
+			continue
+		}
+		name := field.Name
+		if field.ObjectDefinition != nil {
+			name = field.ObjectDefinition.Name + "." + field.Name
+		}
+		var message string
+		v, ok := intArg(args[arg.name])
+		switch {
+		case !ok:
+			message = fmt.Sprintf("%s of %s must be an integer, got %#v", arg.name, name, args[arg.name])
+		case v < 0:
+			message = fmt.Sprintf("%s of %s must not be negative, got %d", arg.name, name, v)
+		case arg.max > 0 && v > arg.max:
+			message = fmt.Sprintf("%s of %s is %d, exceeding the maximum of %d", arg.name, name, v, arg.max)
+		default:
+			continue
+		}
+		return &gqlerror.Error{
+			Message: message,
+			Extensions: map[string]interface{}{
+				"code":     ErrorCode_PageLimit,
+				"field":    name,
+				"argument": arg.name,
+				"maximum":  arg.max,
+			},
+		}
+	}
+	return nil
+}
+
+// pageArgs replaces an offset or limit passed as an explicit null with the default of the field,
+// and clamps them to the page maximum of the field. A null skips the schema default, and the
+// repositories read a missing limit as no limit at all. PageLimit rejects the operation before it
+// gets here, the clamp keeps the maximum when the extension is not installed.
+func (r *Resolver) pageArgs(ctx context.Context, offset *int, limit *int) (*int, *int) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Field.Definition == nil {
+		return offset, limit
+	}
+	maxLimit, maxOffset := 0, 0
+	if r.Config != nil {
+		maxLimit, maxOffset = int(r.Config.MaxPageLimit), int(r.Config.MaxPageOffset)
+	}
+	var vars map[string]interface{}
+	if graphql.HasOperationContext(ctx) {
+		vars = graphql.GetOperationContext(ctx).Variables
+	}
+	maxLimit, maxOffset = pageMax(fc.Field.Definition, maxLimit, maxOffset, vars)
+	return pageArg(fc.Field.Definition, "offset", offset, maxOffset), pageArg(fc.Field.Definition, "limit", limit, maxLimit)
+}
+
+func pageArg(def *ast.FieldDefinition, name string, v *int, max int) *int {
+	if v == nil {
+		if n, ok := argDefault(def, name); ok {
+			v = &n
+		} else {
+			return nil
+		}
+	}
+	switch {
+	case *v < 0:
+		return Of(0)
+	case max > 0 && *v > max:
+		return Of(max)
+	}
+	return v
+}
@@ -0,0 +1,156 @@
+package graph_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLimit(t *testing.T) {
+	db := SetupSQLite(t)
+
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.PageLimit{MaxLimit: 100, MaxOffset: 1000})
+	c := client.New(resolver.Middleware(srv))
+
+	t.Run("limit above maximum", func(t *testing.T) {
+		resp, err := c.RawPost(`{ books(limit: 1000000) { count } }`)
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `limit of Query.books is 1000000, exceeding the maximum of 100`)
+			assert.Contains(t, string(resp.Errors), `"code":"PAGE_LIMIT"`)
+		}
+	})
+
+	t.Run("offset above maximum from variable", func(t *testing.T) {
+		resp, err := c.RawPost(`query Authors($offset: Int) { authors(offset: $offset) { count } }`, client.Var("offset", 5000))
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `offset of Query.authors is 5000, exceeding the maximum of 1000`)
+		}
+	})
+
+	t.Run("negative limit", func(t *testing.T) {
+		resp, err := c.RawPost(`{ bookSeries(limit: -1) { count } }`)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(resp.Errors), `limit of Query.bookSeries must not be negative, got -1`)
+		}
+	})
+
+	t.Run("schema override on nested list", func(t *testing.T) {
+		resp, err := c.RawPost(`{
+         books {
+            list {
+               ...reviews
+            }
+         }
+      }
+      fragment reviews on Book {
+         reviews(limit: 80) {
+            star
+         }
+      }`)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(resp.Errors), `limit of Book.reviews is 80, exceeding the maximum of 50`)
+		}
+	})
+
+	t.Run("reviews are paged per book", func(t *testing.T) {
+		type Review struct {
+			ID int
+		}
+		type Book struct {
+			ID      int
+			Reviews []Review
+		}
+		var resp struct {
+			Books struct {
+				List []Book
+			}
+		}
+		c.MustPost(`{
+         books {
+            list {
+               id
+               reviews(limit: 1) {
+                  id
+               }
+            }
+         }
+      }`, &resp)
+		JsonMatch(t, []Book{
+			{ID: 1, Reviews: []Review{{ID: 1}}},
+			{ID: 2, Reviews: []Review{{ID: 2}}},
+			{ID: 3, Reviews: []Review{{ID: 3}}},
+			{ID: 4, Reviews: []Review{}},
+		}, resp.Books.List)
+
+		c.MustPost(`{
+         books {
+            list {
+               id
+               reviews(offset: 1) {
+                  id
+               }
+            }
+         }
+      }`, &resp)
+		JsonMatch(t, []Book{
+			{ID: 1, Reviews: []Review{{ID: 4}}},
+			{ID: 2, Reviews: []Review{}},
+			{ID: 3, Reviews: []Review{}},
+			{ID: 4, Reviews: []Review{}},
+		}, resp.Books.List)
+	})
+
+	t.Run("null limit", func(t *testing.T) {
+		for i := 0; i < 12; i++ {
+			if result := db.WithContext(graph.WithTenant(context.TODO(), graph.DefaultTenant)).Create(&model.Author{Name: fmt.Sprintf("Author %02d", i)}); result.Error != nil {
+				t.Fatal(result.Error)
+			}
+		}
+		var resp struct {
+			Authors struct {
+				List  []struct{ ID int }
+				Count int
+			}
+		}
+		// a null passes the page limit, it is read as the default rather than as no limit
+		c.MustPost(`{ authors(limit: null, offset: null) { list { id } count } }`, &resp)
+		assert.Len(t, resp.Authors.List, 10)
+		assert.Equal(t, 16, resp.Authors.Count)
+		c.MustPost(`query Authors($limit: Int) { authors(limit: $limit) { list { id } count } }`, &resp, client.Var("limit", nil))
+		assert.Len(t, resp.Authors.List, 10)
+	})
+
+	t.Run("limit as string variable", func(t *testing.T) {
+		resp, err := c.RawPost(`query Authors($limit: Int) { authors(limit: $limit) { list { id } } }`, client.Var("limit", "1000000"))
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `limit of Query.authors`)
+			assert.Contains(t, string(resp.Errors), `"code":"PAGE_LIMIT"`)
+		}
+	})
+
+	t.Run("resolvers clamp to the maximum", func(t *testing.T) {
+		cfg := graph.DefaultConfig()
+		cfg.MaxPageLimit = 5
+		resolver := &graph.Resolver{Config: cfg, NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+		c := client.New(resolver.Middleware(handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))))
+		var resp struct {
+			Authors struct {
+				List []struct{ ID int }
+			}
+		}
+		c.MustPost(`query Authors($limit: Int) { authors(limit: $limit) { list { id } } }`, &resp, client.Var("limit", "1000000"))
+		assert.Len(t, resp.Authors.List, 5)
+	})
+}
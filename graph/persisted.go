@@ -0,0 +1,156 @@
+package graph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/mitchellh/mapstructure"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PersistedQueryStoreMemory   = "memory"
+	PersistedQueryStoreDatabase = "database"
+)
+
+const ErrorCode_OperationNotAllowed = "OPERATION_NOT_ALLOWED"
+
+// PersistedQuery is a query registered by a client through automatic persisted queries.
+type PersistedQuery struct {
+	Hash      string `gorm:"primaryKey;size:64"`
+	Query     string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+}
+
+func QueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// PersistedQueryStore keeps persisted queries in the database so they survive restarts and are
+// shared between instances, Cache is looked up first and filled on every database hit.
+type PersistedQueryStore struct {
+	DB    *gorm.DB
+	Cache graphql.Cache
+}
+
+var _ graphql.Cache = (*PersistedQueryStore)(nil)
+
+func (s *PersistedQueryStore) Get(ctx context.Context, key string) (interface{}, bool) {
+	if v, ok := s.Cache.Get(ctx, key); ok {
+		return v, true
+	}
+	var pq PersistedQuery
+	result := s.DB.WithContext(ctx).Where("hash = ?", key).Limit(1).Find(&pq)
+	if result.Error != nil {
+		DefaultLogger.Error(ctx, "persisted query lookup failed", map[string]interface{}{"hash": key, "error": result.Error.Error()})
+		return nil, false
+	}
+	if result.RowsAffected == 0 {
+		return nil, false
+	}
+	s.Cache.Add(ctx, key, pq.Query)
+	return pq.Query, true
+}
+
+func (s *PersistedQueryStore) Add(ctx context.Context, key string, value interface{}) {
+	s.Cache.Add(ctx, key, value)
+	query, ok := value.(string)
+	if !ok {
+		return
+	}
+	result := s.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&PersistedQuery{Hash: key, Query: query})
+	if result.Error != nil {
+		DefaultLogger.Error(ctx, "persisted query store failed", map[string]interface{}{"hash": key, "error": result.Error.Error()})
+	}
+}
+
+// OperationManifest maps the sha256 hash of every registered operation to its query.
+type OperationManifest map[string]string
+
+// LoadOperationManifest reads either a JSON object of hash to query, or an apollo persisted query
+// manifest with an operations list. Every hash is checked against its query.
+func LoadOperationManifest(file string) (OperationManifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid operation manifest '%s': %v", file, err)
+	}
+	manifest := OperationManifest{}
+	if ops, ok := raw["operations"]; ok {
+		var operations []struct {
+			ID   string `json:"id"`
+			Body string `json:"body"`
+		}
+		if err := json.Unmarshal(ops, &operations); err != nil {
+			return nil, fmt.Errorf("invalid operation manifest '%s': %v", file, err)
+		}
+		for _, op := range operations {
+			manifest[op.ID] = op.Body
+		}
+	} else if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid operation manifest '%s': %v", file, err)
+	}
+	for hash, query := range manifest {
+		if QueryHash(query) != hash {
+			return nil, fmt.Errorf("invalid operation manifest '%s': hash '%s' does not match its query", file, hash)
+		}
+	}
+	return manifest, nil
+}
+
+// OperationAllowlist is a gqlgen extension serving the operations of the manifest by hash, it must
+// be added before the automatic persisted query extension. In strict mode any other operation is
+// rejected before it can be persisted.
+type OperationAllowlist struct {
+	Manifest OperationManifest
+	Strict   bool
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationParameterMutator
+} = OperationAllowlist{}
+
+func (OperationAllowlist) ExtensionName() string {
+	return "OperationAllowlist"
+}
+
+func (OperationAllowlist) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e OperationAllowlist) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	var extension struct {
+		Sha256 string `mapstructure:"sha256Hash"`
+	}
+	if v := rawParams.Extensions["persistedQuery"]; v != nil {
+		mapstructure.Decode(v, &extension)
+	}
+	if rawParams.Query == "" {
+		if query, ok := e.Manifest[extension.Sha256]; ok {
+			rawParams.Query = query
+			return nil
+		}
+	} else if _, ok := e.Manifest[QueryHash(rawParams.Query)]; ok {
+		return nil
+	}
+	if !e.Strict {
+		return nil
+	}
+	err := gqlerror.Errorf("operation is not registered in the manifest")
+	errcode.Set(err, ErrorCode_OperationNotAllowed)
+	return err
+}
@@ -0,0 +1,125 @@
+package graph_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistedQueries(t *testing.T) {
+	db := OpenSQLite(t)
+	if err := graph.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	booksQuery := `query Books { books { count } }`
+	authorsQuery := `query Authors { authors { count } }`
+	persisted := func(query string) client.Option {
+		return client.Extensions(map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": graph.QueryHash(query)},
+		})
+	}
+	newClient := func(allowlist *graph.OperationAllowlist) *client.Client {
+		repo := graph.NewMemoryRepository()
+		repo.Populate()
+		resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return repo }}
+		srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+		srv.AddTransport(transport.POST{})
+		if allowlist != nil {
+			srv.Use(*allowlist)
+		}
+		srv.Use(extension.AutomaticPersistedQuery{Cache: &graph.PersistedQueryStore{DB: db, Cache: lru.New(10)}})
+		return client.New(resolver.Middleware(srv))
+	}
+
+	t.Run("database store survives a new cache", func(t *testing.T) {
+		store := &graph.PersistedQueryStore{DB: db, Cache: lru.New(10)}
+		store.Add(context.TODO(), graph.QueryHash(booksQuery), booksQuery)
+		store.Add(context.TODO(), graph.QueryHash(booksQuery), booksQuery)
+
+		store = &graph.PersistedQueryStore{DB: db, Cache: lru.New(10)}
+		query, ok := store.Get(context.TODO(), graph.QueryHash(booksQuery))
+		assert.True(t, ok)
+		assert.Equal(t, booksQuery, query)
+		_, ok = store.Get(context.TODO(), graph.QueryHash(authorsQuery))
+		assert.False(t, ok)
+	})
+
+	t.Run("automatic persisted query", func(t *testing.T) {
+		c := newClient(nil)
+		resp, err := c.RawPost("", persisted(authorsQuery))
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(resp.Errors), `"code":"PERSISTED_QUERY_NOT_FOUND"`)
+		}
+
+		var data struct {
+			Authors struct{ Count int }
+		}
+		c.MustPost(authorsQuery, &data, persisted(authorsQuery))
+		assert.Equal(t, 4, data.Authors.Count)
+
+		data.Authors.Count = 0
+		c = newClient(nil)
+		c.MustPost("", &data, persisted(authorsQuery))
+		assert.Equal(t, 4, data.Authors.Count)
+	})
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "manifest.json")
+	manifest := `{"format":"apollo-persisted-query-manifest","version":1,"operations":[{"id":"` + graph.QueryHash(booksQuery) + `","name":"Books","type":"query","body":"` + booksQuery + `"}]}`
+	if err := os.WriteFile(file, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("load manifest", func(t *testing.T) {
+		m, err := graph.LoadOperationManifest(file)
+		if assert.NoError(t, err) {
+			assert.Equal(t, graph.OperationManifest{graph.QueryHash(booksQuery): booksQuery}, m)
+		}
+
+		plain := filepath.Join(dir, "plain.json")
+		os.WriteFile(plain, []byte(`{"`+graph.QueryHash(booksQuery)+`":"`+authorsQuery+`"}`), 0644)
+		_, err = graph.LoadOperationManifest(plain)
+		assert.ErrorContains(t, err, "does not match its query")
+	})
+
+	t.Run("strict allowlist", func(t *testing.T) {
+		m, err := graph.LoadOperationManifest(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newClient(&graph.OperationAllowlist{Manifest: m, Strict: true})
+
+		var data struct {
+			Books struct{ Count int }
+		}
+		c.MustPost("", &data, persisted(booksQuery))
+		assert.Equal(t, 4, data.Books.Count)
+		data.Books.Count = 0
+		c.MustPost(booksQuery, &data)
+		assert.Equal(t, 4, data.Books.Count)
+
+		resp, err := c.RawPost(`query Other { authors { count } }`)
+		if assert.NoError(t, err) {
+			assert.Nil(t, resp.Data)
+			assert.Contains(t, string(resp.Errors), `"code":"OPERATION_NOT_ALLOWED"`)
+		}
+		other := `query Other { authors { count } }`
+		resp, err = c.RawPost(other, persisted(other))
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(resp.Errors), `"code":"OPERATION_NOT_ALLOWED"`)
+		}
+		_, ok := (&graph.PersistedQueryStore{DB: db, Cache: lru.New(10)}).Get(context.TODO(), graph.QueryHash(other))
+		assert.False(t, ok, "rejected operation must not be persisted")
+	})
+}
@@ -0,0 +1,266 @@
+package graph
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const ErrorCode_RateLimited = "RATE_LIMITED"
+
+const Context_RateLimit = ContextID("RateLimit")
+
+// APIKeyHeader carries the API key of a client, rate limits are kept per key when it is present.
+const APIKeyHeader = "X-API-Key"
+
+// AuthenticationCost is taken from the bucket of the client address for every API key that has to be
+// verified, a guessed key is never charged to a bucket of its own.
+var AuthenticationCost float64 = 10
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// RateLimiter keeps a token bucket per client refilled at Rate tokens per second up to Burst.
+type RateLimiter struct {
+	Rate  float64
+	Burst float64
+	// Now is the clock of the limiter, time.Now when nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func NewRateLimiter(rate float64, burst float64) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst}
+}
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Take consumes cost tokens from the bucket of key, a cost above Burst is capped so an expensive
+// operation empties the bucket rather than never passing. When the bucket is short it returns how
+// long until it holds enough.
+func (l *RateLimiter) Take(key string, cost float64) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.Burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.Burst, b.tokens+now.Sub(b.at).Seconds()*l.Rate)
+	b.at = now
+	cost = math.Min(cost, l.Burst)
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, 0
+	}
+	return false, time.Duration((cost - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep drops the buckets refilled to Burst once a minute, they are the same as a missing one.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*l.Rate >= l.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type rateLimitState struct {
+	limiter    *RateLimiter
+	apiKey     string
+	remote     string
+	retryAfter time.Duration
+}
+
+// rateLimitWriter turns the response of a limited operation into a 429 with Retry-After.
+type rateLimitWriter struct {
+	http.ResponseWriter
+	state       *rateLimitState
+	wroteHeader bool
+}
+
+func (w *rateLimitWriter) WriteHeader(status int) {
+	if !w.wroteHeader && w.state.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(w.state.retryAfter.Seconds()))))
+		status = http.StatusTooManyRequests
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *rateLimitWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *rateLimitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.wroteHeader = true
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Middleware records who is calling for the RateLimit extension, which decides once the cost of the
+// operation is known.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &rateLimitState{limiter: l, apiKey: r.Header.Get(APIKeyHeader), remote: r.RemoteAddr}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			state.remote = host
+		}
+		ctx := context.WithValue(r.Context(), Context_RateLimit, state)
+		next.ServeHTTP(&rateLimitWriter{ResponseWriter: w, state: state}, r.WithContext(ctx))
+	})
+}
+
+// Charge takes cost tokens for every request to a plain HTTP route like /export, which has no
+// operation for the RateLimit extension to cost. It goes inside Middleware and the authentication
+// so the caller is known.
+func (l *RateLimiter) Charge(cost float64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(Context_RateLimit).(*rateLimitState); ok {
+			if ok, wait := l.Take(rateLimitKey(r.Context(), state), cost); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// chargeAuthentication takes AuthenticationCost from the bucket of the client address before a key
+// is verified, so keys cannot be guessed faster than the address may send requests.
+func chargeAuthentication(ctx context.Context) (bool, time.Duration) {
+	state, ok := ctx.Value(Context_RateLimit).(*rateLimitState)
+	if !ok {
+		return true, 0
+	}
+	return state.limiter.Take("ip:"+state.remote, AuthenticationCost)
+}
+
+// rateLimitKey prefers the API key, then the actor, then the client address. The key itself is
+// hashed so it is never kept in memory in the clear.
+func rateLimitKey(ctx context.Context, state *rateLimitState) string {
+	if state.apiKey != "" {
+		return "key:" + QueryHash(state.apiKey)
+	}
+	if actor := Actor(ctx); actor != nil {
+		return "user:" + *actor
+	}
+	return "ip:" + state.remote
+}
+
+// ParseRateLimitOverrides reads a comma separated list of operation=cost.
+func ParseRateLimitOverrides(value string) (map[string]int, error) {
+	res := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, cost, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit override '%s', expected operation=cost", entry)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(cost))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid rate limit override '%s', cost must be a non negative integer", entry)
+		}
+		res[strings.TrimSpace(name)] = v
+	}
+	return res, nil
+}
+
+// RateLimit is a gqlgen extension taking the cost computed by CostLimit, which must be added
+// before it, from the bucket of the client. Overrides replace the cost of an operation by name.
+type RateLimit struct {
+	Limiter   *RateLimiter
+	Overrides map[string]int
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = RateLimit{}
+
+func (RateLimit) ExtensionName() string {
+	return "RateLimit"
+}
+
+func (RateLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e RateLimit) MutateOperationContext(ctx context.Context, oc *graphql.OperationContext) *gqlerror.Error {
+	state, ok := ctx.Value(Context_RateLimit).(*rateLimitState)
+	if !ok || oc.Operation == nil {
+		return nil
+	}
+	cost := 1
+	if c, ok := oc.Stats.GetExtension(costExtension).(OperationCost); ok && c.Cost > cost {
+		cost = c.Cost
+	}
+	name := oc.OperationName
+	if name == "" {
+		name = oc.Operation.Name
+	}
+	if v, ok := e.Overrides[name]; ok {
+		cost = v
+	}
+	if cost == 0 {
+		return nil
+	}
+	allowed, retryAfter := e.Limiter.Take(rateLimitKey(ctx, state), float64(cost))
+	if allowed {
+		return nil
+	}
+	state.retryAfter = retryAfter
+	return &gqlerror.Error{
+		Message: fmt.Sprintf("rate limit exceeded, retry in %s", retryAfter.Round(time.Millisecond)),
+		Extensions: map[string]interface{}{
+			"code":       ErrorCode_RateLimited,
+			"cost":       cost,
+			"retryAfter": math.Ceil(retryAfter.Seconds()),
+		},
+	}
+}
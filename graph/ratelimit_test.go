@@ -0,0 +1,98 @@
+package graph_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	limiter := graph.NewRateLimiter(2, 10)
+	limiter.Now = func() time.Time { return now }
+
+	t.Run("token bucket", func(t *testing.T) {
+		ok, _ := limiter.Take("a", 8)
+		assert.True(t, ok)
+		ok, retry := limiter.Take("a", 4)
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, retry)
+		ok, _ = limiter.Take("b", 4)
+		assert.True(t, ok, "buckets are per key")
+
+		now = now.Add(time.Second)
+		ok, _ = limiter.Take("a", 4)
+		assert.True(t, ok)
+		ok, retry = limiter.Take("a", 50)
+		assert.False(t, ok, "cost is capped at the burst")
+		assert.Equal(t, 5*time.Second, retry)
+	})
+
+	repo := graph.NewMemoryRepository()
+	repo.Populate()
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return repo }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.CostLimit{})
+	srv.Use(graph.RateLimit{Limiter: limiter, Overrides: map[string]int{"Cheap": 1}})
+	h := limiter.Middleware(resolver.Middleware(srv))
+	post := func(query string, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"`+query+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set(graph.APIKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("cost based consumption", func(t *testing.T) {
+		// bookSeries 1 + limit 3 * list 1
+		query := `query Series { bookSeries(limit: 3) { list { id } } }`
+		for i := 0; i < 2; i++ {
+			rec := post(query, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "RATE_LIMITED")
+		}
+		rec := post(query, "")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), `"code":"RATE_LIMITED"`)
+		assert.Contains(t, rec.Body.String(), `"retryAfter":1`)
+
+		rec = post(query, "secret-key")
+		assert.Equal(t, http.StatusOK, rec.Code, "api keys have their own bucket")
+	})
+
+	t.Run("operation override", func(t *testing.T) {
+		rec := post(`query Cheap { bookSeries(limit: 3) { list { id } } }`, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("plain route", func(t *testing.T) {
+		served := 0
+		route := limiter.Middleware(limiter.Charge(6, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served++
+		})))
+		get := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/export", nil)
+			req.Header.Set(graph.APIKeyHeader, "exporter")
+			rec := httptest.NewRecorder()
+			route.ServeHTTP(rec, req)
+			return rec
+		}
+		assert.Equal(t, http.StatusOK, get().Code)
+		rec := get()
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Equal(t, 1, served)
+	})
+}
@@ -0,0 +1,95 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+)
+
+// Repository is what the resolvers need from a storage backend. DataSource implements it on top of
+// gorm, MemoryRepository keeps everything in process for tests and demos.
+type Repository interface {
+	BookRepository
+	AuthorRepository
+	BookSeriesRepository
+	ReviewRepository
+	UserRepository
+	APIKeyRepository
+	WebhookRepository
+	ChangeRepository
+}
+
+type BookRepository interface {
+	Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error)
+	BooksSeriesBooks(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error)
+	ReviewBook(ctx context.Context, obj *model.Review) (*model.Book, error)
+	BookHistory(ctx context.Context, obj *model.Book) ([]*model.AuditEntry, error)
+	BookAsOf(ctx context.Context, id int, at time.Time) (*model.Book, error)
+	CreateBook(ctx context.Context, input model.NewBook) (*model.Book, error)
+	UpdateBook(ctx context.Context, input model.UpdateBook) (*model.Book, error)
+	DeleteBook(ctx context.Context, id int) (*model.Book, error)
+	RestoreBook(ctx context.Context, id int) (*model.Book, error)
+	PurgeDeleted(ctx context.Context, olderThan time.Time) (int, error)
+	ImportBooks(ctx context.Context, r io.Reader, format model.ImportFormat, batchSize int) (*model.ImportResult, error)
+	ExportBooks(ctx context.Context, w io.Writer, format ExportFormat) (int, error)
+}
+
+type AuthorRepository interface {
+	Authors(ctx context.Context, offset *int, limit *int, filter *model.AuthorFilter) (*model.AuthorList, error)
+	BookAuthors(ctx context.Context, obj *model.Book) ([]*model.Author, error)
+	CreateAuthor(ctx context.Context, input model.NewAuthor) (*model.Author, error)
+}
+
+type BookSeriesRepository interface {
+	BookSeries(ctx context.Context, offset *int, limit *int, filter *model.BookSeriesFilter) (*model.BookSeriesList, error)
+}
+
+type ReviewRepository interface {
+	BookReviews(ctx context.Context, obj *model.Book, offset *int, limit *int, filter *model.ReviewFilter) ([]*model.Review, error)
+	CreateReview(ctx context.Context, input model.NewReview) (*model.Review, error)
+	UpdateReview(ctx context.Context, input model.UpdateReview) (*model.Review, error)
+	DeleteReview(ctx context.Context, id int) (*model.Review, error)
+}
+
+type UserRepository interface {
+	User(ctx context.Context, id int) (*model.User, error)
+	CreateUser(ctx context.Context, input model.NewUser) (*model.User, error)
+}
+
+type APIKeyRepository interface {
+	APIKeys(ctx context.Context, includeRevoked *bool) ([]*model.APIKey, error)
+	APIKeyByPrefix(ctx context.Context, prefix string) (*model.APIKey, error)
+	CreateAPIKey(ctx context.Context, key *model.APIKey) error
+	RevokeAPIKey(ctx context.Context, id int, at time.Time) (*model.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int, at time.Time) error
+}
+
+type WebhookRepository interface {
+	Webhooks(ctx context.Context) ([]*model.Webhook, error)
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	DeleteWebhook(ctx context.Context, id int) (*model.Webhook, error)
+	WebhookDeliveries(ctx context.Context, status model.WebhookDeliveryStatus, limit int) ([]*model.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, id int, at time.Time) (*model.WebhookDelivery, error)
+}
+
+type ChangeRepository interface {
+	Changes(ctx context.Context, since model.Cursor, limit int) (*model.ChangeFeed, error)
+}
+
+var _ Repository = (*DataSource)(nil)
+var _ Repository = (*MemoryRepository)(nil)
+
+func WithRepository(ctx context.Context, repo Repository) context.Context {
+	return context.WithValue(ctx, Context_DataSource, repo)
+}
+
+// RepositoryOf returns the repository attached by Resolver.Middleware or WithRepository.
+func RepositoryOf(ctx context.Context) (Repository, error) {
+	if repo, ok := ctx.Value(Context_DataSource).(Repository); ok && repo != nil {
+		return repo, nil
+	}
+	return nil, fmt.Errorf("no repository in request context, wrap the handler with Resolver.Middleware")
+}
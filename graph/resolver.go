@@ -1,5 +1,10 @@
 package graph
 
+import (
+	"fmt"
+	"net/http"
+)
+
 //go:generate go run github.com/senomas/gographql/plugin github.com/99designs/gqlgen/plugin
 // github.com/99designs/gqlgen
 //
@@ -7,4 +12,42 @@ package graph
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
-type Resolver struct{}
+type Resolver struct {
+	Config *ConfigType
+	// NewRepository creates the repository for a single request. DataSource batches loads per
+	// instance, so a repository must not be shared between requests unless it is stateless.
+	NewRepository func() Repository
+}
+
+// Middleware attaches a repository from NewRepository to every request passing through it, scoped
+// to the configured tenant. The tenant of a request is bound to its credential: APIKeyAuth scopes a
+// request with a key to the tenant of the key, so TenantHeader naming another tenant is refused
+// for anonymous requests.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.NewRepository == nil {
+			http.Error(w, "resolver has no repository factory", http.StatusInternalServerError)
+			return
+		}
+		tenant := DefaultTenant
+		if r.Config != nil && r.Config.Tenant != "" {
+			tenant = r.Config.Tenant
+		}
+		if header := req.Header.Get(TenantHeader); header != "" {
+			if err := ValidTenant(header); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if header != tenant && req.Header.Get(APIKeyHeader) == "" {
+				http.Error(w, fmt.Sprintf("tenant '%s' requires an api key of the tenant", header), http.StatusForbidden)
+				return
+			}
+		}
+		if err := ValidTenant(tenant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := WithTenant(req.Context(), tenant)
+		next.ServeHTTP(w, req.WithContext(WithRepository(ctx, r.NewRepository())))
+	})
+}
@@ -0,0 +1,44 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	repo := graph.NewMemoryRepository()
+	repo.Populate()
+	created := 0
+	resolver := &graph.Resolver{
+		Config: graph.DefaultConfig(),
+		NewRepository: func() graph.Repository {
+			created++
+			return repo
+		},
+	}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+
+	t.Run("missing repository", func(t *testing.T) {
+		var resp struct {
+			Books struct{ Count int }
+		}
+		err := client.New(srv).Post(`{ books { count } }`, &resp)
+		assert.ErrorContains(t, err, "no repository in request context, wrap the handler with Resolver.Middleware")
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		c := client.New(resolver.Middleware(srv))
+		var resp struct {
+			Books struct{ Count int }
+		}
+		c.MustPost(`{ books { count } }`, &resp)
+		assert.Equal(t, 4, resp.Books.Count)
+		c.MustPost(`{ books { count } }`, &resp)
+		assert.Equal(t, 2, created)
+	})
+}
@@ -0,0 +1,165 @@
+package graph_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviewOwnership(t *testing.T) {
+	db := SetupSQLite(t)
+	ctx := graph.WithTenant(context.TODO(), graph.DefaultTenant)
+
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	resolver := &graph.Resolver{Config: cfg, NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole}}))
+	c := client.New(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv)))
+
+	ds := graph.NewDataSource(db)
+	newKey := func(name string, scopes []string, userID *int) string {
+		apiKey, key, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: name, Scopes: scopes, UserID: userID}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.CreateAPIKey(ctx, apiKey); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	luna, err := ds.CreateUser(ctx, model.NewUser{Name: "luna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	neville, err := ds.CreateUser(ctx, model.NewUser{Name: "neville"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lunaKey := newKey("luna", []string{"reader"}, &luna.ID)
+	nevilleKey := newKey("neville", []string{"reader"}, &neville.ID)
+	moderatorKey := newKey("moderator", []string{graph.RoleModerator}, nil)
+
+	type Review struct {
+		ID      int
+		Star    int
+		Version int
+		Author  *struct{ Name string }
+	}
+	var created struct {
+		CreateReview Review
+	}
+	t.Run("create", func(t *testing.T) {
+		var anonymous struct {
+			CreateReview *Review
+		}
+		err := c.Post(`mutation { createReview(input: {book_id: 1, star: 5, text: "nargles"}) { id } }`, &anonymous)
+		assert.ErrorContains(t, err, `"code":"UNAUTHENTICATED"`)
+
+		c.MustPost(`mutation { createReview(input: {book_id: 1, star: 5, text: "nargles"}) { id star version author { name } } }`, &created,
+			client.AddHeader(graph.APIKeyHeader, lunaKey))
+		if assert.NotNil(t, created.CreateReview.Author) {
+			assert.Equal(t, "luna", created.CreateReview.Author.Name)
+		}
+
+		var again struct {
+			CreateReview *Review
+		}
+		err = c.Post(`mutation { createReview(input: {book_id: 1, star: 1, text: "again"}) { id } }`, &again,
+			client.AddHeader(graph.APIKeyHeader, lunaKey))
+		assert.ErrorContains(t, err, "user 'luna' already reviewed book with id '1'")
+
+		var count int64
+		db.WithContext(ctx).Model(&model.Review{}).Where("book_id = ? AND user_id = ?", 1, luna.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("author of book reviews", func(t *testing.T) {
+		var resp struct {
+			Books struct {
+				List []struct {
+					Reviews []Review
+				}
+			}
+		}
+		c.MustPost(`{ books(filter: {id: 1}) { list { reviews(limit: 50) { id star version author { name } } } } }`, &resp)
+		if assert.Len(t, resp.Books.List, 1) {
+			authors := map[int]string{}
+			for _, r := range resp.Books.List[0].Reviews {
+				if r.Author != nil {
+					authors[r.ID] = r.Author.Name
+				}
+			}
+			assert.Equal(t, map[int]string{created.CreateReview.ID: "luna"}, authors)
+		}
+	})
+
+	t.Run("only the owner updates", func(t *testing.T) {
+		var resp struct {
+			UpdateReview Review
+		}
+		err := c.Post(`mutation($id: Int!) { updateReview(input: {id: $id, expectedVersion: 1, star: 1}) { id } }`, &resp,
+			client.Var("id", created.CreateReview.ID), client.AddHeader(graph.APIKeyHeader, nevilleKey))
+		assert.ErrorContains(t, err, "belongs to another user")
+		assert.ErrorContains(t, err, `"code":"FORBIDDEN"`)
+
+		c.MustPost(`mutation($id: Int!) { updateReview(input: {id: $id, expectedVersion: 1, star: 4}) { id star version author { name } } }`, &resp,
+			client.Var("id", created.CreateReview.ID), client.AddHeader(graph.APIKeyHeader, lunaKey))
+		assert.Equal(t, 4, resp.UpdateReview.Star)
+		assert.Equal(t, 2, resp.UpdateReview.Version)
+
+		err = c.Post(`mutation($id: Int!) { updateReview(input: {id: $id, expectedVersion: 1, star: 3}) { id } }`, &resp,
+			client.Var("id", created.CreateReview.ID), client.AddHeader(graph.APIKeyHeader, lunaKey))
+		assert.ErrorContains(t, err, `"code":"CONFLICT"`)
+	})
+
+	t.Run("enforced by the data source", func(t *testing.T) {
+		_, err := ds.DeleteReview(graph.WithUser(ctx, neville), created.CreateReview.ID)
+		assert.ErrorContains(t, err, "belongs to another user")
+		_, err = ds.DeleteReview(ctx, created.CreateReview.ID)
+		assert.ErrorContains(t, err, "modifying a review requires a user")
+		_, err = ds.UpdateReview(graph.WithUser(ctx, neville), model.UpdateReview{ID: 1, ExpectedVersion: 1, Text: graph.Of("anonymous")})
+		assert.ErrorContains(t, err, "belongs to another user")
+	})
+
+	t.Run("moderator deletes", func(t *testing.T) {
+		var resp struct {
+			DeleteReview struct{ ID int }
+		}
+		c.MustPost(`mutation($id: Int!) { deleteReview(id: $id) { id } }`, &resp,
+			client.Var("id", created.CreateReview.ID), client.AddHeader(graph.APIKeyHeader, moderatorKey))
+		assert.Equal(t, created.CreateReview.ID, resp.DeleteReview.ID)
+
+		var history []model.AuditEntry
+		db.WithContext(ctx).Where("entity = ? AND entity_id = ?", "reviews", created.CreateReview.ID).Order("id").Find(&history)
+		if assert.Len(t, history, 3) {
+			assert.Equal(t, model.AuditActionDelete, history[2].Action)
+			assert.Equal(t, graph.Of("apikey:moderator"), history[2].Actor)
+		}
+	})
+
+	t.Run("book of a deleted book", func(t *testing.T) {
+		review, err := ds.CreateReview(graph.WithUser(ctx, neville), model.NewReview{BookID: 2, Star: 3, Text: "wrackspurts"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = ds.DeleteBook(ctx, 2)
+		assert.NoError(t, err)
+
+		var resp struct {
+			UpdateReview struct {
+				Book *struct{ ID int }
+			}
+		}
+		err = c.Post(`mutation($id: Int!) { updateReview(input: {id: $id, expectedVersion: 1, star: 2}) { book { id } } }`, &resp,
+			client.Var("id", review.ID), client.AddHeader(graph.APIKeyHeader, nevilleKey))
+		assert.ErrorContains(t, err, "the requested element is null")
+	})
+}
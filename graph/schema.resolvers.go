@@ -5,59 +5,324 @@ package graph
 
 import (
 	"context"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/senomas/gographql/graph/generated"
 	"github.com/senomas/gographql/graph/model"
 )
 
+func (r *apiKeyResolver) User(ctx context.Context, obj *model.APIKey) (*model.User, error) {
+	if obj.UserID == nil {
+		return nil, nil
+	}
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.User(ctx, *obj.UserID)
+}
+
 func (r *bookResolver) Authors(ctx context.Context, obj *model.Book) ([]*model.Author, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).BookAuthors(ctx, obj)
+	if obj.Authors != nil {
+		return obj.Authors, nil
+	}
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.BookAuthors(ctx, obj)
 }
 
 func (r *bookResolver) Reviews(ctx context.Context, obj *model.Book, offset *int, limit *int, filter *model.ReviewFilter) ([]*model.Review, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).BookReviews(ctx, obj, offset, limit, filter)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit = r.pageArgs(ctx, offset, limit)
+	return repo.BookReviews(ctx, obj, offset, limit, filter)
 }
 
-func (r *bookSeriesResolver) Books(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter) (*model.BookList, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).BooksSeriesBooks(ctx, obj, offset, limit, filter)
+func (r *bookResolver) History(ctx context.Context, obj *model.Book) ([]*model.AuditEntry, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.BookHistory(ctx, obj)
+}
+
+func (r *bookSeriesResolver) Books(ctx context.Context, obj *model.BookSeries, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := CanIncludeDeleted(ctx, includeDeleted); err != nil {
+		return nil, err
+	}
+	offset, limit = r.pageArgs(ctx, offset, limit)
+	return repo.BooksSeriesBooks(ctx, obj, offset, limit, filter, includeDeleted)
 }
 
 func (r *mutationResolver) CreateAuthor(ctx context.Context, input model.NewAuthor) (*model.Author, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).CreateAuthor(ctx, input)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CreateAuthor(ctx, input)
 }
 
 func (r *mutationResolver) CreateBook(ctx context.Context, input model.NewBook) (*model.Book, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).CreateBook(ctx, input)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CreateBook(ctx, input)
 }
 
 func (r *mutationResolver) UpdateBook(ctx context.Context, input model.UpdateBook) (*model.Book, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).UpdateBook(ctx, input)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.UpdateBook(ctx, input)
 }
 
 func (r *mutationResolver) DeleteBook(ctx context.Context, id int) (*model.Book, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).DeleteBook(ctx, id)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.DeleteBook(ctx, id)
+}
+
+func (r *mutationResolver) RestoreBook(ctx context.Context, id int) (*model.Book, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.RestoreBook(ctx, id)
+}
+
+func (r *mutationResolver) PurgeDeleted(ctx context.Context, olderThan time.Time) (int, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.PurgeDeleted(ctx, olderThan)
 }
 
 func (r *mutationResolver) CreateReview(ctx context.Context, input model.NewReview) (*model.Review, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).CreateReview(ctx, input)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CreateReview(ctx, input)
+}
+
+func (r *mutationResolver) UpdateReview(ctx context.Context, input model.UpdateReview) (*model.Review, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.UpdateReview(ctx, input)
+}
+
+func (r *mutationResolver) DeleteReview(ctx context.Context, id int) (*model.Review, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.DeleteReview(ctx, id)
+}
+
+func (r *mutationResolver) ImportBooks(ctx context.Context, file graphql.Upload, format *model.ImportFormat, batchSize *int) (*model.ImportResult, error) {
+	if format == nil {
+		if f, err := ImportFormatOf(file.Filename); err != nil {
+			return nil, err
+		} else {
+			format = &f
+		}
+	}
+	if batchSize == nil {
+		batchSize = Of(DefaultImportBatchSize)
+	}
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ImportBooks(ctx, file.File, *format, *batchSize)
+}
+
+func (r *mutationResolver) CreateUser(ctx context.Context, input model.NewUser) (*model.User, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CreateUser(ctx, input)
+}
+
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input model.NewAPIKey) (*model.NewAPIKeyResult, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	apiKey, key, err := NewAPIKey(r.Config, input, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.CreateAPIKey(ctx, apiKey); err != nil {
+		return nil, err
+	}
+	return &model.NewAPIKeyResult{APIKey: apiKey, Key: key}, nil
+}
+
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id int) (*model.APIKey, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.RevokeAPIKey(ctx, id, time.Now())
+}
+
+func (r *mutationResolver) CreateWebhook(ctx context.Context, input model.NewWebhook) (*model.NewWebhookResult, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	webhook, secret, err := NewWebhook(ctx, r.Config, input, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return &model.NewWebhookResult{Webhook: webhook, Secret: secret}, nil
+}
+
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id int) (*model.Webhook, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.DeleteWebhook(ctx, id)
+}
+
+func (r *mutationResolver) RetryWebhookDelivery(ctx context.Context, id int) (*model.WebhookDelivery, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.RetryWebhookDelivery(ctx, id, time.Now())
 }
 
 func (r *queryResolver) BookSeries(ctx context.Context, offset *int, limit *int, filter *model.BookSeriesFilter) (*model.BookSeriesList, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).BookSeries(ctx, offset, limit, filter)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit = r.pageArgs(ctx, offset, limit)
+	return repo.BookSeries(ctx, offset, limit, filter)
 }
 
 func (r *queryResolver) Authors(ctx context.Context, offset *int, limit *int, filter *model.AuthorFilter) (*model.AuthorList, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).Authors(ctx, offset, limit, filter)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit = r.pageArgs(ctx, offset, limit)
+	return repo.Authors(ctx, offset, limit, filter)
+}
+
+func (r *queryResolver) Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter, includeDeleted *bool) (*model.BookList, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := CanIncludeDeleted(ctx, includeDeleted); err != nil {
+		return nil, err
+	}
+	offset, limit = r.pageArgs(ctx, offset, limit)
+	return repo.Books(ctx, offset, limit, filter, includeDeleted)
+}
+
+func (r *queryResolver) BookAsOf(ctx context.Context, id int, at time.Time) (*model.Book, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.BookAsOf(ctx, id, at)
 }
 
-func (r *queryResolver) Books(ctx context.Context, offset *int, limit *int, filter *model.BookFilter) (*model.BookList, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).Books(ctx, offset, limit, filter)
+func (r *queryResolver) APIKeys(ctx context.Context, includeRevoked *bool) ([]*model.APIKey, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.APIKeys(ctx, includeRevoked)
+}
+
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Webhooks(ctx)
+}
+
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, status *model.WebhookDeliveryStatus, limit *int) ([]*model.WebhookDelivery, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := model.WebhookDeliveryStatusDead
+	if status != nil {
+		s = *status
+	}
+	l := 50
+	if limit != nil {
+		l = *limit
+	}
+	return repo.WebhookDeliveries(ctx, s, l)
+}
+
+func (r *queryResolver) Changes(ctx context.Context, since *model.Cursor, limit *int) (*model.ChangeFeed, error) {
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var s model.Cursor
+	if since != nil {
+		s = *since
+	}
+	l := 100
+	if limit != nil {
+		l = *limit
+	}
+	return repo.Changes(ctx, s, l)
 }
 
 func (r *reviewResolver) Book(ctx context.Context, obj *model.Review) (*model.Book, error) {
-	return ctx.Value(Context_DataSource).(*DataSource).ReviewBook(ctx, obj)
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ReviewBook(ctx, obj)
+}
+
+func (r *reviewResolver) Author(ctx context.Context, obj *model.Review) (*model.User, error) {
+	if obj.UserID == nil {
+		return nil, nil
+	}
+	repo, err := RepositoryOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.User(ctx, *obj.UserID)
 }
 
+// ApiKey returns generated.ApiKeyResolver implementation.
+func (r *Resolver) ApiKey() generated.ApiKeyResolver { return &apiKeyResolver{r} }
+
 // Book returns generated.BookResolver implementation.
 func (r *Resolver) Book() generated.BookResolver { return &bookResolver{r} }
 
@@ -73,6 +338,7 @@ func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 // Review returns generated.ReviewResolver implementation.
 func (r *Resolver) Review() generated.ReviewResolver { return &reviewResolver{r} }
 
+type apiKeyResolver struct{ *Resolver }
 type bookResolver struct{ *Resolver }
 type bookSeriesResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
@@ -0,0 +1,184 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const Context_Tenant = ContextID("Tenant")
+
+// TenantHeader names the catalog a request expects. It has to match the tenant of the API key of
+// the request, anonymous requests are served the configured tenant.
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultTenant owns the rows created before tenants existed and is the tenant of requests when
+// none is configured.
+const DefaultTenant = "default"
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func ValidTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant '%s'", tenant)
+	}
+	return nil
+}
+
+// anyTenant is the tenant of a context lifted by AnyTenant, it is never a valid tenant.
+const anyTenant = "*"
+
+// TenantOf returns the tenant of ctx, empty when the context is not scoped to a single tenant.
+func TenantOf(ctx context.Context) string {
+	if tenant := tenantScope(ctx); tenant != anyTenant {
+		return tenant
+	}
+	return ""
+}
+
+// tenantScope is the tenant of ctx as given, anyTenant for a context lifted by AnyTenant and empty
+// for a context that never had a tenant.
+func tenantScope(ctx context.Context) string {
+	tenant, _ := ctx.Value(Context_Tenant).(string)
+	return tenant
+}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, Context_Tenant, tenant)
+}
+
+// AnyTenant lifts the tenant scope of ctx, for the few statements that span every tenant like
+// authenticating an API key, the migrations or the webhook dispatcher.
+func AnyTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, Context_Tenant, anyTenant)
+}
+
+const tenantScopedKey = "tenant:scoped"
+
+// TenantPlugin scopes every statement on a model with a TenantID to the tenant of the statement
+// context: queries, counts, updates and deletes only see the rows of the tenant and creates are
+// stamped with it. A statement without a tenant in its context fails, only a context lifted by
+// AnyTenant sees every tenant.
+type TenantPlugin struct{}
+
+func (TenantPlugin) Name() string {
+	return "gographql:tenant"
+}
+
+func (TenantPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("tenant:create", stampTenant),
+		cb.Query().Before("gorm:query").Register("tenant:query", scopeTenant),
+		cb.Update().Before("gorm:update").Register("tenant:update", scopeTenant),
+		cb.Delete().Before("gorm:delete").Register("tenant:delete", scopeTenant),
+		cb.Row().Before("gorm:row").Register("tenant:row", scopeTenant),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scopeTenant(db *gorm.DB) {
+	tenant := tenantScope(db.Statement.Context)
+	if tenant == anyTenant || db.Statement.Schema == nil {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+	if tenant == "" {
+		db.AddError(errNoTenant(db.Statement.Schema.Table))
+		return
+	}
+	if expr := db.Statement.TableExpr; expr != nil && expr.SQL != db.Statement.Quote(db.Statement.Table) {
+		// the rows of a derived table cannot be told apart, its source statement is scoped instead
+		// and the derived table lifted with AnyTenant
+		db.AddError(fmt.Errorf("statement on table expression %s without a tenant", expr.SQL))
+		return
+	}
+	if _, ok := db.Statement.Settings.LoadOrStore(tenantScopedKey, true); ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+	}})
+}
+
+func errNoTenant(table string) error {
+	return fmt.Errorf("statement on %s without a tenant", table)
+}
+
+func stampTenant(db *gorm.DB) {
+	tenant := tenantScope(db.Statement.Context)
+	if tenant == anyTenant || db.Statement.Schema == nil {
+		// rows created across tenants keep the tenant they were given
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+	if tenant == "" {
+		db.AddError(errNoTenant(db.Statement.Schema.Table))
+		return
+	}
+	ctx := db.Statement.Context
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := field.Set(ctx, reflect.Indirect(rv.Index(i)), tenant); err != nil {
+				db.AddError(err)
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(ctx, rv, tenant); err != nil {
+			db.AddError(err)
+		}
+	}
+}
+
+// legacyUniques are the single column unique constraints that became unique per tenant.
+var legacyUniques = []struct {
+	model  interface{}
+	table  string
+	column string
+}{
+	{&model.Author{}, "authors", "name"},
+	{&model.Book{}, "books", "title"},
+	{&model.BookSeries{}, "book_series", "title"},
+	{&model.User{}, "users", "name"},
+}
+
+// dropLegacyUniques removes the unique constraints of databases migrated before tenants, they
+// would keep a title unique across every tenant. Sqlite declares them inline with the column,
+// those databases have to be recreated.
+func dropLegacyUniques(db *gorm.DB) error {
+	for _, u := range legacyUniques {
+		switch db.Dialector.Name() {
+		case DialectPostgres:
+			name := fmt.Sprintf("%s_%s_key", u.table, u.column)
+			if db.Migrator().HasConstraint(u.model, name) {
+				if err := db.Migrator().DropConstraint(u.model, name); err != nil {
+					return err
+				}
+			}
+		case DialectMySQL:
+			if db.Migrator().HasIndex(u.model, u.column) {
+				if err := db.Migrator().DropIndex(u.model, u.column); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
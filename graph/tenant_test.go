@@ -0,0 +1,240 @@
+package graph_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenant(t *testing.T) {
+	db := SetupSQLite(t)
+	// the same catalog again, titles and names are only unique per tenant
+	acme := graph.WithTenant(context.TODO(), "acme")
+	if err := graph.Populate(db.WithContext(acme)); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	resolver := &graph.Resolver{Config: cfg, NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole}}))
+	c := client.New(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv)))
+
+	ds := graph.NewDataSource(db)
+	ginny, err := ds.CreateUser(acme, model.NewUser{Name: "ginny"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey, acmeKey, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: "acme", Scopes: []string{"reader"}, UserID: &ginny.ID}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.CreateAPIKey(acme, apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	type Books struct {
+		Books struct {
+			Count int
+			List  []struct {
+				ID      int
+				Title   string
+				Authors []struct{ ID int }
+				Reviews []struct{ ID int }
+			}
+		}
+	}
+	tenant := func(name string) client.Option {
+		return client.AddHeader(graph.TenantHeader, name)
+	}
+	ids := func(resp Books) (books []int, authors []int, reviews []int) {
+		for _, b := range resp.Books.List {
+			books = append(books, b.ID)
+			for _, a := range b.Authors {
+				authors = append(authors, a.ID)
+			}
+			for _, r := range b.Reviews {
+				reviews = append(reviews, r.ID)
+			}
+		}
+		return books, authors, reviews
+	}
+	query := `{ books { count list { id title authors { id } reviews { id } } } }`
+	// the tenant of a request is the tenant of its api key
+	asAcme := client.AddHeader(graph.APIKeyHeader, acmeKey)
+
+	t.Run("queries and batch loaders", func(t *testing.T) {
+		var resp Books
+		c.MustPost(query, &resp)
+		books, authors, reviews := ids(resp)
+		assert.Equal(t, 4, resp.Books.Count)
+		assert.ElementsMatch(t, []int{1, 2, 3, 4}, books)
+		assert.Subset(t, []int{1, 2, 3, 4}, authors)
+		assert.ElementsMatch(t, []int{1, 2, 3, 4}, reviews)
+
+		var acmeResp Books
+		c.MustPost(query, &acmeResp, asAcme)
+		books, authors, reviews = ids(acmeResp)
+		assert.Equal(t, 4, acmeResp.Books.Count)
+		assert.ElementsMatch(t, []int{5, 6, 7, 8}, books)
+		assert.Subset(t, []int{5, 6, 7, 8}, authors)
+		assert.ElementsMatch(t, []int{5, 6, 7, 8}, reviews)
+
+		// an anonymous request cannot pick another tenant
+		var other Books
+		err := c.Post(query, &other, tenant("acme"))
+		assert.ErrorContains(t, err, "http 403")
+		c.MustPost(query, &other, tenant(graph.DefaultTenant))
+		assert.Equal(t, 4, other.Books.Count)
+	})
+
+	t.Run("lookup by id", func(t *testing.T) {
+		var resp Books
+		c.MustPost(`{ books(filter: {id: 1}) { count list { id title authors { id } reviews { id } } } }`, &resp, asAcme)
+		assert.Equal(t, 0, resp.Books.Count)
+
+		// a context without a tenant fails closed, lifting the scope is explicit
+		_, err := ds.User(context.TODO(), ginny.ID)
+		assert.ErrorContains(t, err, "statement on users without a tenant")
+		user, err := ds.User(graph.AnyTenant(context.TODO()), ginny.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, user)
+		user, err = graph.NewDataSource(db).User(graph.WithTenant(context.TODO(), graph.DefaultTenant), ginny.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, user)
+
+		// a table named as is stays scoped, a derived table has to be lifted explicitly
+		var books []*model.Book
+		result := db.WithContext(acme).Table("books").Find(&books)
+		if assert.NoError(t, result.Error) {
+			assert.Len(t, books, 4)
+		}
+		source := db.WithContext(acme).Model(&model.Book{}).Select("id", "title")
+		result = db.WithContext(acme).Table("(?) AS books", source).Find(&books)
+		assert.ErrorContains(t, result.Error, "statement on table expression (?) AS books without a tenant")
+		result = db.WithContext(graph.AnyTenant(acme)).Table("(?) AS books", source).Find(&books)
+		if assert.NoError(t, result.Error) {
+			assert.Len(t, books, 4)
+		}
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		var deleted struct {
+			DeleteBook struct{ ID int }
+		}
+		err := c.Post(`mutation { deleteBook(id: 1) { id } }`, &deleted, asAcme)
+		assert.ErrorContains(t, err, "book with id '1' does not exist")
+
+		var created struct {
+			CreateBook struct{ ID int }
+		}
+		err = c.Post(`mutation { createBook(input: {title: "Harry Potter and the Book of Evil", authors_name: ["J.K. Rowling"]}) { id } }`, &created, asAcme)
+		assert.ErrorContains(t, err, `duplicate key books.title \"Harry Potter and the Book of Evil\"`)
+		c.MustPost(`mutation { createBook(input: {title: "Harry Potter and the Unknown", authors_name: ["J.K. Rowling"]}) { id } }`, &created, asAcme)
+		assert.NotZero(t, created.CreateBook.ID)
+
+		var resp Books
+		c.MustPost(`{ books(filter: {title: {op: EQ, value: "Harry Potter and the Unknown"}}) { count list { id title authors { id } reviews { id } } } }`, &resp)
+		assert.Equal(t, 0, resp.Books.Count)
+		c.MustPost(`{ books(filter: {id: 1}) { count list { id title authors { id } reviews { id } } } }`, &resp)
+		assert.Equal(t, 1, resp.Books.Count)
+
+		// the author subquery is scoped like the statement it filters
+		c.MustPost(`{ books(filter: {author_name: {op: EQ, value: "J.K. Rowling"}}) { count list { id title authors { id } reviews { id } } } }`, &resp, asAcme)
+		assert.Equal(t, 3, resp.Books.Count)
+		c.MustPost(`{ books(filter: {author_name: {op: EQ, value: "J.K. Rowling"}}) { count list { id title authors { id } reviews { id } } } }`, &resp)
+		assert.Equal(t, 2, resp.Books.Count)
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		var resp Books
+		c.MustPost(query, &resp, client.AddHeader(graph.APIKeyHeader, acmeKey))
+		books, _, _ := ids(resp)
+		assert.Subset(t, books, []int{5, 6, 7, 8})
+		assert.NotContains(t, books, 1)
+
+		err := c.Post(query, &resp, client.AddHeader(graph.APIKeyHeader, acmeKey), tenant(graph.DefaultTenant))
+		assert.ErrorContains(t, err, "http 401")
+	})
+
+	t.Run("export", func(t *testing.T) {
+		// wired like /export in server.go
+		export := resolver.Middleware((&graph.APIKeyAuth{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			repo, err := graph.RepositoryOf(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			graph.ExportHandler(repo).ServeHTTP(w, r)
+		})))
+		get := func(h http.Handler, header string, value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/export?format=ndjson", nil)
+			req.Header.Set(header, value)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+		rec := get(export, graph.TenantHeader, graph.DefaultTenant)
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Contains(t, rec.Body.String(), "Harry Potter and the Book of Evil")
+			assert.NotContains(t, rec.Body.String(), "Harry Potter and the Unknown")
+			assert.Equal(t, 4, strings.Count(rec.Body.String(), "\n"))
+		}
+		rec = get(export, graph.TenantHeader, "acme")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "Harry Potter")
+		rec = get(export, graph.APIKeyHeader, acmeKey)
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Contains(t, rec.Body.String(), "Harry Potter and the Unknown")
+			assert.Equal(t, 5, strings.Count(rec.Body.String(), "\n"))
+		}
+
+		// a handler left outside the middleware fails instead of exporting every tenant
+		rec = get(graph.ExportHandler(graph.NewDataSource(db)), graph.APIKeyHeader, acmeKey)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "Harry Potter")
+	})
+
+	t.Run("invalid tenant", func(t *testing.T) {
+		var resp Books
+		err := c.Post(query, &resp, tenant("../acme"))
+		assert.ErrorContains(t, err, "http 400")
+	})
+
+	t.Run("batch loader shared by tenants", func(t *testing.T) {
+		// loads of different tenants queued in one batch are queried per tenant
+		ds := graph.NewDataSource(db)
+		var wg sync.WaitGroup
+		users := map[string]*model.User{}
+		var mu sync.Mutex
+		for _, name := range []string{graph.DefaultTenant, "acme"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				user, err := ds.User(graph.WithTenant(context.TODO(), name), ginny.ID)
+				assert.NoError(t, err)
+				mu.Lock()
+				users[name] = user
+				mu.Unlock()
+			}(name)
+		}
+		wg.Wait()
+		assert.Nil(t, users[graph.DefaultTenant])
+		if assert.NotNil(t, users["acme"]) {
+			assert.Equal(t, "ginny", users["acme"].Name)
+			assert.Equal(t, "acme", users["acme"].TenantID)
+		}
+	})
+}
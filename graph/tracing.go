@@ -0,0 +1,231 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/graph-gophers/dataloader"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	TracingNone   = ""
+	TracingStdout = "stdout"
+	// TracingFile writes spans as JSON to the file named by tracingEndpoint
+	TracingFile = "file"
+	// TracingOTLP sends spans over OTLP/HTTP to tracingEndpoint, localhost:4318 when empty
+	TracingOTLP = "otlp"
+)
+
+// tracer resolves through the global provider, spans are dropped until SetupTracing installs one.
+var tracer = otel.Tracer("github.com/senomas/gographql/graph")
+
+// SetupTracing installs the exporter selected by the configuration as the global tracer provider
+// and the W3C trace context propagator. The returned function flushes pending spans.
+func SetupTracing(ctx context.Context, cfg *ConfigType) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	closeFn := func() error { return nil }
+	switch cfg.TracingExporter {
+	case TracingNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	case TracingFile:
+		f, err := os.OpenFile(cfg.TracingEndpoint, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = exp
+		closeFn = f.Close
+	case TracingOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithInsecure()}
+		if cfg.TracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.TracingEndpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter '%s'", cfg.TracingExporter)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.Application))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if cerr := closeFn(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracingMiddleware continues the trace of the incoming request headers, or starts a new one.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.RequestURI())))
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Tracing is a gqlgen extension adding a span per operation and per field backed by a resolver.
+type Tracing struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = Tracing{}
+
+func (Tracing) ExtensionName() string {
+	return "Tracing"
+}
+
+func (Tracing) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (Tracing) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	name, kind := oc.OperationName, "operation"
+	if oc.Operation != nil {
+		kind = string(oc.Operation.Operation)
+		if name == "" {
+			name = oc.Operation.Name
+		}
+	}
+	spanName := kind
+	if name != "" {
+		spanName += " " + name
+	}
+	ctx, span := tracer.Start(ctx, spanName, trace.WithAttributes(
+		attribute.String("graphql.operation.name", name),
+		attribute.String("graphql.operation.type", kind),
+	))
+	resp := next(ctx)
+	var err error
+	if resp != nil && len(resp.Errors) > 0 {
+		err = resp.Errors
+	}
+	endSpan(span, err)
+	return resp
+}
+
+func (Tracing) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+	ctx, span := tracer.Start(ctx, fc.Object+"."+fc.Field.Name, trace.WithAttributes(
+		attribute.String("graphql.field.path", fc.Path().String()),
+	))
+	res, err := next(ctx)
+	endSpan(span, err)
+	return res, err
+}
+
+// startBatchSpan backdates the batch span to the first queued key, so the loader wait shows up as
+// the gap before the dispatched event.
+func startBatchSpan(ctx context.Context, queued time.Time, keys int) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "dataloader.batch", trace.WithTimestamp(queued), trace.WithAttributes(
+		attribute.Int("dataloader.keys", keys),
+	))
+	span.AddEvent("dispatched")
+	return ctx, span
+}
+
+func endBatchSpan(span trace.Span, groups int, results []*dataloader.Result) {
+	span.SetAttributes(attribute.Int("dataloader.groups", groups))
+	var err error
+	for _, r := range results {
+		if r != nil && r.Error != nil {
+			err = r.Error
+		}
+	}
+	endSpan(span, err)
+}
+
+// TracingPlugin adds a span per gorm statement under the span of the statement context.
+type TracingPlugin struct{}
+
+const tracingSpanKey = "tracing:span"
+
+func (TracingPlugin) Name() string {
+	return "tracing"
+}
+
+func (TracingPlugin) Initialize(db *gorm.DB) error {
+	system := db.Dialector.Name()
+	before := func(db *gorm.DB) {
+		if db.DryRun {
+			return
+		}
+		_, span := tracer.Start(db.Statement.Context, "sql", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(system)))
+		db.InstanceSet(tracingSpanKey, span)
+	}
+	after := func(kind string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			v, ok := db.InstanceGet(tracingSpanKey)
+			if !ok {
+				return
+			}
+			span := v.(trace.Span)
+			span.SetName("sql " + kind + " " + db.Statement.Table)
+			span.SetAttributes(
+				semconv.DBStatementKey.String(db.Statement.SQL.String()),
+				semconv.DBSQLTableKey.String(db.Statement.Table),
+				attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+			)
+			err := db.Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = nil
+			}
+			endSpan(span, err)
+		}
+	}
+	return registerAround(db, "tracing", before, after)
+}
@@ -0,0 +1,70 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	// the package tracer delegates to the first provider installed globally, keep this the only one
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	db := SetupSQLite(t)
+	if err := db.Use(graph.TracingPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &graph.Resolver{Config: graph.DefaultConfig(), NewRepository: func() graph.Repository { return graph.NewDataSource(db) }}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+	srv.Use(graph.Tracing{})
+	c := client.New(graph.TracingMiddleware(resolver.Middleware(srv)))
+
+	var resp struct {
+		Books model.BookList
+	}
+	c.MustPost(`query BooksWithAuthors {
+      books {
+         count
+         list {
+            id
+            authors {
+               name
+            }
+         }
+      }
+   }`, &resp, client.AddHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	assert.Equal(t, 4, resp.Books.Count)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String(), s.Name())
+		spans[s.Name()] = s
+	}
+	for _, name := range []string{"POST /", "query BooksWithAuthors", "Query.books", "Book.authors", "dataloader.batch", "sql query books", "sql query authors"} {
+		assert.Contains(t, spans, name)
+	}
+	if s, ok := spans["POST /"]; ok {
+		assert.Equal(t, "00f067aa0ba902b7", s.Parent().SpanID().String())
+	}
+	if s, ok := spans["sql query authors"]; ok {
+		batches := map[string]bool{}
+		for _, b := range recorder.Ended() {
+			if b.Name() == "dataloader.batch" {
+				batches[b.SpanContext().SpanID().String()] = true
+			}
+		}
+		assert.True(t, batches[s.Parent().SpanID().String()], "sql span should be a child of a batch span")
+	}
+}
@@ -0,0 +1,331 @@
+package graph
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/senomas/gographql/graph/model"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// WebhookSignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot
+	// and the body, keyed with the secret of the webhook.
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookSecretPrefix starts every webhook secret.
+const WebhookSecretPrefix = "whsec_"
+
+// NewWebhook builds a webhook for input, the returned secret is the only time it is handed out. In
+// production mode the url has to resolve to public addresses only.
+func NewWebhook(ctx context.Context, cfg *ConfigType, input model.NewWebhook, now time.Time) (*model.Webhook, string, error) {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid webhook url '%s'", input.URL)
+	}
+	if cfg.Mode == ModeProduction {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid webhook url '%s': %v", input.URL, err)
+		}
+		for _, ip := range ips {
+			if !webhookAddressAllowed(ip) {
+				return nil, "", fmt.Errorf("invalid webhook url '%s': %s is not a public address", input.URL, ip)
+			}
+		}
+	}
+	events := []model.WebhookEvent{}
+	for _, e := range input.Events {
+		if !e.IsValid() {
+			return nil, "", fmt.Errorf("invalid webhook event '%s'", e)
+		}
+		events = append(events, e)
+	}
+	secret := WebhookSecretPrefix + GenerateRandomString(32)
+	return &model.Webhook{URL: input.URL, Events: events, Secret: secret, CreatedAt: now}, secret, nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private although net.IP.IsPrivate leaves it out.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// webhookAddressAllowed is false for the addresses a production server keeps webhooks away from, the
+// server itself, its private networks and the metadata service of its cloud at 169.254.169.254.
+func webhookAddressAllowed(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// webhookTransport checks every address a delivery connects to again, a name resolving to a public
+// address when the webhook was created may resolve to a private one since, or redirect to one.
+func webhookTransport(timeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{Timeout: timeout, Control: func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+			return fmt.Errorf("webhook address %s is not a public address", host)
+		}
+		return nil
+	}}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// auditWebhook leaves the secret out of the audit trail.
+func auditWebhook(webhook *model.Webhook) *model.Webhook {
+	if webhook == nil {
+		return nil
+	}
+	c := *webhook
+	c.Secret = ""
+	return &c
+}
+
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEvent is the event of an audited change, false for the entities webhooks do not cover.
+func webhookEvent(action model.AuditAction, entity string) (model.WebhookEvent, bool) {
+	if entity != "books" {
+		return "", false
+	}
+	switch action {
+	case model.AuditActionInsert:
+		return model.WebhookEventBookCreated, true
+	case model.AuditActionUpdate:
+		return model.WebhookEventBookUpdated, true
+	case model.AuditActionDelete:
+		return model.WebhookEventBookDeleted, true
+	}
+	return "", false
+}
+
+func subscribed(webhook *model.Webhook, event model.WebhookEvent) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the body posted for an event, Data is the snapshot of the book after the change
+// or before it when the book was deleted for good.
+type WebhookPayload struct {
+	Event     model.WebhookEvent     `json:"event"`
+	Tenant    string                 `json:"tenant"`
+	Entity    string                 `json:"entity"`
+	ID        int                    `json:"id"`
+	Operation string                 `json:"operation"`
+	Actor     *string                `json:"actor"`
+	At        time.Time              `json:"at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// NewWebhookDeliveries queues the change for every webhook subscribed to its event, the deliveries
+// are meant to be stored along with the change.
+func NewWebhookDeliveries(ctx context.Context, namer schema.Namer, webhooks []*model.Webhook, action model.AuditAction, entity string, id int, before interface{}, after interface{}, now time.Time) ([]*model.WebhookDelivery, error) {
+	event, ok := webhookEvent(action, entity)
+	if !ok {
+		return nil, nil
+	}
+	deliveries := []*model.WebhookDelivery{}
+	var payload []byte
+	for _, w := range webhooks {
+		if !subscribed(w, event) {
+			continue
+		}
+		if payload == nil {
+			data := after
+			if data == nil {
+				data = before
+			}
+			snapshot, err := Snapshot(ctx, namer, data)
+			if err != nil {
+				return nil, err
+			}
+			payload, err = json.Marshal(&WebhookPayload{Event: event, Tenant: TenantOf(ctx), Entity: entity, ID: id,
+				Operation: OperationName(ctx), Actor: Actor(ctx), At: now, Data: snapshot})
+			if err != nil {
+				return nil, err
+			}
+		}
+		deliveries = append(deliveries, &model.WebhookDelivery{WebhookID: w.ID, Event: event, Payload: string(payload),
+			Status: model.WebhookDeliveryStatusPending, NextAttemptAt: now, CreatedAt: now})
+	}
+	return deliveries, nil
+}
+
+// WebhookStore is the outbox the dispatcher works from, it spans every tenant.
+type WebhookStore interface {
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due at now along with their
+	// webhook, pushing their next attempt to lease so other dispatchers leave them alone meanwhile.
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]*model.WebhookDelivery, error)
+	// SaveWebhookDelivery records the attempt at a delivery claimed until lease, it fails when the
+	// lease ran out and another dispatcher claimed the delivery since.
+	SaveWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, lease time.Time) error
+}
+
+var _ WebhookStore = (*DataSource)(nil)
+var _ WebhookStore = (*MemoryRepository)(nil)
+
+// WebhookDispatcher posts the deliveries of the outbox, retrying a failed one after Backoff doubled
+// for every attempt made, up to MaxBackoff, until MaxAttempts leaves it dead.
+type WebhookDispatcher struct {
+	Store       WebhookStore
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Interval    time.Duration
+	Batch       int
+	// Now is the clock of the due and retry times, time.Now when nil
+	Now func() time.Time
+}
+
+func NewWebhookDispatcher(cfg *ConfigType, store WebhookStore) *WebhookDispatcher {
+	client := &http.Client{Timeout: time.Duration(cfg.WebhookTimeout) * time.Second}
+	if cfg.Mode == ModeProduction {
+		client.Transport = webhookTransport(client.Timeout)
+	}
+	return &WebhookDispatcher{
+		Store:       store,
+		Client:      client,
+		MaxAttempts: int(cfg.WebhookMaxAttempts),
+		Backoff:     time.Duration(cfg.WebhookBackoff) * time.Second,
+		MaxBackoff:  time.Hour,
+		Interval:    time.Duration(cfg.WebhookInterval) * time.Second,
+		Batch:       100,
+	}
+}
+
+func (d *WebhookDispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+// Run dispatches every Interval until ctx is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			DefaultLogger.Error(ctx, "webhook dispatch failed", map[string]interface{}{"error": err.Error()})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch makes one attempt at every delivery due, up to Batch of them, and returns how many were
+// attempted.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context) (int, error) {
+	now := d.now()
+	for i := 0; i < d.Batch; i++ {
+		// every delivery is claimed right before its attempt, a lease taken for the whole batch would
+		// run out while the deliveries at its end still wait for their turn
+		lease := d.lease()
+		deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, now, lease, 1)
+		if err != nil || len(deliveries) == 0 {
+			return i, err
+		}
+		delivery := deliveries[0]
+		err = d.post(ctx, delivery)
+		d.attempt(ctx, delivery, err, d.now())
+		if err := d.Store.SaveWebhookDelivery(ctx, delivery, lease); err != nil {
+			return i, err
+		}
+	}
+	return d.Batch, nil
+}
+
+// lease is when a delivery claimed now is given up on, a delivery whose dispatcher died during the
+// attempt is picked up again once it has passed.
+func (d *WebhookDispatcher) lease() time.Time {
+	timeout := 2 * d.Client.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	// the store keeps no more than milliseconds, the lease is matched again when the attempt is saved
+	return d.now().Add(timeout).Truncate(time.Millisecond)
+}
+
+func (d *WebhookDispatcher) post(ctx context.Context, delivery *model.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	// signed when it is sent rather than when its batch started, receivers reject stale timestamps
+	now := d.now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Webhook.Secret, now.Unix(), []byte(delivery.Payload)))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// attempt records the outcome of an attempt made at now.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *model.WebhookDelivery, err error, now time.Time) {
+	delivery.Attempts++
+	if err == nil {
+		delivery.Status = model.WebhookDeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = nil
+		return
+	}
+	delivery.LastError = Of(err.Error())
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = model.WebhookDeliveryStatusDead
+		DefaultLogger.Error(ctx, "webhook delivery is dead", map[string]interface{}{
+			"delivery": delivery.ID, "webhook": delivery.WebhookID, "attempts": delivery.Attempts, "error": err.Error()})
+		return
+	}
+	backoff := d.Backoff
+	for i := 1; i < delivery.Attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	delivery.NextAttemptAt = now.Add(backoff)
+}
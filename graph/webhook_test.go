@@ -0,0 +1,354 @@
+package graph_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver stands in for a downstream system, failing every request while fail is set.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	fail     bool
+	requests []webhookRequest
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, webhookRequest{header: req.Header.Clone(), body: body})
+	if r.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+func (r *webhookReceiver) received() []webhookRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhookRequest{}, r.requests...)
+}
+
+// claimHook calls claimed after every claim, standing in for the time the posts of a batch take.
+type claimHook struct {
+	graph.WebhookStore
+	claimed func()
+}
+
+func (h claimHook) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Time, limit int) ([]*model.WebhookDelivery, error) {
+	deliveries, err := h.WebhookStore.ClaimWebhookDeliveries(ctx, now, lease, limit)
+	h.claimed()
+	return deliveries, err
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Run("database", func(t *testing.T) {
+		db := SetupSQLite(t)
+		testWebhooks(t, func() graph.Repository { return graph.NewDataSource(db) }, graph.NewDataSource(db))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		mem := graph.NewMemoryRepository()
+		mem.Populate()
+		testWebhooks(t, func() graph.Repository { return mem }, mem)
+	})
+}
+
+func testWebhooks(t *testing.T, repository func() graph.Repository, store graph.WebhookStore) {
+	cfg := graph.DefaultConfig()
+	cfg.Argon2_Time = 1
+	cfg.Argon2_Memory = 1024
+	resolver := &graph.Resolver{Config: cfg, NewRepository: repository}
+	srv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole}}))
+	c := client.New(resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv)))
+
+	apiKey, key, err := graph.NewAPIKey(cfg, model.NewAPIKey{Name: "admin", Scopes: []string{graph.RoleAdmin}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repository().CreateAPIKey(graph.WithTenant(context.TODO(), graph.DefaultTenant), apiKey); err != nil {
+		t.Fatal(err)
+	}
+	admin := client.AddHeader(graph.APIKeyHeader, key)
+
+	receiver := &webhookReceiver{}
+	target := httptest.NewServer(receiver)
+	defer target.Close()
+
+	now := time.Now().Add(time.Second)
+	dispatcher := graph.NewWebhookDispatcher(cfg, store)
+	dispatcher.MaxAttempts = 3
+	dispatcher.Backoff = time.Minute
+	dispatcher.Now = func() time.Time { return now }
+
+	var created struct {
+		CreateWebhook struct {
+			Webhook struct {
+				ID     int
+				Events []string
+			}
+			Secret string
+		}
+	}
+	c.MustPost(`mutation($url: String!) { createWebhook(input: {url: $url, events: [BOOK_CREATED, BOOK_DELETED]}) { webhook { id events } secret } }`,
+		&created, admin, client.Var("url", target.URL))
+	secret := created.CreateWebhook.Secret
+	assert.Regexp(t, "^"+graph.WebhookSecretPrefix, secret)
+	assert.Equal(t, []string{"BOOK_CREATED", "BOOK_DELETED"}, created.CreateWebhook.Webhook.Events)
+
+	var invalid struct {
+		CreateWebhook *struct{ Secret string }
+	}
+	err = c.Post(`mutation { createWebhook(input: {url: "ftp://example.com"}) { secret } }`, &invalid, admin)
+	assert.ErrorContains(t, err, "invalid webhook url 'ftp://example.com'")
+
+	var book struct {
+		CreateBook struct {
+			ID      int
+			Version int
+		}
+	}
+	t.Run("signed delivery", func(t *testing.T) {
+		c.MustPost(`mutation { createBook(input: {title: "Harry Potter and the Half-Blood Prince", authors_name: ["J.K. Rowling"]}) { id version } }`, &book)
+		// rolled back with the failed mutation, and updates are not subscribed
+		var duplicate struct {
+			CreateBook *struct{ ID int }
+		}
+		err := c.Post(`mutation { createBook(input: {title: "Harry Potter and the Half-Blood Prince", authors_name: ["J.K. Rowling"]}) { id } }`, &duplicate)
+		assert.ErrorContains(t, err, "duplicate key books.title")
+		var updated struct {
+			UpdateBook struct{ ID int }
+		}
+		c.MustPost(`mutation($id: Int!) { updateBook(input: {id: $id, expectedVersion: 1, title: "Harry Potter and the Prince"}) { id } }`,
+			&updated, client.Var("id", book.CreateBook.ID))
+
+		attempted, err := dispatcher.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+		requests := receiver.received()
+		if !assert.Len(t, requests, 1) {
+			return
+		}
+		r := requests[0]
+		assert.Equal(t, "BOOK_CREATED", r.header.Get(graph.WebhookEventHeader))
+		assert.Equal(t, strconv.FormatInt(now.Unix(), 10), r.header.Get(graph.WebhookTimestampHeader))
+		assert.Equal(t, graph.SignWebhook(secret, now.Unix(), r.body), r.header.Get(graph.WebhookSignatureHeader))
+		assert.NotEqual(t, graph.SignWebhook("whsec_other", now.Unix(), r.body), r.header.Get(graph.WebhookSignatureHeader))
+		var payload graph.WebhookPayload
+		if assert.NoError(t, json.Unmarshal(r.body, &payload)) {
+			assert.Equal(t, model.WebhookEventBookCreated, payload.Event)
+			assert.Equal(t, graph.DefaultTenant, payload.Tenant)
+			assert.Equal(t, book.CreateBook.ID, payload.ID)
+			assert.Equal(t, "createBook", payload.Operation)
+			assert.Equal(t, "Harry Potter and the Half-Blood Prince", payload.Data["title"])
+		}
+
+		attempted, err = dispatcher.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempted)
+	})
+
+	type Delivery struct {
+		ID        int
+		Event     string
+		Status    string
+		Attempts  int
+		LastError *string
+		Webhook   struct{ URL string }
+	}
+	var deliveries struct {
+		WebhookDeliveries []Delivery
+	}
+	t.Run("retries until dead", func(t *testing.T) {
+		receiver.mu.Lock()
+		receiver.fail = true
+		receiver.mu.Unlock()
+		var deleted struct {
+			DeleteBook struct{ ID int }
+		}
+		c.MustPost(`mutation($id: Int!) { deleteBook(id: $id) { id } }`, &deleted, client.Var("id", book.CreateBook.ID))
+
+		for i, step := range []struct {
+			after     time.Duration
+			attempted int
+		}{
+			{0, 1},
+			{30 * time.Second, 0},
+			{30 * time.Second, 1},
+			{time.Minute, 0},
+			{time.Minute, 1},
+			{time.Hour, 0},
+		} {
+			now = now.Add(step.after)
+			attempted, err := dispatcher.Dispatch(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, step.attempted, attempted, "step %d", i)
+		}
+		assert.Len(t, receiver.received(), 4)
+
+		c.MustPost(`{ webhookDeliveries { id event status attempts lastError webhook { url } } }`, &deliveries, admin)
+		if assert.Len(t, deliveries.WebhookDeliveries, 1) {
+			d := deliveries.WebhookDeliveries[0]
+			assert.Equal(t, "BOOK_DELETED", d.Event)
+			assert.Equal(t, "DEAD", d.Status)
+			assert.Equal(t, 3, d.Attempts)
+			if assert.NotNil(t, d.LastError) {
+				assert.Contains(t, *d.LastError, "503")
+			}
+			assert.Equal(t, target.URL, d.Webhook.URL)
+		}
+	})
+
+	t.Run("retry dead letter", func(t *testing.T) {
+		if len(deliveries.WebhookDeliveries) != 1 {
+			t.Skip("no dead letter")
+		}
+		receiver.mu.Lock()
+		receiver.fail = false
+		receiver.mu.Unlock()
+		var retried struct {
+			RetryWebhookDelivery Delivery
+		}
+		c.MustPost(`mutation($id: Int!) { retryWebhookDelivery(id: $id) { id event status attempts lastError webhook { url } } }`,
+			&retried, admin, client.Var("id", deliveries.WebhookDeliveries[0].ID))
+		assert.Equal(t, "PENDING", retried.RetryWebhookDelivery.Status)
+		assert.Equal(t, 0, retried.RetryWebhookDelivery.Attempts)
+
+		var again struct {
+			RetryWebhookDelivery *Delivery
+		}
+		err := c.Post(`mutation($id: Int!) { retryWebhookDelivery(id: $id) { id } }`, &again, admin, client.Var("id", deliveries.WebhookDeliveries[0].ID))
+		assert.ErrorContains(t, err, "is already pending")
+
+		now = now.Add(time.Second)
+		attempted, err := dispatcher.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		var delivered struct {
+			WebhookDeliveries []Delivery
+		}
+		c.MustPost(`{ webhookDeliveries(status: DELIVERED) { id event status attempts lastError webhook { url } } }`, &delivered, admin)
+		assert.Len(t, delivered.WebhookDeliveries, 2)
+		c.MustPost(`{ webhookDeliveries { id event status attempts lastError webhook { url } } }`, &deliveries, admin)
+		assert.Empty(t, deliveries.WebhookDeliveries)
+	})
+
+	t.Run("lost lease", func(t *testing.T) {
+		var book struct {
+			CreateBook struct{ ID int }
+		}
+		c.MustPost(`mutation { createBook(input: {title: "Harry Potter and the Goblet of Fire", authors_name: ["J.K. Rowling"]}) { id } }`, &book)
+		lease := now.Add(time.Minute).Truncate(time.Millisecond)
+		claimed, err := store.ClaimWebhookDeliveries(context.TODO(), now, lease, 10)
+		if !assert.NoError(t, err) || !assert.Len(t, claimed, 1) {
+			return
+		}
+		// the attempt outlives its lease and another dispatcher claims the delivery again
+		now = lease.Add(time.Second)
+		again, err := store.ClaimWebhookDeliveries(context.TODO(), now, now.Add(time.Minute), 10)
+		if !assert.NoError(t, err) || !assert.Len(t, again, 1) {
+			return
+		}
+		claimed[0].Status = model.WebhookDeliveryStatusDelivered
+		claimed[0].Attempts++
+		err = store.SaveWebhookDelivery(context.TODO(), claimed[0], lease)
+		assert.ErrorContains(t, err, "was claimed by another dispatcher")
+		assert.NoError(t, store.SaveWebhookDelivery(context.TODO(), again[0], again[0].NextAttemptAt))
+
+		now = now.Add(time.Minute)
+		attempted, err := dispatcher.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+	})
+
+	t.Run("signed when sent", func(t *testing.T) {
+		var book struct {
+			CreateBook struct{ ID int }
+		}
+		c.MustPost(`mutation { createBook(input: {title: "Harry Potter and the Order of the Phoenix", authors_name: ["J.K. Rowling"]}) { id } }`, &book)
+		now = now.Add(time.Second)
+		slow := graph.NewWebhookDispatcher(cfg, claimHook{store, func() { now = now.Add(time.Minute) }})
+		slow.Now = func() time.Time { return now }
+		sent := now.Add(time.Minute)
+		attempted, err := slow.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+		requests := receiver.received()
+		r := requests[len(requests)-1]
+		assert.Equal(t, strconv.FormatInt(sent.Unix(), 10), r.header.Get(graph.WebhookTimestampHeader))
+		assert.Equal(t, graph.SignWebhook(secret, sent.Unix(), r.body), r.header.Get(graph.WebhookSignatureHeader))
+	})
+
+	t.Run("delete webhook", func(t *testing.T) {
+		var deleted struct {
+			DeleteWebhook struct{ ID int }
+		}
+		c.MustPost(`mutation($id: Int!) { deleteWebhook(id: $id) { id } }`, &deleted, admin, client.Var("id", created.CreateWebhook.Webhook.ID))
+		var webhooks struct {
+			Webhooks []struct{ ID int }
+		}
+		c.MustPost(`{ webhooks { id } }`, &webhooks, admin)
+		assert.Empty(t, webhooks.Webhooks)
+
+		var book struct {
+			CreateBook struct{ ID int }
+		}
+		c.MustPost(`mutation { createBook(input: {title: "Harry Potter and the Deathly Hallows", authors_name: ["J.K. Rowling"]}) { id } }`, &book)
+		attempted, err := dispatcher.Dispatch(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempted)
+	})
+}
+
+func TestWebhookAddress(t *testing.T) {
+	cfg := graph.DefaultConfig()
+	cfg.Mode = graph.ModeProduction
+
+	for _, u := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, _, err := graph.NewWebhook(context.TODO(), cfg, model.NewWebhook{URL: u}, time.Now())
+		assert.ErrorContains(t, err, "is not a public address", u)
+	}
+	_, _, err := graph.NewWebhook(context.TODO(), cfg, model.NewWebhook{URL: "https://93.184.215.14/hook"}, time.Now())
+	assert.NoError(t, err)
+	_, _, err = graph.NewWebhook(context.TODO(), graph.DefaultConfig(), model.NewWebhook{URL: "http://127.0.0.1/hook"}, time.Now())
+	assert.NoError(t, err, "development mode reaches local receivers")
+
+	// the name of a webhook can be pointed elsewhere after it was created, the address is checked again
+	target := httptest.NewServer(&webhookReceiver{})
+	defer target.Close()
+	_, err = graph.NewWebhookDispatcher(cfg, nil).Client.Post(target.URL, "application/json", nil)
+	assert.ErrorContains(t, err, "webhook address 127.0.0.1 is not a public address")
+	resp, err := graph.NewWebhookDispatcher(graph.DefaultConfig(), nil).Client.Post(target.URL, "application/json", nil)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/senomas/gographql/graph"
+	"github.com/urfave/cli/v2"
+)
+
+var importCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "import books from a CSV or JSON file",
+	ArgsUsage: "FILE",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "format", Usage: "input format, csv or json (default from file extension)"},
+		&cli.IntFlag{Name: "batch", Usage: "number of rows committed per transaction", Value: graph.DefaultImportBatchSize, EnvVars: []string{"IMPORT_BATCH_SIZE"}},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.ShowSubcommandHelp(c)
+		}
+		db, err := openDB(c)
+		if err != nil {
+			return err
+		}
+		return importFile(c, db, "import", c.Args().First())
+	},
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senomas/gographql/graph"
+	"github.com/senomas/gographql/graph/generated"
+	"github.com/senomas/gographql/graph/model"
+	"github.com/urfave/cli/v2"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"gorm.io/gorm"
+)
+
+// set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+func main() {
+	app := &cli.App{
+		Name:    "gographql",
+		Usage:   "books GraphQL server",
+		Version: version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "YAML or TOML configuration file",
+				EnvVars: []string{"CONFIG_FILE"},
+			},
+			&cli.StringFlag{
+				Name:  "mode",
+				Usage: "development or production, overrides $APP_MODE",
+			},
+			&cli.StringFlag{
+				Name:  "driver",
+				Usage: "database driver, postgres, mysql, sqlite or memory, overrides $DB_DRIVER",
+			},
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "database DSN, a file name or :memory: for sqlite, overrides $DB_DSN",
+			},
+			&cli.BoolFlag{
+				Name:  "log-sql",
+				Usage: "log every SQL statement, overrides $LOGGER",
+			},
+			&cli.StringFlag{
+				Name:  "tenant",
+				Usage: "tenant the commands work on and of anonymous requests, overrides $TENANT",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			cfg, err := graph.LoadConfig(c.String("config"))
+			if err != nil {
+				return err
+			}
+			if c.IsSet("mode") {
+				cfg.Mode = c.String("mode")
+			}
+			if c.IsSet("driver") {
+				cfg.Driver = c.String("driver")
+			}
+			if c.IsSet("db") {
+				cfg.DSN = c.String("db")
+			}
+			if c.IsSet("log-sql") {
+				cfg.LogSQL = c.Bool("log-sql")
+			}
+			if c.IsSet("tenant") {
+				cfg.Tenant = c.String("tenant")
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %v", err)
+			}
+			c.App.Metadata["config"] = cfg
+			c.Context = graph.WithTenant(c.Context, cfg.Tenant)
+			return nil
+		},
+		Commands: []*cli.Command{
+			serveCommand,
+			migrateCommand,
+			seedCommand,
+			exportCommand,
+			importCommand,
+			schemaCommand,
+			apiKeyCommand,
+			userCommand,
+		},
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func config(c *cli.Context) *graph.ConfigType {
+	return c.App.Metadata["config"].(*graph.ConfigType)
+}
+
+func openDB(c *cli.Context) (*gorm.DB, error) {
+	return graph.Open(config(c))
+}
+
+var migrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "manage the database schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "create missing tables and columns",
+			Action: func(c *cli.Context) error {
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				if err := graph.MigrateUp(db); err != nil {
+					return err
+				}
+				fmt.Println("migrated up")
+				return nil
+			},
+		},
+		{
+			Name:  "down",
+			Usage: "drop every table, all data is lost",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "force", Usage: "confirm dropping the tables"},
+			},
+			Action: func(c *cli.Context) error {
+				if !c.Bool("force") {
+					return fmt.Errorf("refusing to drop tables without --force")
+				}
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				if err := graph.MigrateDown(db); err != nil {
+					return err
+				}
+				fmt.Println("migrated down")
+				return nil
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "list tables that are missing or out of date",
+			Action: func(c *cli.Context) error {
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				migrations, err := graph.Migrations(db)
+				if err != nil {
+					return err
+				}
+				pending := 0
+				for _, m := range migrations {
+					switch {
+					case !m.Exists:
+						fmt.Printf("%-16s missing\n", m.Table)
+					case len(m.MissingColumns) > 0:
+						fmt.Printf("%-16s missing columns %s\n", m.Table, strings.Join(m.MissingColumns, ", "))
+					default:
+						fmt.Printf("%-16s up to date\n", m.Table)
+					}
+					if !m.UpToDate() {
+						pending++
+					}
+				}
+				if pending > 0 {
+					return cli.Exit(fmt.Sprintf("%v tables pending, run migrate up", pending), 1)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+var seedCommand = &cli.Command{
+	Name:      "seed",
+	Usage:     "populate the database with sample data or a fixture file",
+	ArgsUsage: "[FILE]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "format", Usage: "fixture format, csv or json (default from file extension)"},
+		&cli.IntFlag{Name: "batch", Usage: "number of rows committed per transaction", Value: graph.DefaultImportBatchSize, EnvVars: []string{"IMPORT_BATCH_SIZE"}},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() > 1 {
+			return cli.ShowSubcommandHelp(c)
+		}
+		db, err := openDB(c)
+		if err != nil {
+			return err
+		}
+		if c.NArg() == 1 {
+			return importFile(c, db, "seed", c.Args().First())
+		}
+		tx := db.WithContext(c.Context).Begin()
+		if err := graph.Populate(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if result := tx.Commit(); result.Error != nil {
+			return result.Error
+		}
+		fmt.Println("seeded sample data")
+		return nil
+	},
+}
+
+var schemaCommand = &cli.Command{
+	Name:  "schema",
+	Usage: "inspect the GraphQL schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "print",
+			Usage: "print the GraphQL schema",
+			Action: func(c *cli.Context) error {
+				schema := generated.NewExecutableSchema(generated.Config{Resolvers: &graph.Resolver{Config: config(c)}}).Schema()
+				formatter.NewFormatter(c.App.Writer).FormatSchema(schema)
+				return nil
+			},
+		},
+	},
+}
+
+var apiKeyCommand = &cli.Command{
+	Name:  "apikey",
+	Usage: "manage API keys of service clients",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a key and print it, the key cannot be shown again",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "name", Usage: "name of the client", Required: true},
+				&cli.StringSliceFlag{Name: "scope", Usage: "role granted to the key, repeat for several", Required: true},
+				&cli.DurationFlag{Name: "expires", Usage: "lifetime of the key, never expires when zero"},
+				&cli.IntFlag{Name: "user", Usage: "id of the user the key acts for"},
+			},
+			Action: func(c *cli.Context) error {
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				now := time.Now()
+				input := model.NewAPIKey{Name: c.String("name"), Scopes: c.StringSlice("scope")}
+				if d := c.Duration("expires"); d > 0 {
+					input.ExpiresAt = graph.Of(now.Add(d))
+				}
+				if c.IsSet("user") {
+					input.UserID = graph.Of(c.Int("user"))
+				}
+				apiKey, key, err := graph.NewAPIKey(config(c), input, now)
+				if err != nil {
+					return err
+				}
+				ctx := context.WithValue(c.Context, graph.Context_Operation, "apikey create")
+				if err := graph.NewDataSource(db).CreateAPIKey(ctx, apiKey); err != nil {
+					return err
+				}
+				fmt.Fprintf(c.App.ErrWriter, "created api key %v %s (%s)\n", apiKey.ID, apiKey.Name, strings.Join(apiKey.Scopes, ", "))
+				fmt.Fprintln(c.App.Writer, key)
+				return nil
+			},
+		},
+		{
+			Name:      "revoke",
+			Usage:     "revoke a key",
+			ArgsUsage: "ID",
+			Action: func(c *cli.Context) error {
+				id, err := strconv.Atoi(c.Args().First())
+				if err != nil || c.NArg() != 1 {
+					return cli.ShowSubcommandHelp(c)
+				}
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				ctx := context.WithValue(c.Context, graph.Context_Operation, "apikey revoke")
+				apiKey, err := graph.NewDataSource(db).RevokeAPIKey(ctx, id, time.Now())
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(c.App.Writer, "revoked api key %v %s\n", apiKey.ID, apiKey.Name)
+				return nil
+			},
+		},
+	},
+}
+
+func importFile(c *cli.Context, db *gorm.DB, operation string, file string) error {
+	var importFormat model.ImportFormat
+	if format := c.String("format"); format != "" {
+		importFormat = model.ImportFormat(strings.ToUpper(format))
+		if !importFormat.IsValid() {
+			return fmt.Errorf("invalid format '%s'", format)
+		}
+	} else if f, err := graph.ImportFormatOf(file); err != nil {
+		return err
+	} else {
+		importFormat = f
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.WithValue(c.Context, graph.Context_Operation, operation)
+	res, err := graph.NewDataSource(db).ImportBooks(ctx, f, importFormat, c.Int("batch"))
+	if res != nil {
+		for _, e := range res.Errors {
+			fmt.Fprintf(c.App.ErrWriter, "row %v: %s\n", e.Row, e.Message)
+		}
+		fmt.Fprintf(c.App.Writer, "imported %v of %v rows from %s\n", res.Imported, res.Rows, file)
+	}
+	return err
+}
+
+var userCommand = &cli.Command{
+	Name:  "user",
+	Usage: "manage users",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a user and print its id, pass it to apikey create --user",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "name", Usage: "unique name of the user", Required: true},
+				&cli.StringFlag{Name: "email", Usage: "email of the user, only shown to moderators and the user"},
+			},
+			Action: func(c *cli.Context) error {
+				db, err := openDB(c)
+				if err != nil {
+					return err
+				}
+				ctx := context.WithValue(c.Context, graph.Context_Operation, "user create")
+				input := model.NewUser{Name: c.String("name")}
+				if c.IsSet("email") {
+					input.Email = graph.Of(c.String("email"))
+				}
+				user, err := graph.NewDataSource(db).CreateUser(ctx, input)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(c.App.ErrWriter, "created user %v %s\n", user.ID, user.Name)
+				fmt.Fprintln(c.App.Writer, user.ID)
+				return nil
+			},
+		},
+	},
+}
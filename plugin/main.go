@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/99designs/gqlgen/api"
 	"github.com/99designs/gqlgen/codegen/config"
@@ -12,6 +14,39 @@ import (
 	"github.com/senomas/gqlgen/plugin/gorm"
 )
 
+var uniqueIndex = regexp.MustCompile(`uniqueIndex:(\w+)`)
+
+// tenantHook adds a hidden TenantID to every model stored in the database, those with a primary
+// key, and leads every named unique index of the model with it so uniqueness holds per tenant.
+func tenantHook(b *modelgen.ModelBuild) *modelgen.ModelBuild {
+	b = gorm.MutateHook(b)
+	for _, model := range b.Models {
+		stored := false
+		tag := "size:64;not null;default:'default';index"
+		indexes := map[string]bool{}
+		for _, field := range model.Fields {
+			if strings.Contains(field.Tag, `gorm:"primaryKey`) {
+				stored = true
+			}
+			for _, m := range uniqueIndex.FindAllStringSubmatch(field.Tag, -1) {
+				if !indexes[m[1]] {
+					indexes[m[1]] = true
+					tag += ";uniqueIndex:" + m[1] + ",priority:1"
+				}
+			}
+		}
+		if stored {
+			model.Fields = append(model.Fields, &modelgen.Field{
+				Name:   "tenantId",
+				GoName: "TenantID",
+				Type:   &gorm.RefType{Name: "string"},
+				Tag:    `json:"-" gorm:"` + tag + `"`,
+			})
+		}
+	}
+	return b
+}
+
 func main() {
 	cfg, err := config.LoadConfigFromDefaultLocations()
 	if err != nil {
@@ -21,7 +56,7 @@ func main() {
 
 	err = api.Generate(cfg,
 		api.NoPlugins(),
-		api.ReplacePlugin(&modelgen.Plugin{MutateHook: gorm.MutateHook, FieldHook: gorm.FieldHook}),
+		api.ReplacePlugin(&modelgen.Plugin{MutateHook: tenantHook, FieldHook: gorm.FieldHook}),
 		api.AddPlugin(resolvergen.New()))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -2,44 +2,213 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/senomas/gographql/graph"
 	"github.com/senomas/gographql/graph/generated"
-	"gorm.io/gorm"
+	"github.com/urfave/cli/v2"
 )
 
-const defaultPort = "8088"
+var serveCommand = &cli.Command{
+	Name:  "serve",
+	Usage: "start the GraphQL server",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "port", Usage: "port to listen on, overrides $PORT"},
+		&cli.BoolFlag{Name: "reset", Usage: "drop, migrate and seed the database before serving", EnvVars: []string{"RESET"}},
+		&cli.DurationFlag{Name: "shutdown-timeout", Usage: "how long to wait for in-flight requests on SIGTERM", Value: 30 * time.Second, EnvVars: []string{"SHUTDOWN_TIMEOUT"}},
+	},
+	Action: func(c *cli.Context) error {
+		shutdownTracing, err := graph.SetupTracing(c.Context, config(c))
+		if err != nil {
+			return fmt.Errorf("tracing error %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				log.Printf("tracing shutdown error %v", err)
+			}
+		}()
 
-func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
-	}
+		var repository func() graph.Repository
+		var webhookStore graph.WebhookStore
+		var persistedQueries graphql.Cache = lru.New(int(config(c).PersistedQueryCache))
+		readiness := &graph.Readiness{Timeout: 2 * time.Second}
+		if config(c).Driver == graph.DialectMemory {
+			mem := graph.NewMemoryRepository()
+			mem.Populate()
+			repository = func() graph.Repository { return mem }
+			webhookStore = mem
+		} else {
+			db, err := openDB(c)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if sqlDB, err := db.DB(); err == nil {
+					sqlDB.Close()
+				}
+			}()
+			readiness.DB = db
+			if err := db.Use(graph.MetricsPlugin{}); err != nil {
+				return err
+			}
+			if err := db.Use(graph.TracingPlugin{}); err != nil {
+				return err
+			}
+			if err := graph.RegisterDBStats(db, config(c).Driver); err != nil {
+				return err
+			}
+			if c.Bool("reset") {
+				if err := graph.Migrate(db); err != nil {
+					return fmt.Errorf("migrate error %v", err)
+				}
+				tx := db.WithContext(c.Context).Begin()
+				if err := graph.Populate(tx); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("seed error %v", err)
+				}
+				if result := tx.Commit(); result.Error != nil {
+					return result.Error
+				}
+			}
+			repository = func() graph.Repository { return graph.NewDataSource(db) }
+			webhookStore = graph.NewDataSource(db)
+			if config(c).PersistedQueryStore == graph.PersistedQueryStoreDatabase {
+				persistedQueries = &graph.PersistedQueryStore{DB: db, Cache: persistedQueries}
+			}
+		}
 
-	var db *gorm.DB
+		// stopped before the database is closed, an attempt cut short is retried when its lease expires
+		dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+		dispatched := make(chan struct{})
+		go func() {
+			defer close(dispatched)
+			graph.NewWebhookDispatcher(config(c), webhookStore).Run(dispatchCtx)
+		}()
+		defer func() {
+			stopDispatch()
+			<-dispatched
+		}()
 
-	if _, _db, err := graph.Setup(); err != nil {
-		log.Panicf("setup database error %v", err)
-	} else {
-		db = _db
-	}
+		resolver := &graph.Resolver{Config: config(c), NewRepository: repository}
+		authorizer := graph.NewAuthorizer()
+		schema := generated.NewExecutableSchema(generated.Config{Resolvers: resolver, Directives: generated.DirectiveRoot{HasRole: graph.HasRole, Auth: authorizer.Auth}})
+		srv := handler.New(schema)
+		srv.AddTransport(transport.Websocket{KeepAlivePingInterval: 10 * time.Second})
+		srv.AddTransport(transport.Options{})
+		srv.AddTransport(transport.GET{})
+		srv.AddTransport(transport.POST{})
+		srv.AddTransport(transport.MultipartForm{})
+		srv.SetQueryCache(lru.New(1000))
+		srv.Use(extension.Introspection{})
+		srv.Use(authorizer)
+		if config(c).OperationManifest != "" {
+			manifest, err := graph.LoadOperationManifest(config(c).OperationManifest)
+			if err != nil {
+				return err
+			}
+			srv.Use(graph.OperationAllowlist{Manifest: manifest, Strict: config(c).StrictOperations})
+		}
+		srv.Use(extension.AutomaticPersistedQuery{Cache: persistedQueries})
+		srv.Use(graph.CostLimit{MaxDepth: int(config(c).MaxQueryDepth), MaxCost: int(config(c).MaxQueryCost), MaxLimit: int(config(c).MaxPageLimit)})
+		srv.Use(graph.PageLimit{MaxLimit: int(config(c).MaxPageLimit), MaxOffset: int(config(c).MaxPageOffset)})
+		var limiter *graph.RateLimiter
+		if config(c).RateLimit > 0 {
+			overrides, err := graph.ParseRateLimitOverrides(config(c).RateLimitOverrides)
+			if err != nil {
+				return err
+			}
+			limiter = graph.NewRateLimiter(float64(config(c).RateLimit), float64(config(c).RateLimitBurst))
+			srv.Use(graph.RateLimit{Limiter: limiter, Overrides: overrides})
+		}
+		srv.Use(graph.Metrics{})
+		srv.Use(graph.Tracing{})
+		srv.Use(graph.Logging{Logger: graph.DefaultLogger})
+		var export http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			repo, err := graph.RepositoryOf(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			graph.ExportHandler(repo).ServeHTTP(w, r)
+		})
+		if limiter != nil {
+			// priced like importBooks
+			export = limiter.Charge(100, export)
+		}
+		export = resolver.Middleware((&graph.APIKeyAuth{}).Middleware(export))
+		if limiter != nil {
+			export = limiter.Middleware(export)
+		}
 
-	cfg := generated.Config{Resolvers: &graph.Resolver{}}
-	cfg.Directives.Gorm = graph.Directive_Gorm
-	srv := handler.NewDefaultServer(generated.NewExecutableSchema(cfg))
-	var xsrv http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
-		r.Context()
-		srv.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), graph.Context_DataSource, graph.NewDataSource(db))))
-	}
+		mux := http.NewServeMux()
+		mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
+		var query http.Handler = resolver.Middleware((&graph.APIKeyAuth{}).Middleware(srv))
+		if limiter != nil {
+			query = limiter.Middleware(query)
+		}
+		var changes http.Handler = resolver.Middleware((&graph.APIKeyAuth{}).Middleware(graph.NewChangeFeedHandler(config(c))))
+		if limiter != nil {
+			// only the keys it verifies are charged
+			changes = limiter.Middleware(changes)
+		}
+		mux.Handle("/query", query)
+		mux.Handle("/export", export)
+		mux.Handle("/changes", changes)
+		mux.Handle("/healthz", graph.HealthHandler())
+		mux.Handle("/readyz", readiness.Handler())
+		mux.Handle("/metrics", graph.MetricsHandler())
+		mux.Handle("/version", graph.VersionHandler(graph.NewBuildInfo(version, commit, buildDate, schema.Schema())))
 
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", xsrv)
+		port := config(c).Port
+		if c.IsSet("port") {
+			port = c.String("port")
+		}
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+		// subscriptions hijack their connection, Shutdown does not wait for them, they end when the
+		// base context is cancelled after the regular requests are drained
+		baseCtx, cancelBase := context.WithCancel(context.Background())
+		defer cancelBase()
+		server := &http.Server{
+			Addr:        ":" + port,
+			Handler:     graph.TracingMiddleware(graph.DefaultLogger.Middleware(mux)),
+			BaseContext: func(net.Listener) context.Context { return baseCtx },
+		}
+
+		ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		errc := make(chan error, 1)
+		go func() {
+			log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
+			errc <- server.ListenAndServe()
+		}()
+
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+		}
+		log.Printf("shutting down, waiting up to %v for in-flight requests", c.Duration("shutdown-timeout"))
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), c.Duration("shutdown-timeout"))
+		defer cancel()
+		err = server.Shutdown(shutdownCtx)
+		cancelBase()
+		if err != nil {
+			return fmt.Errorf("shutdown error %v", err)
+		}
+		return nil
+	},
 }